@@ -15,7 +15,12 @@
 package main
 
 import (
+	"errors"
 	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"unicode"
 )
 
 // separateArgs parses command-line arguments (excluding argv[0]) meant for the tool and kubectl
@@ -41,3 +46,125 @@ func separateArgs(argv []string) (toolArgs []string, kubectlArgs []string, err e
 	}
 	return
 }
+
+// readCommandFile reads the arguments to kubectl from a file, see splitCommand.
+func readCommandFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read command file: %w", err)
+	}
+	args, err := splitCommand(string(b))
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse command file %s: %w", path, err)
+	}
+	if len(args) == 0 {
+		return nil, fmt.Errorf("command file %s has no arguments", path)
+	}
+	return args, nil
+}
+
+// splitCommand splits s into arguments with a simplified shell syntax:
+// arguments are separated by whitespace (including newlines), quotes ('...'
+// or "...") group characters into a single argument, a backslash escapes the
+// next character (except within single quotes) and words starting with '#'
+// start a comment until the end of line. No variables or globs are expanded.
+func splitCommand(s string) ([]string, error) {
+	var (
+		out     []string
+		cur     strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+		comment bool
+	)
+	for _, r := range s {
+		switch {
+		case comment:
+			if r == '\n' {
+				comment = false
+			}
+		case escaped:
+			cur.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				cur.WriteRune(r)
+			}
+		case quote == '"':
+			switch r {
+			case '"':
+				quote = 0
+			case '\\':
+				escaped = true
+			default:
+				cur.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inWord = true, true
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == '#' && !inWord:
+			comment = true
+		case unicode.IsSpace(r):
+			if inWord {
+				out = append(out, cur.String())
+				cur.Reset()
+				inWord = false
+			}
+		default:
+			cur.WriteRune(r)
+			inWord = true
+		}
+	}
+	if quote != 0 {
+		return nil, fmt.Errorf("unterminated quote (%c)", quote)
+	}
+	if escaped {
+		return nil, errors.New("trailing backslash")
+	}
+	if inWord {
+		out = append(out, cur.String())
+	}
+	return out, nil
+}
+
+// hasSeparator reports whether argv contains '--'.
+func hasSeparator(argv []string) bool {
+	for _, v := range argv {
+		if v == "--" {
+			return true
+		}
+	}
+	return false
+}
+
+// invocationName returns how the user invoked the tool, based on argv[0]. As a
+// kubectl plugin (named kubectl-foreach) it's "kubectl foreach", otherwise it's
+// the name of the executable.
+func invocationName(argv0 string) string {
+	name := filepath.Base(argv0)
+	name = strings.TrimSuffix(name, ".exe")
+	if name == "." || name == string(filepath.Separator) || name == "" {
+		return "kubectl foreach"
+	}
+	if strings.HasPrefix(name, "kubectl-") {
+		// kubectl maps dashes in plugin names to subcommands, and underscores to dashes
+		plugin := strings.TrimPrefix(name, "kubectl-")
+		return "kubectl " + strings.ReplaceAll(strings.ReplaceAll(plugin, "-", " "), "_", "-")
+	}
+	return name
+}
+
+// commandArgv returns the argv to run kubectlBin with args, prefixed with the
+// wrapper command (e.g. "time"), if any, and with the --kubeconfig flag.
+func commandArgv(wrapper, args []string) []string {
+	out := make([]string, 0, len(wrapper)+2+len(args))
+	out = append(out, wrapper...)
+	out = append(out, kubectlBin)
+	if kubeconfigPath != "" {
+		out = append(out, "--kubeconfig="+kubeconfigPath)
+	}
+	return append(out, args...)
+}
@@ -15,9 +15,12 @@
 package main
 
 import (
+	"os"
+	"path/filepath"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestSeparateArgs(t *testing.T) {
@@ -49,6 +52,14 @@ func TestSeparateArgs(t *testing.T) {
 		assert.Equal(t, []string{"a", "b"}, l)
 		assert.Equal(t, []string{"foo"}, r)
 	})
+	t.Run("plugin argv", func(t *testing.T) {
+		// kubectl runs plugins with the arguments after the plugin name
+		argv := []string{"/home/user/.krew/bin/kubectl-foreach", "-c", "2", "a", "--", "get", "pods"}
+		l, r, err := separateArgs(argv[1:])
+		assert.Nil(t, err)
+		assert.Equal(t, []string{"-c", "2", "a"}, l)
+		assert.Equal(t, []string{"get", "pods"}, r)
+	})
 	t.Run("uses the leftmost double dash", func(t *testing.T) {
 		l, r, err := separateArgs([]string{"a", "b", "--", "foo", "--", "--bar"})
 		assert.Nil(t, err)
@@ -56,3 +67,88 @@ func TestSeparateArgs(t *testing.T) {
 		assert.Equal(t, []string{"foo", "--", "--bar"}, r)
 	})
 }
+
+func Test_splitCommand(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    []string
+		wantErr bool
+	}{
+		{name: "empty", in: "", want: nil},
+		{name: "whitespace and newlines", in: " get\n\tpods  -A \n", want: []string{"get", "pods", "-A"}},
+		{name: "single quotes", in: `get pods -l 'app in (a, b)'`, want: []string{"get", "pods", "-l", "app in (a, b)"}},
+		{name: "single quotes are literal", in: `'a\b' '"'`, want: []string{`a\b`, `"`}},
+		{name: "double quotes with escapes", in: `"a \"b\" \\c"`, want: []string{`a "b" \c`}},
+		{name: "backslash escape", in: `a\ b c`, want: []string{"a b", "c"}},
+		{name: "adjacent quotes join", in: `--x='a b'"c"`, want: []string{"--x=a bc"}},
+		{name: "empty quoted arg", in: `a ''`, want: []string{"a", ""}},
+		{name: "comments", in: "# comment\nget pods # trailing\n-A", want: []string{"get", "pods", "-A"}},
+		{name: "hash inside word", in: "a#b", want: []string{"a#b"}},
+		{name: "unterminated quote", in: `a 'b`, wantErr: true},
+		{name: "trailing backslash", in: `a \`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := splitCommand(tt.in)
+			if tt.wantErr {
+				assert.Error(t, err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tt.want, got)
+		})
+	}
+}
+
+func Test_readCommandFile(t *testing.T) {
+	dir := t.TempDir()
+	f := filepath.Join(dir, "cmd.txt")
+	require.NoError(t, os.WriteFile(f, []byte("get pods\n  --selector 'app=foo'\n"), 0o644))
+	got, err := readCommandFile(f)
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"get", "pods", "--selector", "app=foo"}, got)
+
+	empty := filepath.Join(dir, "empty.txt")
+	require.NoError(t, os.WriteFile(empty, []byte("# nothing\n"), 0o644))
+	_, err = readCommandFile(empty)
+	assert.Error(t, err)
+
+	_, err = readCommandFile(filepath.Join(dir, "missing.txt"))
+	assert.Error(t, err)
+}
+
+func Test_hasSeparator(t *testing.T) {
+	assert.False(t, hasSeparator(nil))
+	assert.False(t, hasSeparator([]string{"a", "-c=1"}))
+	assert.True(t, hasSeparator([]string{"a", "--", "b"}))
+}
+
+func Test_invocationName(t *testing.T) {
+	assert.Equal(t, "kubectl foreach", invocationName("/home/user/.krew/bin/kubectl-foreach"))
+	assert.Equal(t, "kubectl foreach", invocationName("kubectl-foreach.exe"))
+	assert.Equal(t, "kubectl foreach", invocationName("kubectl-foreach"))
+	assert.Equal(t, "kubectl foreach", invocationName(""))
+	assert.Equal(t, "kubectl for each", invocationName("/bin/kubectl-for-each"))
+	assert.Equal(t, "kubectl for-each", invocationName("/bin/kubectl-for_each"))
+	assert.Equal(t, "foreach", invocationName("/usr/local/bin/foreach"))
+}
+
+func Test_commandArgv(t *testing.T) {
+	assert.Equal(t, []string{"kubectl", "--context=a", "get", "pods"},
+		commandArgv(nil, []string{"--context=a", "get", "pods"}))
+	assert.Equal(t, []string{"time", "kubectl", "--context=a", "get"},
+		commandArgv([]string{"time"}, []string{"--context=a", "get"}))
+	assert.Equal(t, []string{"strace", "-f", "-e", "trace=network", "kubectl", "version"},
+		commandArgv([]string{"strace", "-f", "-e", "trace=network"}, []string{"version"}))
+	assert.Equal(t, []string{"kubectl"}, commandArgv(nil, nil))
+
+	defer func(v string) { kubectlBin = v }(kubectlBin)
+	kubectlBin = "oc"
+	assert.Equal(t, []string{"time", "oc", "get"}, commandArgv([]string{"time"}, []string{"get"}))
+
+	defer func(v string) { kubeconfigPath = v }(kubeconfigPath)
+	kubeconfigPath = "/tmp/a b.yaml"
+	assert.Equal(t, []string{"oc", "--kubeconfig=/tmp/a b.yaml", "--context=a", "get"},
+		commandArgv(nil, []string{"--context=a", "get"}))
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// withBaseline returns kubeCtxs with the baseline context first, and without
+// it anywhere else. The context must exist in kubeconfig (in all).
+func withBaseline(baseline string, kubeCtxs, all []string) ([]string, error) {
+	if len(missingContexts([]string{baseline}, all)) > 0 {
+		return nil, fmt.Errorf("baseline context %q not found in kubeconfig", baseline)
+	}
+	out := []string{baseline}
+	for _, c := range kubeCtxs {
+		if c != baseline {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
+
+// baselineDiff returns a unified diff of the output of a context against the
+// output of the baseline context, or "" if they're the same.
+func baselineDiff(baseline, baselineOut, ctx, out string) (string, error) {
+	if out == baselineOut {
+		return "", nil
+	}
+	return difflib.GetUnifiedDiffString(difflib.UnifiedDiff{
+		A:        diffLines(baselineOut),
+		B:        diffLines(out),
+		FromFile: baseline,
+		ToFile:   ctx,
+		Context:  3,
+	})
+}
+
+// writeBaselineDiffs writes the diffs of the contexts whose command succeeded
+// with a different output than the baseline, and returns the number of those
+// contexts. Failed contexts are not compared, as their errors are printed
+// already.
+func writeBaselineDiffs(w io.Writer, base result, results []result) (int, error) {
+	var differ int
+	for _, r := range results {
+		if r.err != nil || r.stdout == nil {
+			continue
+		}
+		d, err := baselineDiff(base.context, base.stdout.String(), r.context, r.stdout.String())
+		if err != nil {
+			return differ, fmt.Errorf("failed to diff output of context %q: %w", r.context, err)
+		}
+		if d == "" {
+			continue
+		}
+		differ++
+		if _, err := io.WriteString(w, d); err != nil {
+			return differ, err
+		}
+	}
+	return differ, nil
+}
+
+// diffLines splits s into lines that all end with a newline.
+func diffLines(s string) []string {
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		return lines[:len(lines)-1]
+	}
+	lines[len(lines)-1] += "\n"
+	return lines
+}
@@ -0,0 +1,53 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_withBaseline(t *testing.T) {
+	got, err := withBaseline("b", []string{"a", "b", "c"}, []string{"a", "b", "c", "d"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b", "a", "c"}, got)
+
+	got, err = withBaseline("d", []string{"a"}, []string{"a", "d"})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"d", "a"}, got)
+
+	_, err = withBaseline("x", []string{"a"}, []string{"a"})
+	assert.Error(t, err)
+}
+
+func Test_writeBaselineDiffs(t *testing.T) {
+	out := func(s string) *bytes.Buffer { return bytes.NewBufferString(s) }
+	base := result{context: "base", stdout: out("a\nb\nc\n")}
+	results := []result{
+		{context: "same", stdout: out("a\nb\nc\n")},
+		{context: "changed", stdout: out("a\nx\nc\n")},
+		{context: "failed", stdout: out(""), err: errors.New("exit status 1")},
+	}
+	var w strings.Builder
+	n, err := writeBaselineDiffs(&w, base, results)
+	require.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "--- base\n+++ changed\n@@ -1,3 +1,3 @@\n a\n-b\n+x\n c\n", w.String())
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import "sync"
+
+// circuitBreaker counts consecutive failures of contexts per API server, and
+// opens for a server once it reaches the threshold. It's the foreach.Breaker
+// of --circuit-breaker-threshold. A nil *circuitBreaker is never open.
+type circuitBreaker struct {
+	threshold int
+	servers   map[string]string // context name -> server
+
+	mu       sync.Mutex
+	failures map[string]int
+}
+
+func newCircuitBreaker(threshold int, kc map[string]contextInfo) *circuitBreaker {
+	servers := make(map[string]string, len(kc))
+	for name, c := range kc {
+		servers[name] = c.server
+	}
+	return &circuitBreaker{threshold: threshold, servers: servers, failures: make(map[string]int)}
+}
+
+// endpoint returns the API server of the context. Contexts with unknown
+// servers are their own endpoint.
+func (c *circuitBreaker) endpoint(kctx string) string {
+	if s := c.servers[kctx]; s != "" {
+		return s
+	}
+	return "context:" + kctx
+}
+
+// Open returns why the context should be skipped, or "" if it should run.
+func (c *circuitBreaker) Open(kctx string) string {
+	if c == nil {
+		return ""
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.failures[c.endpoint(kctx)] < c.threshold {
+		return ""
+	}
+	return "too many failures on " + c.endpoint(kctx)
+}
+
+// Record records the outcome of running the command in a context.
+func (c *circuitBreaker) Record(kctx string, success bool) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if success {
+		c.failures[c.endpoint(kctx)] = 0
+	} else {
+		c.failures[c.endpoint(kctx)]++
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCircuitBreaker(t *testing.T) {
+	t.Run("nil is never open", func(t *testing.T) {
+		var c *circuitBreaker
+		c.Record("a", false)
+		assert.Empty(t, c.Open("a"))
+	})
+
+	t.Run("opens per server", func(t *testing.T) {
+		c := newCircuitBreaker(2, map[string]contextInfo{
+			"a1": {name: "a1", server: "https://a"},
+			"a2": {name: "a2", server: "https://a"},
+			"a3": {name: "a3", server: "https://a"},
+			"b":  {name: "b", server: "https://b"},
+		})
+		c.Record("a1", false)
+		assert.Empty(t, c.Open("a3"))
+		c.Record("a2", false)
+		assert.Equal(t, "too many failures on https://a", c.Open("a3"))
+		assert.Empty(t, c.Open("b"))
+	})
+
+	t.Run("success resets", func(t *testing.T) {
+		c := newCircuitBreaker(2, map[string]contextInfo{
+			"a1": {name: "a1", server: "https://a"},
+			"a2": {name: "a2", server: "https://a"},
+		})
+		c.Record("a1", false)
+		c.Record("a2", true)
+		c.Record("a1", false)
+		assert.Empty(t, c.Open("a2"))
+	})
+
+	t.Run("unknown server", func(t *testing.T) {
+		c := newCircuitBreaker(1, nil)
+		c.Record("x", false)
+		assert.NotEmpty(t, c.Open("x"))
+		assert.Empty(t, c.Open("y"))
+	})
+}
@@ -0,0 +1,66 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+// checkArgs are the kubectl arguments of the --check probe, a cheap request
+// that fails quickly if the API server of a context can't be reached.
+var checkArgs = []string{"version", "--request-timeout=5s"}
+
+// writeCheck writes whether the API server of each context responded to the
+// --check probe, and how long it took.
+func writeCheck(w io.Writer, results []result) error {
+	names := make([]string, len(results))
+	for i, r := range results {
+		names[i] = r.context
+	}
+	ctxWidth := maxLen(append(names, "CONTEXT"))
+	statusWidth := len("unreachable")
+	var b strings.Builder
+	fmt.Fprintf(&b, "%-*s  %-*s  %s\n", ctxWidth, "CONTEXT", statusWidth, "STATUS", "DURATION")
+	for _, r := range results {
+		// padded before coloring, so that escape codes don't misalign the columns
+		var status string
+		switch r.status() {
+		case statusSucceeded:
+			status = chalk.Green(fmt.Sprintf("%-*s", statusWidth, "reachable"))
+		case statusSkipped:
+			status = gray(fmt.Sprintf("%-*s", statusWidth, "skipped"))
+		default:
+			status = red(fmt.Sprintf("%-*s", statusWidth, "unreachable"))
+		}
+		fmt.Fprintf(&b, "%-*s  %s  %s\n", ctxWidth, r.context, status, r.duration.Round(time.Millisecond))
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// unreachableCount returns the number of contexts that failed the --check
+// probe.
+func unreachableCount(results []result) int {
+	n := 0
+	for _, r := range results {
+		if r.err != nil {
+			n++
+		}
+	}
+	return n
+}
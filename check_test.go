@@ -0,0 +1,44 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWriteCheck(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+	results := []result{
+		{context: "prod-us", duration: 212 * time.Millisecond},
+		{context: "prod-eu", err: errors.New("exit status 1"), duration: 5003 * time.Millisecond},
+		{context: "dev", err: foreach.ErrSkipped},
+	}
+	var buf bytes.Buffer
+	assert.NoError(t, writeCheck(&buf, results))
+	assert.Equal(t, `CONTEXT  STATUS       DURATION
+prod-us  reachable    212ms
+prod-eu  unreachable  5.003s
+dev      skipped      0s
+`, buf.String())
+	assert.Equal(t, 2, unreachableCount(results))
+}
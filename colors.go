@@ -14,43 +14,114 @@
 
 package main
 
-var colors = []func(string, ...interface{}) string{
+import (
+	"hash/fnv"
+	"math/rand"
+
+	"github.com/jwalton/gchalk"
+)
+
+// colorStyles are the gchalk styles of context names in the output prefix,
+// assigned to the contexts by the hash of their name, or in the order they are
+// run with --color-by=index.
+var colorStyles = [][]string{
 	// foreground only
-	chalk.WithRed().Sprintf,
-	chalk.WithBlue().Sprintf,
-	chalk.WithGreen().Sprintf,
-	chalk.WithYellow().WithBgBlack().Sprintf,
-	chalk.WithGray().Sprintf,
-	chalk.WithMagenta().Sprintf,
-	chalk.WithCyan().Sprintf,
-	chalk.WithBrightRed().Sprintf,
-
-	chalk.WithBrightBlue().Sprintf,
-	chalk.WithBrightGreen().Sprintf,
-	chalk.WithBrightMagenta().Sprintf,
-	chalk.WithBrightYellow().WithBgBlack().Sprintf,
-	chalk.WithBrightCyan().Sprintf,
+	{"red"},
+	{"blue"},
+	{"green"},
+	{"yellow", "bgBlack"},
+	{"gray"},
+	{"magenta"},
+	{"cyan"},
+	{"brightRed"},
+
+	{"brightBlue"},
+	{"brightGreen"},
+	{"brightMagenta"},
+	{"brightYellow", "bgBlack"},
+	{"brightCyan"},
 
 	// inverse
-	chalk.WithBgRed().WithWhite().Sprintf,
-	chalk.WithBgBlue().WithWhite().Sprintf,
-	chalk.WithBgCyan().WithBlack().Sprintf,
-	chalk.WithBgGreen().WithBlack().Sprintf,
-	chalk.WithBgMagenta().WithBrightWhite().Sprintf,
-	chalk.WithBgYellow().WithBlack().Sprintf,
-	chalk.WithBgGray().WithWhite().Sprintf,
-	chalk.WithBgBrightRed().WithWhite().Sprintf,
-	chalk.WithBgBrightBlue().WithWhite().Sprintf,
-	chalk.WithBgBrightCyan().WithBlack().Sprintf,
-	chalk.WithBgBrightGreen().WithBlack().Sprintf,
-	chalk.WithBgBrightMagenta().WithBlack().Sprintf,
-	chalk.WithBgBrightYellow().WithBlack().Sprintf,
+	{"bgRed", "white"},
+	{"bgBlue", "white"},
+	{"bgCyan", "black"},
+	{"bgGreen", "black"},
+	{"bgMagenta", "brightWhite"},
+	{"bgYellow", "black"},
+	{"bgGray", "white"},
+	{"bgBrightRed", "white"},
+	{"bgBrightBlue", "white"},
+	{"bgBrightCyan", "black"},
+	{"bgBrightGreen", "black"},
+	{"bgBrightMagenta", "black"},
+	{"bgBrightYellow", "black"},
 
 	// mixes+inverses
-	chalk.WithBgRed().WithYellow().Sprintf,
-	chalk.WithBgYellow().WithRed().Sprintf,
-	chalk.WithBgBlue().WithYellow().Sprintf,
-	chalk.WithBgYellow().WithBlue().Sprintf,
-	chalk.WithBgBlack().WithBrightWhite().Sprintf,
-	chalk.WithBgBrightWhite().WithBlack().Sprintf,
+	{"bgRed", "yellow"},
+	{"bgYellow", "red"},
+	{"bgBlue", "yellow"},
+	{"bgYellow", "blue"},
+	{"bgBlack", "brightWhite"},
+	{"bgBrightWhite", "black"},
+}
+
+var colors = styleFuncs(chalk, colorStyles)
+
+func styleFuncs(b *gchalk.Builder, styles [][]string) []func(string, ...interface{}) string {
+	out := make([]func(string, ...interface{}) string, len(styles))
+	for i, s := range styles {
+		out[i] = b.WithStyleMust(s...).Sprintf
+	}
+	return out
+}
+
+// disableColors makes the colors of context names and the messages of the
+// tool plain strings.
+func disableColors() {
+	chalk.SetLevel(gchalk.LevelNone)
+}
+
+// shuffleColors shuffles the order colors are assigned to contexts in, so that
+// runs with the same seed assign the same colors.
+func shuffleColors(seed int64) {
+	colorStyles = shuffledStyles(colorStyles, seed)
+	colors = styleFuncs(chalk, colorStyles)
+}
+
+func shuffledStyles(styles [][]string, seed int64) [][]string {
+	out := make([][]string, len(styles))
+	copy(out, styles)
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
+
+// colorIndex returns the index in colors of the ith context, kctx. With
+// --color-by=name, it's the FNV-1a hash of the name, so that a context gets
+// the same color regardless of the other matched contexts, though two
+// contexts can get the same color.
+func colorIndex(i int, kctx string) int {
+	if *colorBy == "index" {
+		return i % len(colorStyles)
+	}
+	h := fnv.New32a()
+	h.Write([]byte(kctx))
+	return int(h.Sum32() % uint32(len(colorStyles)))
+}
+
+// focused is the set of contexts matched by --focus, or nil without it.
+var focused map[string]bool
+
+var dimmed = chalk.WithStyleMust("gray", "dim").Sprintf
+
+// focusColor returns the color of kctx with --focus: colFn in bold for the
+// focused contexts, and dimmed gray for the others.
+func focusColor(colFn func(string, ...interface{}) string, kctx string) func(string, ...interface{}) string {
+	if focused == nil {
+		return colFn
+	}
+	if !focused[kctx] {
+		return dimmed
+	}
+	return func(format string, a ...interface{}) string { return chalk.Bold(colFn(format, a...)) }
 }
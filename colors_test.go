@@ -0,0 +1,67 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_shuffledStyles(t *testing.T) {
+	got := shuffledStyles(colorStyles, 42)
+	assert.Equal(t, got, shuffledStyles(colorStyles, 42))
+	assert.NotEqual(t, colorStyles, got)
+	assert.ElementsMatch(t, colorStyles, got)
+	assert.Equal(t, []string{"red"}, colorStyles[0], "input is not modified")
+}
+
+func Test_colorIndex(t *testing.T) {
+	defer func(v string) { *colorBy = v }(*colorBy)
+	*colorBy = "name"
+	i := colorIndex(0, "prod-us-east")
+	assert.Equal(t, i, colorIndex(7, "prod-us-east"), "doesn't depend on the position")
+	assert.True(t, i >= 0 && i < len(colorStyles))
+
+	*colorBy = "index"
+	assert.Equal(t, 1, colorIndex(1, "prod-us-east"))
+	assert.Equal(t, 0, colorIndex(len(colorStyles), "prod-us-east"))
+}
+
+func Test_disableColors(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelBasic)
+	assert.NotEqual(t, "a", colors[0]("a"))
+
+	disableColors()
+	assert.Equal(t, "a", colors[0]("a"))
+	assert.Equal(t, "a", gray("a"))
+	assert.Equal(t, "a", red("a"))
+}
+
+func Test_focusColor(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	defer func(v map[string]bool) { focused = v }(focused)
+	chalk.SetLevel(gchalk.LevelBasic)
+
+	focused = nil
+	assert.Equal(t, colors[0]("a"), focusColor(colors[0], "a")("a"))
+
+	focused = map[string]bool{"a": true}
+	assert.Equal(t, chalk.Bold(colors[0]("a")), focusColor(colors[0], "a")("a"))
+	assert.Equal(t, dimmed("b"), focusColor(colors[0], "b")("b"))
+	assert.NotEqual(t, colors[0]("b"), dimmed("b"))
+}
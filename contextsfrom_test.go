@@ -0,0 +1,42 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReadContextsFrom(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "contexts.txt")
+	require.NoError(t, os.WriteFile(path, []byte("# fleet\nprod-us\nprod-eu\n\nprod-us\n"), 0o644))
+	got, err := readContextsFrom(path, nil)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod-us", "prod-eu"}, got)
+
+	got, err = readContextsFrom("-", strings.NewReader(`["a", "b"]`))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	_, err = readContextsFrom("-", strings.NewReader("\n# none\n"))
+	assert.Error(t, err)
+	_, err = readContextsFrom(filepath.Join(t.TempDir(), "missing"), nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// controlPollInterval is how often the --control-file is read.
+const controlPollInterval = time.Second
+
+// readControlFile reads the concurrency limit in the --control-file.
+func readControlFile(path string) (int, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read control file: %w", err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(string(b)))
+	if err != nil || n < 0 {
+		return 0, fmt.Errorf("invalid concurrency %q in control file %s: need a number >= 0", strings.TrimSpace(string(b)), path)
+	}
+	return n, nil
+}
+
+// watchControlFile reads the limit of s from the file every interval until ctx
+// is done. Changes and invalid values are reported to w, and invalid values
+// keep the previous limit.
+func watchControlFile(ctx context.Context, path string, interval time.Duration, s *foreach.Semaphore, w io.Writer) {
+	t := time.NewTicker(interval)
+	defer t.Stop()
+	var lastErr string
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		n, err := readControlFile(path)
+		if err != nil {
+			if err.Error() != lastErr {
+				fmt.Fprintln(w, gray(fmt.Sprintf("warning: %v, keeping the current concurrency", err)))
+				lastErr = err.Error()
+			}
+			continue
+		}
+		lastErr = ""
+		if s.Limit() != n {
+			s.SetLimit(n)
+			if n == 0 {
+				fmt.Fprintln(w, gray("concurrency changed to unlimited (--control-file)"))
+			} else {
+				fmt.Fprintln(w, gray(fmt.Sprintf("concurrency changed to %d (--control-file)", n)))
+			}
+		}
+	}
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readControlFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	_, err := readControlFile(path)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	for in, want := range map[string]int{"5\n": 5, " 0 ": 0} {
+		require.NoError(t, os.WriteFile(path, []byte(in), 0o644))
+		n, err := readControlFile(path)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, n, in)
+	}
+	for _, in := range []string{"", "-1", "five"} {
+		require.NoError(t, os.WriteFile(path, []byte(in), 0o644))
+		_, err := readControlFile(path)
+		assert.Error(t, err, in)
+	}
+}
+
+func Test_watchControlFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "control")
+	s := foreach.NewSemaphore(1)
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		watchControlFile(ctx, path, 10*time.Millisecond, s, &synchronizedWriter{Writer: &out})
+		close(done)
+	}()
+	require.NoError(t, os.WriteFile(path, []byte("4\n"), 0o644))
+	assert.Eventually(t, func() bool { return s.Limit() == 4 }, time.Second, 5*time.Millisecond)
+	require.NoError(t, os.WriteFile(path, []byte("x\n"), 0o644))
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 4, s.Limit(), "invalid values are ignored")
+
+	cancel()
+	<-done
+	assert.Contains(t, out.String(), "concurrency changed to 4")
+	assert.Contains(t, out.String(), `warning: invalid concurrency "x"`)
+}
@@ -0,0 +1,47 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+)
+
+// writeCSV writes the results as CSV with a header row. Durations are in
+// seconds. The output_hash column is added with --output-hash.
+func writeCSV(w io.Writer, results []result) error {
+	cw := csv.NewWriter(w)
+	header := []string{"context", "status", "exit_code", "duration", "output_lines"}
+	if *outputHash {
+		header = append(header, "output_hash")
+	}
+	cw.Write(header)
+	for _, r := range results {
+		row := []string{
+			r.context,
+			r.status(),
+			strconv.Itoa(r.exitCode()),
+			strconv.FormatFloat(r.duration.Seconds(), 'f', 3, 64),
+			strconv.Itoa(r.outputLines),
+		}
+		if *outputHash {
+			row = append(row, r.hash)
+		}
+		cw.Write(row)
+	}
+	cw.Flush()
+	return cw.Error()
+}
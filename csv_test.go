@@ -0,0 +1,55 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeCSV(t *testing.T) {
+	var b bytes.Buffer
+	require.NoError(t, writeCSV(&b, []result{
+		{context: "a", duration: 1500 * time.Millisecond, outputLines: 3},
+		{context: `prod,"eu"`, err: errors.New("phony error")},
+	}))
+
+	rows, err := csv.NewReader(&b).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"context", "status", "exit_code", "duration", "output_lines"},
+		{"a", "succeeded", "0", "1.500", "3"},
+		{`prod,"eu"`, "failed", "-1", "0.000", "0"},
+	}, rows)
+}
+
+func Test_writeCSV_hash(t *testing.T) {
+	defer func(v bool) { *outputHash = v }(*outputHash)
+	*outputHash = true
+	var b bytes.Buffer
+	require.NoError(t, writeCSV(&b, []result{{context: "a", hash: "abc"}}))
+	rows, err := csv.NewReader(&b).ReadAll()
+	require.NoError(t, err)
+	assert.Equal(t, [][]string{
+		{"context", "status", "exit_code", "duration", "output_lines", "output_hash"},
+		{"a", "succeeded", "0", "0.000", "0", "abc"},
+	}, rows)
+}
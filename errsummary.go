@@ -0,0 +1,101 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// errorGroup is an error message and the contexts that failed with it.
+type errorGroup struct {
+	message  string
+	contexts []string
+}
+
+// serverURLPattern matches API server URLs in error messages.
+var serverURLPattern = regexp.MustCompile(`https?://[^\s"']*[^\s"'.,:;)]`)
+
+// errorMessage returns the last line of the stderr of a failed context, or
+// the error of the command if it printed nothing.
+func errorMessage(r result) string {
+	if r.stderr != nil {
+		lines := strings.Split(strings.TrimSpace(r.stderr.String()), "\n")
+		if l := strings.TrimSpace(lines[len(lines)-1]); l != "" {
+			return l
+		}
+	}
+	if u := errors.Unwrap(r.err); u != nil {
+		return u.Error()
+	}
+	return r.err.Error()
+}
+
+// normalizeError replaces the parts of an error message specific to a context
+// (its name and API server URL) so that the same errors in different contexts
+// are equal.
+func normalizeError(msg, ctx string) string {
+	msg = serverURLPattern.ReplaceAllString(msg, "<server>")
+	name := regexp.MustCompile(`(^|[^\w.:@/-])` + regexp.QuoteMeta(ctx) + `($|[^\w.@/-])`)
+	return name.ReplaceAllString(msg, "${1}<context>${2}")
+}
+
+// groupErrors groups the failed contexts by their normalized error message,
+// with the most common errors first. If all is true, each context gets its
+// own group with its error as is.
+func groupErrors(results []result, all bool) []errorGroup {
+	var out []errorGroup
+	index := make(map[string]int)
+	for _, r := range results {
+		if r.status() != statusFailed {
+			continue
+		}
+		msg := errorMessage(r)
+		if all {
+			out = append(out, errorGroup{message: msg, contexts: []string{r.context}})
+			continue
+		}
+		msg = normalizeError(msg, r.context)
+		if i, ok := index[msg]; ok {
+			out[i].contexts = append(out[i].contexts, r.context)
+			continue
+		}
+		index[msg] = len(out)
+		out = append(out, errorGroup{message: msg, contexts: []string{r.context}})
+	}
+	sort.SliceStable(out, func(i, j int) bool { return len(out[i].contexts) > len(out[j].contexts) })
+	return out
+}
+
+// writeErrorSummary writes each error group with the contexts that failed
+// with it.
+func writeErrorSummary(w io.Writer, groups []errorGroup) {
+	if len(groups) == 0 {
+		return
+	}
+	var n int
+	for _, g := range groups {
+		n += len(g.contexts)
+	}
+	fmt.Fprintf(w, "%s\n", gray(fmt.Sprintf("errors in %d context(s):", n)))
+	for _, g := range groups {
+		fmt.Fprintf(w, "  %s %s\n", red(g.message), gray(fmt.Sprintf("(%d)", len(g.contexts))))
+		fmt.Fprintf(w, "    %s\n", strings.Join(g.contexts, ", "))
+	}
+}
@@ -0,0 +1,72 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+)
+
+func failed(ctx, stderr string) result {
+	r := result{context: ctx, err: fmt.Errorf("command failed in context %q: %w", ctx, &exec.ExitError{})}
+	if stderr != "" {
+		r.stderr = new(bytes.Buffer)
+		_, _ = r.stderr.Write([]byte(stderr))
+	}
+	return r
+}
+
+func Test_normalizeError(t *testing.T) {
+	assert.Equal(t, `Unable to connect to the server: dial tcp: lookup <server>: no such host`,
+		normalizeError(`Unable to connect to the server: dial tcp: lookup https://10.0.0.1:443/api: no such host`, "prod"))
+	assert.Equal(t, `context "<context>" not found in prod-us`, normalizeError(`context "prod" not found in prod-us`, "prod"))
+	assert.Equal(t, `<context>: forbidden`, normalizeError(`prod: forbidden`, "prod"))
+}
+
+func Test_groupErrors(t *testing.T) {
+	results := []result{
+		failed("a", "warning\nError from server (Forbidden): pods is forbidden\n"),
+		{context: "ok"},
+		failed("b", "error: context b is unauthorized\n"),
+		failed("c", "Error from server (Forbidden): pods is forbidden\n"),
+		{context: "s", err: fmt.Errorf("context %q: %w", "s", foreach.ErrSkipped)},
+		{context: "e", err: fmt.Errorf("command printed nothing in context %q: %w", "e", foreach.ErrEmptyOutput)},
+	}
+	assert.Equal(t, []errorGroup{
+		{message: "Error from server (Forbidden): pods is forbidden", contexts: []string{"a", "c"}},
+		{message: "error: context <context> is unauthorized", contexts: []string{"b"}},
+		{message: "empty output", contexts: []string{"e"}},
+	}, groupErrors(results, false))
+
+	all := groupErrors(results, true)
+	assert.Len(t, all, 4)
+	assert.Equal(t, errorGroup{message: "error: context b is unauthorized", contexts: []string{"b"}}, all[1])
+}
+
+func Test_writeErrorSummary(t *testing.T) {
+	var b strings.Builder
+	writeErrorSummary(&b, nil)
+	assert.Empty(t, b.String())
+
+	writeErrorSummary(&b, []errorGroup{{message: "boom", contexts: []string{"a", "b"}}})
+	assert.Contains(t, b.String(), "errors in 2 context(s):")
+	assert.Contains(t, b.String(), "a, b")
+}
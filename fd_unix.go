@@ -0,0 +1,40 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// fdsSupported reports whether --fd-per-context can be used on this platform.
+const fdsSupported = true
+
+// inheritedFiles returns the file descriptors from 3 on that were opened by
+// the parent process (e.g. "3>a.log 4>b.log" in shells), up to the first one
+// that's not open. It must be called before the tool opens any files.
+func inheritedFiles() []*os.File {
+	var out []*os.File
+	for fd := 3; ; fd++ {
+		var st syscall.Stat_t
+		if err := syscall.Fstat(fd, &st); err != nil {
+			return out
+		}
+		out = append(out, os.NewFile(uintptr(fd), fmt.Sprintf("fd%d", fd)))
+	}
+}
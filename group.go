@@ -0,0 +1,62 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"sort"
+)
+
+// groupOther is the group of contexts that don't match the --group-by pattern.
+const groupOther = "other"
+
+// groupKey returns the first submatch of re in the context name (or the whole
+// match if re has no groups), or groupOther if it doesn't match.
+func groupKey(re *regexp.Regexp, ctx string) string {
+	m := re.FindStringSubmatch(ctx)
+	switch {
+	case m == nil:
+		return groupOther
+	case len(m) > 1:
+		return m[1]
+	default:
+		return m[0]
+	}
+}
+
+// groupCount is the number of contexts in a group.
+type groupCount struct {
+	key   string
+	count int
+}
+
+// groupCounts returns the number of contexts in each group, largest first.
+func groupCounts(re *regexp.Regexp, kubeCtxs []string) []groupCount {
+	m := make(map[string]int)
+	for _, c := range kubeCtxs {
+		m[groupKey(re, c)]++
+	}
+	out := make([]groupCount, 0, len(m))
+	for k, v := range m {
+		out = append(out, groupCount{key: k, count: v})
+	}
+	sort.Slice(out, func(i, j int) bool {
+		if out[i].count != out[j].count {
+			return out[i].count > out[j].count
+		}
+		return out[i].key < out[j].key
+	})
+	return out
+}
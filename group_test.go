@@ -0,0 +1,41 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_groupKey(t *testing.T) {
+	re := regexp.MustCompile(`^(prod|staging)-`)
+	assert.Equal(t, "prod", groupKey(re, "prod-us"))
+	assert.Equal(t, "staging", groupKey(re, "staging-eu"))
+	assert.Equal(t, groupOther, groupKey(re, "dev-us"))
+
+	assert.Equal(t, "-us", groupKey(regexp.MustCompile(`-[a-z]+$`), "prod-us"), "whole match without groups")
+}
+
+func Test_groupCounts(t *testing.T) {
+	re := regexp.MustCompile(`^([a-z]+)-`)
+	assert.Equal(t, []groupCount{
+		{key: "prod", count: 3},
+		{key: "dev", count: 1},
+		{key: "staging", count: 1},
+	}, groupCounts(re, []string{"prod-a", "staging-a", "prod-b", "dev-a", "prod-c"}))
+	assert.Empty(t, groupCounts(re, nil))
+}
@@ -0,0 +1,110 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"html/template"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+var htmlReportTmpl = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>kubectl foreach report</title>
+<style>
+body { font-family: sans-serif; margin: 2em; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+th, td { border: 1px solid #ccc; padding: 4px 8px; text-align: left; }
+pre { background: #f6f6f6; padding: 8px; overflow-x: auto; }
+summary { cursor: pointer; font-weight: bold; padding: 4px 0; }
+.succeeded { color: #1a7f37; }
+.failed { color: #cf222e; }
+.skipped, .unreachable { color: #6e7781; }
+</style>
+</head>
+<body>
+<h1>kubectl foreach</h1>
+<p><code>{{.Command}}</code><br>Generated at {{.Time}}</p>
+<table>
+<tr><th>Context</th><th>Status</th><th>Exit code</th><th>Duration</th></tr>
+{{- range .Results}}
+<tr><td><a href="#ctx-{{.Index}}">{{.Context}}</a></td><td class="{{.Status}}">{{.Status}}{{if .Reason}} ({{.Reason}}){{end}}</td><td>{{.ExitCode}}</td><td>{{.Duration}}</td></tr>
+{{- end}}
+</table>
+{{- range .Results}}
+<details id="ctx-{{.Index}}"{{if ne .Status "succeeded"}} open{{end}}>
+<summary class="{{.Status}}">{{.Context}} ({{.Status}})</summary>
+{{- if .Error}}
+<p class="{{.Status}}">{{.Error}}</p>
+{{- end}}
+<pre>{{.Output}}</pre>
+</details>
+{{- end}}
+</body>
+</html>
+`))
+
+// writeHTMLReport writes a self-contained HTML page with the results.
+func writeHTMLReport(w io.Writer, args []string, results []result) error {
+	type row struct {
+		Index                                            int
+		Context, Status, Reason, Duration, Error, Output string
+		ExitCode                                         int
+	}
+	data := struct {
+		Command string
+		Time    string
+		Results []row
+	}{
+		Command: "kubectl " + strings.Join(args, " "),
+		Time:    time.Now().Format(time.RFC3339),
+	}
+	for i, r := range results {
+		v := row{
+			Index:    i,
+			Context:  r.context,
+			Status:   r.status(),
+			Reason:   r.reason,
+			ExitCode: r.exitCode(),
+			Duration: r.duration.Round(time.Millisecond).String(),
+		}
+		if r.err != nil {
+			v.Error = r.err.Error()
+		}
+		if r.output != nil {
+			v.Output = r.output.String()
+		}
+		data.Results = append(data.Results, v)
+	}
+	return htmlReportTmpl.Execute(w, data)
+}
+
+// saveHTMLReport writes the HTML report from writeHTMLReport to a file.
+func saveHTMLReport(path string, args []string, results []result) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create html report: %w", err)
+	}
+	if err := writeHTMLReport(f, args, results); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write html report: %w", err)
+	}
+	return f.Close()
+}
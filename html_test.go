@@ -0,0 +1,48 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeHTMLReport(t *testing.T) {
+	out := new(bytes.Buffer)
+	fmt.Fprint(out, "<b>pod-1</b>\n")
+	results := []result{
+		{context: "ok-ctx", duration: 1500 * time.Millisecond, output: out},
+		{context: "bad-ctx", err: errors.New("phony error"), reason: foreach.ReasonForbidden, output: new(bytes.Buffer)},
+	}
+	var b strings.Builder
+	require.NoError(t, writeHTMLReport(&b, []string{"get", "pods"}, results))
+	s := b.String()
+
+	assert.Contains(t, s, "<code>kubectl get pods</code>")
+	assert.Contains(t, s, `<td><a href="#ctx-0">ok-ctx</a></td><td class="succeeded">succeeded</td><td>0</td><td>1.5s</td>`)
+	assert.Contains(t, s, `<td class="failed">failed (forbidden)</td><td>-1</td>`)
+	assert.Contains(t, s, "&lt;b&gt;pod-1&lt;/b&gt;", "output should be escaped")
+	assert.Contains(t, s, `<details id="ctx-1" open>`, "failed contexts should be expanded")
+	assert.Contains(t, s, "phony error")
+	assert.NotContains(t, s, "<script src", "should not have external dependencies")
+}
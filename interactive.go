@@ -0,0 +1,178 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/term"
+)
+
+// patternEditor lets the user add or remove the patterns at the confirmation
+// prompt, and keeps the contexts they match.
+type patternEditor struct {
+	patterns []string
+	rematch  func([]string) ([]string, error)
+	matches  []string
+}
+
+// edit reads changes to the patterns from s until an empty line: "+PATTERN"
+// adds a pattern and "-N" removes the Nth pattern. The contexts are matched
+// again after each change, and a change is undone if the patterns are invalid.
+func (e *patternEditor) edit(s *bufio.Scanner, out io.Writer) {
+	for {
+		e.printPatterns(out)
+		fmt.Fprint(out, "Add (+PATTERN), remove (-N) or press enter when done: ")
+		if !s.Scan() {
+			return
+		}
+		v := strings.TrimSpace(s.Text())
+		if v == "" {
+			return
+		}
+		patterns, err := e.change(v)
+		if err == nil {
+			var matches []string
+			if matches, err = e.rematch(patterns); err == nil {
+				e.patterns, e.matches = patterns, matches
+				fmt.Fprintf(out, "%d context(s) matched\n", len(matches))
+				continue
+			}
+		}
+		fmt.Fprintln(out, red("error: ")+err.Error())
+	}
+}
+
+// change returns the patterns after the change v.
+func (e *patternEditor) change(v string) ([]string, error) {
+	switch v[0] {
+	case '+':
+		p := strings.TrimSpace(v[1:])
+		if p == "" {
+			return nil, fmt.Errorf("empty pattern")
+		}
+		return append(e.patterns[:len(e.patterns):len(e.patterns)], p), nil
+	case '-':
+		n, err := strconv.Atoi(v[1:])
+		if err != nil || n < 1 || n > len(e.patterns) {
+			return nil, fmt.Errorf("no pattern numbered %q", v[1:])
+		}
+		out := make([]string, 0, len(e.patterns)-1)
+		out = append(out, e.patterns[:n-1]...)
+		return append(out, e.patterns[n:]...), nil
+	default:
+		return nil, fmt.Errorf("invalid change %q, use +PATTERN or -N", v)
+	}
+}
+
+func (e *patternEditor) printPatterns(out io.Writer) {
+	if len(e.patterns) == 0 {
+		fmt.Fprintln(out, "No patterns.")
+		return
+	}
+	fmt.Fprintln(out, "Patterns:")
+	for i, p := range e.patterns {
+		fmt.Fprintf(out, "  %d) %s\n", i+1, p)
+	}
+}
+
+// stdinIsTerminal reports whether stdin is a terminal. Other character
+// devices, such as /dev/null in cron jobs, are not.
+func stdinIsTerminal() bool {
+	return term.IsTerminal(int(os.Stdin.Fd()))
+}
+
+// lineFeed reads the lines of r in the background and hands each line to one
+// of its readers, so that the lines not read by the confirmation prompt are
+// left for the tail viewer, instead of being lost in the buffer of the prompt.
+type lineFeed struct {
+	lines chan string
+	done  chan struct{} // closed once r is read to the end
+	err   error         // error of reading r, set before done is closed
+	all   *lineFeedReader
+}
+
+func newLineFeed(r io.Reader) *lineFeed {
+	f := &lineFeed{lines: make(chan string), done: make(chan struct{})}
+	f.all = &lineFeedReader{feed: f}
+	go func() {
+		defer close(f.done)
+		s := bufio.NewScanner(r)
+		for s.Scan() {
+			f.lines <- s.Text()
+		}
+		f.err = s.Err()
+	}()
+	return f
+}
+
+// Read implements io.Reader, for readers that read until the end.
+func (f *lineFeed) Read(p []byte) (int, error) {
+	return f.all.Read(p)
+}
+
+// reader returns a reader of the next lines, which returns io.EOF once stop is
+// closed, leaving the lines it hasn't read to the next reader.
+func (f *lineFeed) reader(stop <-chan struct{}) io.Reader {
+	return &lineFeedReader{feed: f, stop: stop}
+}
+
+type lineFeedReader struct {
+	feed *lineFeed
+	stop <-chan struct{}
+	buf  []byte // rest of the line being read
+}
+
+func (r *lineFeedReader) Read(p []byte) (int, error) {
+	if len(r.buf) == 0 {
+		select {
+		case <-r.stop: // before taking a line that is ready
+			return 0, io.EOF
+		default:
+		}
+		select {
+		case <-r.stop:
+			return 0, io.EOF
+		case <-r.feed.done:
+			if r.feed.err != nil {
+				return 0, r.feed.err
+			}
+			return 0, io.EOF
+		case l := <-r.feed.lines:
+			r.buf = []byte(l + "\n")
+		}
+	}
+	n := copy(p, r.buf)
+	r.buf = r.buf[n:]
+	return n, nil
+}
+
+var (
+	stdinFeedOnce sync.Once
+	stdinLines    *lineFeed
+)
+
+// stdinFeed returns the lineFeed of stdin, shared by everything that reads
+// answers of the user from stdin.
+func stdinFeed() *lineFeed {
+	stdinFeedOnce.Do(func() { stdinLines = newLineFeed(os.Stdin) })
+	return stdinLines
+}
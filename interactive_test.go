@@ -0,0 +1,105 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestPatternEditor(t *testing.T) {
+	rematch := func(p []string) ([]string, error) {
+		for _, v := range p {
+			if v == "bad" {
+				return nil, fmt.Errorf("invalid pattern %q", v)
+			}
+		}
+		return append([]string{"ctx"}, p...), nil
+	}
+	edit := func(patterns []string, input string) (*patternEditor, string) {
+		var out bytes.Buffer
+		e := &patternEditor{patterns: patterns, rematch: rematch}
+		e.edit(bufio.NewScanner(strings.NewReader(input)), &out)
+		return e, out.String()
+	}
+
+	t.Run("add and remove", func(t *testing.T) {
+		e, out := edit([]string{"a", "b"}, "+c\n-1\n\nignored\n")
+		assert.Equal(t, []string{"b", "c"}, e.patterns)
+		assert.Equal(t, []string{"ctx", "b", "c"}, e.matches)
+		assert.Contains(t, out, "Patterns:\n  1) a\n  2) b\n")
+		assert.Contains(t, out, "3 context(s) matched\n")
+		assert.True(t, strings.HasSuffix(out, "Patterns:\n  1) b\n  2) c\n"+
+			"Add (+PATTERN), remove (-N) or press enter when done: "), out)
+	})
+	t.Run("remove all", func(t *testing.T) {
+		e, out := edit([]string{"a"}, "-1\n")
+		assert.Empty(t, e.patterns)
+		assert.Equal(t, []string{"ctx"}, e.matches)
+		assert.Contains(t, out, "No patterns.\n")
+	})
+	t.Run("invalid changes are undone", func(t *testing.T) {
+		for _, in := range []string{"+bad", "-0", "-2", "-x", "+ ", "a"} {
+			e, out := edit([]string{"a"}, in+"\n\n")
+			assert.Equal(t, []string{"a"}, e.patterns, in)
+			assert.Nil(t, e.matches, in)
+			assert.Contains(t, out, "error: ", in)
+		}
+	})
+	t.Run("does not modify the initial patterns", func(t *testing.T) {
+		patterns := make([]string, 1, 2)
+		patterns[0] = "a"
+		e, _ := edit(patterns, "+b\n-1\n")
+		assert.Equal(t, []string{"a"}, patterns[:1])
+		assert.Equal(t, []string{"b"}, e.patterns)
+	})
+	t.Run("eof", func(t *testing.T) {
+		e := &patternEditor{patterns: []string{"a"}, rematch: rematch}
+		e.edit(bufio.NewScanner(strings.NewReader("+b")), io.Discard)
+		assert.Equal(t, []string{"a", "b"}, e.patterns)
+	})
+}
+
+func TestLineFeed(t *testing.T) {
+	f := newLineFeed(strings.NewReader("first\nsecond\nthird\n"))
+
+	stop := make(chan struct{})
+	s := bufio.NewScanner(f.reader(stop))
+	require.True(t, s.Scan())
+	assert.Equal(t, "first", s.Text())
+	close(stop)
+	assert.False(t, s.Scan(), "stopped")
+
+	b, err := io.ReadAll(f.reader(nil))
+	require.NoError(t, err)
+	assert.Equal(t, "second\nthird\n", string(b), "the next reader gets the rest")
+}
+
+func TestPrompt_leavesLinesAfterAnswer(t *testing.T) {
+	f := newLineFeed(strings.NewReader("y\nprod-us\n"))
+	require.NoError(t, prompt(context.TODO(), f))
+
+	s := bufio.NewScanner(f.reader(nil))
+	require.True(t, s.Scan())
+	assert.Equal(t, "prod-us", s.Text(), "for the tail viewer")
+}
@@ -0,0 +1,89 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"io"
+)
+
+// jsonResult is the result of a context in --output=json.
+type jsonResult struct {
+	Context    string  `json:"context"`
+	ExitCode   int     `json:"exitCode"`
+	DurationMs int64   `json:"durationMs"`
+	Stdout     string  `json:"stdout"`
+	Stderr     string  `json:"stderr"`
+	Error      *string `json:"error"`              // null if the command succeeded
+	Reason     string  `json:"reason,omitempty"`   // with --classify-failures
+	Hash       string  `json:"hash,omitempty"`     // with --output-hash
+	Attempts   int     `json:"attempts,omitempty"` // 0 if the command didn't run
+}
+
+// newJSONResult returns the JSON result of a context. stdout and stderr are
+// empty if they weren't captured, e.g. for skipped contexts. If limit is not
+// negative, both are truncated to limit bytes.
+func newJSONResult(r result, limit int) jsonResult {
+	v := jsonResult{
+		Context:    r.context,
+		ExitCode:   r.exitCode(),
+		DurationMs: r.duration.Milliseconds(),
+		Reason:     r.reason,
+		Hash:       r.hash,
+		Attempts:   r.attempts,
+	}
+	if r.stdout != nil {
+		v.Stdout = truncateOutput(r.stdout.String(), limit)
+	}
+	if r.stderr != nil {
+		v.Stderr = truncateOutput(r.stderr.String(), limit)
+	}
+	if r.err != nil {
+		s := r.err.Error()
+		v.Error = &s
+	}
+	return v
+}
+
+// truncateOutput returns s truncated to limit bytes, if limit is not negative.
+func truncateOutput(s string, limit int) string {
+	if limit >= 0 && len(s) > limit {
+		return s[:limit] + "\n[output truncated]\n"
+	}
+	return s
+}
+
+// writeJSON writes the results as a JSON array.
+func writeJSON(w io.Writer, results []result) error {
+	out := make([]jsonResult, len(results))
+	for i, r := range results {
+		out[i] = newJSONResult(r, -1)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(out)
+}
+
+// writeNDJSON writes the result of a context as a line of JSON with a single
+// Write, so that w (shared by all contexts) can keep the lines whole. stdout and
+// stderr are truncated to limit bytes if limit is not negative.
+func writeNDJSON(w io.Writer, r result, limit int) error {
+	b, err := json.Marshal(newJSONResult(r, limit))
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeJSON(t *testing.T) {
+	errBuf := new(bytes.Buffer)
+	errBuf.Write([]byte("error: not found\n"))
+	var b bytes.Buffer
+	require.NoError(t, writeJSON(&b, []result{
+		{context: "a", duration: 1500 * time.Millisecond, stdout: bytes.NewBufferString("pod/web\n")},
+		{context: "b", err: errors.New("phony error"), stderr: errBuf},
+		{context: "c", err: foreach.ErrTimeout, reason: "timeout", hash: "e3b0c442", attempts: 3, code: -1},
+	}))
+	assert.JSONEq(t, `[
+		{"context": "a", "exitCode": 0, "durationMs": 1500, "stdout": "pod/web\n", "stderr": "", "error": null},
+		{"context": "b", "exitCode": -1, "durationMs": 0, "stdout": "", "stderr": "error: not found\n", "error": "phony error"},
+		{"context": "c", "exitCode": -1, "durationMs": 0, "stdout": "", "stderr": "", "error": "timed out",
+			"reason": "timeout", "hash": "e3b0c442", "attempts": 3}
+	]`, b.String())
+}
+
+func TestRunAll_outputJSON(t *testing.T) {
+	defer func(v string) { *outputFormat = v }(*outputFormat)
+	*outputFormat = "json"
+	fakeKubectl(t, `case "$1" in
+	--context=b) echo "error: forbidden" >&2; exit 1;;
+	esac
+	echo ok`)
+
+	var errOut bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, &synchronizedWriter{Writer: &errOut})
+	require.Error(t, err)
+	assert.Empty(t, errOut.String(), "stderr of commands is only in the results")
+	assert.Equal(t, "ok\n", results[0].stdout.String())
+	assert.Equal(t, "error: forbidden\n", results[1].stderr.String())
+	assert.Equal(t, 1, results[1].exitCode())
+}
+
+func TestRunAll_outputJSONFullStderr(t *testing.T) {
+	defer func(v string) { *outputFormat = v }(*outputFormat)
+	defer func(v int) { *captureLimit = v }(*captureLimit)
+	*outputFormat, *captureLimit = "json", 4
+	fakeKubectl(t, `echo "stdout line"; echo "stderr line" >&2`)
+
+	results, err := runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.NoError(t, err)
+	v := newJSONResult(results[0], -1)
+	assert.Equal(t, "stdout line\n", v.Stdout)
+	assert.Equal(t, "stderr line\n", v.Stderr, "not cut at --capture-limit, like stdout")
+}
+
+func Test_writeNDJSON(t *testing.T) {
+	var b bytes.Buffer
+	errBuf := new(bytes.Buffer)
+	errBuf.Write([]byte("warn 1\nwarn 2\n"))
+	r := result{context: "a", duration: 20 * time.Millisecond, stdout: bytes.NewBufferString("line 1\nline 2\n"), stderr: errBuf, attempts: 1}
+	require.NoError(t, writeNDJSON(&b, r, 6))
+	require.NoError(t, writeNDJSON(&b, r, -1))
+	assert.Equal(t, `{"context":"a","exitCode":0,"durationMs":20,"stdout":"line 1\n[output truncated]\n","stderr":"warn 1\n[output truncated]\n","error":null,"attempts":1}`+"\n"+
+		`{"context":"a","exitCode":0,"durationMs":20,"stdout":"line 1\nline 2\n","stderr":"warn 1\nwarn 2\n","error":null,"attempts":1}`+"\n", b.String())
+}
+
+func TestRunAll_outputNDJSON(t *testing.T) {
+	defer func(v string) { *outputFormat = v }(*outputFormat)
+	defer func(w io.Writer) { resultStream = w }(resultStream)
+	*outputFormat = "ndjson"
+	var lines bytes.Buffer
+	resultStream = &synchronizedWriter{Writer: &lines}
+	// b finishes first
+	fakeKubectl(t, `case "$1" in
+	--context=a) sleep 0.3; echo a;;
+	--context=b) echo b; exit 2;;
+	esac`)
+
+	_, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.Error(t, err)
+	var got []jsonResult
+	dec := json.NewDecoder(&lines)
+	for dec.More() {
+		var v jsonResult
+		require.NoError(t, dec.Decode(&v))
+		v.DurationMs = 0
+		got = append(got, v)
+	}
+	require.Len(t, got, 2)
+	require.NotNil(t, got[0].Error)
+	assert.Equal(t, jsonResult{Context: "b", ExitCode: 2, Stdout: "b\n", Error: got[0].Error, Attempts: 1}, got[0])
+	assert.Equal(t, jsonResult{Context: "a", Stdout: "a\n", Attempts: 1}, got[1])
+}
@@ -0,0 +1,118 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// contextInfo is a context from kubeconfig, resolved with the cluster it
+// points to.
+type contextInfo struct {
+	name      string
+	cluster   string
+	user      string
+	namespace string
+	server    string
+}
+
+// runConfig runs kubectl with args, without the --wrap command.
+func runConfig(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	argv := commandArgv(nil, args)
+	cmd := exec.CommandContext(ctx, argv[0], argv[1:]...)
+	cmd.Stdin = stdin
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+// kubeConfig returns the contexts in kubeconfig keyed by their name.
+func kubeConfig(ctx context.Context) (map[string]contextInfo, error) {
+	var b bytes.Buffer
+	if err := configCmd.Run(ctx, []string{"config", "view", "-o=json"}, nil, &b, os.Stderr); err != nil {
+		return nil, fmt.Errorf("failed to read kubeconfig: %w", err)
+	}
+	return parseKubeConfig(b.Bytes())
+}
+
+// currentContext returns the current context in kubeconfig, or "" if it's not
+// set.
+func currentContext(ctx context.Context) (string, error) {
+	var b bytes.Buffer
+	if err := configCmd.Run(ctx, []string{"config", "view", "-o=jsonpath={.current-context}"}, nil, &b, os.Stderr); err != nil {
+		return "", fmt.Errorf("failed to get current context: %w", err)
+	}
+	return strings.TrimSpace(b.String()), nil
+}
+
+// parseKubeConfig parses the output of "kubectl config view -o=json".
+func parseKubeConfig(b []byte) (map[string]contextInfo, error) {
+	var v struct {
+		Contexts []struct {
+			Name    string `json:"name"`
+			Context struct {
+				Cluster   string `json:"cluster"`
+				User      string `json:"user"`
+				Namespace string `json:"namespace"`
+			} `json:"context"`
+		} `json:"contexts"`
+		Clusters []struct {
+			Name    string `json:"name"`
+			Cluster struct {
+				Server string `json:"server"`
+			} `json:"cluster"`
+		} `json:"clusters"`
+	}
+	if err := json.Unmarshal(b, &v); err != nil {
+		return nil, fmt.Errorf("failed to parse kubeconfig: %w", err)
+	}
+	servers := make(map[string]string, len(v.Clusters))
+	for _, c := range v.Clusters {
+		servers[c.Name] = c.Cluster.Server
+	}
+	out := make(map[string]contextInfo, len(v.Contexts))
+	for _, c := range v.Contexts {
+		out[c.Name] = contextInfo{
+			name:      c.Name,
+			cluster:   c.Context.Cluster,
+			user:      c.Context.User,
+			namespace: c.Context.Namespace,
+			server:    servers[c.Context.Cluster],
+		}
+	}
+	return out, nil
+}
+
+// field returns the value of a named kubeconfig field of the context.
+func (c contextInfo) field(key string) (string, bool) {
+	switch key {
+	case "cluster":
+		return c.cluster, c.cluster != ""
+	case "user":
+		return c.user, c.user != ""
+	case "namespace":
+		return c.namespace, c.namespace != ""
+	case "server":
+		return c.server, c.server != ""
+	}
+	return "", false
+}
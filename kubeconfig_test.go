@@ -0,0 +1,87 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseKubeConfig(t *testing.T) {
+	t.Run("malformed", func(t *testing.T) {
+		_, err := parseKubeConfig([]byte("{"))
+		assert.Error(t, err)
+	})
+	t.Run("resolves clusters", func(t *testing.T) {
+		in := `{
+			"clusters": [{"name": "c1", "cluster": {"server": "https://1.2.3.4"}}],
+			"contexts": [
+				{"name": "a", "context": {"cluster": "c1", "user": "u1", "namespace": "ns1"}},
+				{"name": "b", "context": {"cluster": "missing", "user": "u2"}}
+			]}`
+		got, err := parseKubeConfig([]byte(in))
+		require.NoError(t, err)
+		assert.Equal(t, map[string]contextInfo{
+			"a": {name: "a", cluster: "c1", user: "u1", namespace: "ns1", server: "https://1.2.3.4"},
+			"b": {name: "b", cluster: "missing", user: "u2"},
+		}, got)
+	})
+}
+
+func Test_contextInfo_field(t *testing.T) {
+	c := contextInfo{name: "a", cluster: "c1", user: "u1", server: "https://1.2.3.4"}
+	v, ok := c.field("server")
+	assert.True(t, ok)
+	assert.Equal(t, "https://1.2.3.4", v)
+	_, ok = c.field("namespace")
+	assert.False(t, ok)
+	_, ok = c.field("unknown")
+	assert.False(t, ok)
+}
+
+func Test_currentContext(t *testing.T) {
+	fakeKubectl(t, `echo "prod-us"`)
+	got, err := currentContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "prod-us", got)
+
+	fakeKubectl(t, `exit 0`)
+	got, err = currentContext(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, "", got, "not set")
+
+	fakeKubectl(t, `exit 1`)
+	_, err = currentContext(context.Background())
+	assert.Error(t, err)
+}
+
+func Test_kubeContexts(t *testing.T) {
+	defer func(r foreach.Runner) { configCmd = r }(configCmd)
+	var got []string
+	configCmd = foreach.RunnerFunc(func(_ context.Context, args []string, _ io.Reader, stdout, _ io.Writer) error {
+		got = args
+		_, err := io.WriteString(stdout, "prod-us\nprod-eu\n")
+		return err
+	})
+	ctxs, err := kubeContexts(context.Background())
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod-us", "prod-eu"}, ctxs)
+	assert.Equal(t, []string{"config", "get-contexts", "-o=name"}, got)
+}
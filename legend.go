@@ -0,0 +1,73 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/jwalton/gchalk/pkg/ansistyles"
+)
+
+// legendEntry is the color of a context in the output prefix.
+type legendEntry struct {
+	Context string `json:"context"`
+	Color   string `json:"color"` // gchalk styles joined with "+", e.g. "yellow+bgBlack"
+	ANSI    string `json:"ansi"`  // escape sequence starting the color
+}
+
+// legend returns the colors assigned to the contexts by runAll.
+func legend(kubeCtxs []string) []legendEntry {
+	out := make([]legendEntry, len(kubeCtxs))
+	for i, kctx := range kubeCtxs {
+		styles := colorStyles[colorIndex(i, kctx)]
+		var ansi strings.Builder
+		for _, s := range styles {
+			ansi.WriteString(ansistyles.Styles[s].Open)
+		}
+		out[i] = legendEntry{Context: kctx, Color: strings.Join(styles, "+"), ANSI: ansi.String()}
+	}
+	return out
+}
+
+// writeLegend writes the legend of contexts in the given format.
+func writeLegend(w io.Writer, format string, kubeCtxs []string) error {
+	if format != "json" {
+		return fmt.Errorf("unsupported legend format %q (supported: json)", format)
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(legend(kubeCtxs))
+}
+
+// emitLegend writes the legend of contexts to path, or to stderr if path is
+// empty.
+func emitLegend(path, format string, kubeCtxs []string) error {
+	if path == "" {
+		return writeLegend(os.Stderr, format, kubeCtxs)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		return fmt.Errorf("failed to create legend file: %w", err)
+	}
+	if err := writeLegend(f, format, kubeCtxs); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
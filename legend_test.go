@@ -0,0 +1,78 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_legend(t *testing.T) {
+	defer func(v string) { *colorBy = v }(*colorBy)
+	*colorBy = "index"
+	got := legend([]string{"a", "b", "c", "d"})
+	assert.Equal(t, []legendEntry{
+		{Context: "a", Color: "red", ANSI: "\x1b[31m"},
+		{Context: "b", Color: "blue", ANSI: "\x1b[34m"},
+		{Context: "c", Color: "green", ANSI: "\x1b[32m"},
+		{Context: "d", Color: "yellow+bgBlack", ANSI: "\x1b[33m\x1b[40m"},
+	}, got)
+
+	ctxs := make([]string, len(colorStyles)+1)
+	assert.Equal(t, got[0].Color, legend(ctxs)[len(colorStyles)].Color, "colors wrap around")
+}
+
+func Test_legend_byName(t *testing.T) {
+	defer func(v string) { *colorBy = v }(*colorBy)
+	*colorBy = "name"
+	all := legend([]string{"a", "prod-us-east", "b"})
+	some := legend([]string{"prod-us-east"})
+	assert.Equal(t, all[1], some[0], "same color regardless of the other contexts")
+}
+
+func Test_legend_matchesColors(t *testing.T) {
+	defer func(v string) { *colorBy = v }(*colorBy)
+	*colorBy = "index"
+	b := gchalk.New(gchalk.ForceLevel(gchalk.LevelBasic))
+	fns := styleFuncs(b, colorStyles)
+	for i, e := range legend(make([]string, len(colorStyles))) {
+		assert.Regexp(t, "^"+regexp.QuoteMeta(e.ANSI)+"x", fns[i]("x"), e.Color)
+	}
+}
+
+func Test_writeLegend(t *testing.T) {
+	defer func(v string) { *colorBy = v }(*colorBy)
+	*colorBy = "index"
+	var b bytes.Buffer
+	require.NoError(t, writeLegend(&b, "json", []string{"a"}))
+	assert.JSONEq(t, `[{"context":"a","color":"red","ansi":"\u001b[31m"}]`, b.String())
+
+	assert.Error(t, writeLegend(&b, "yaml", nil))
+}
+
+func Test_emitLegend(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "legend.json")
+	require.NoError(t, emitLegend(path, "json", []string{"a"}))
+	b, err := os.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(b), `"context": "a"`)
+}
@@ -16,24 +16,51 @@ package main
 
 import (
 	"bufio"
-	"bytes"
 	"context"
 	"errors"
 	"flag"
 	"fmt"
 	"io"
+	"io/fs"
 	"log"
 	"os"
 	"os/exec"
 	"os/signal"
+	"path/filepath"
+	"regexp"
+	"runtime"
 	"strings"
+	"time"
 
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
 	"github.com/jwalton/gchalk"
-	"golang.org/x/sync/errgroup"
 )
 
 const (
 	envDisablePrompts = `KUBECTL_FOREACH_DISABLE_PROMPTS`
+	envPromptMessage  = `KUBECTL_FOREACH_PROMPT_MESSAGE`
+	envAllowedVerbs   = `KUBECTL_FOREACH_ALLOWED_VERBS`
+	envKubectl        = `KUBECTL`
+	envNoColor        = `NO_COLOR`
+)
+
+// Exit codes of the tool.
+const (
+	// exitSetupError is used when the tool fails before or while launching
+	// the commands (e.g. bad usage, kubectl not found, no matching contexts).
+	exitSetupError = 1
+	// exitCommandFailed is used when the command has run, but returned a
+	// non-zero exit code (or timed out, was skipped, or printed nothing with
+	// --fail-on-empty-output, or printed output that --merge-resources could
+	// not parse) in a context. If it failed in more contexts, the exit code is
+	// 1 + the number of failed contexts, up to exitManyFailed.
+	exitCommandFailed = 2
+	// exitManyFailed is used when the command failed in 124 or more contexts,
+	// below the codes shells use for commands that couldn't run or were killed.
+	exitManyFailed = 125
+	// exitUserAborted is used when the user answers "no" to the confirmation
+	// prompt (same as exiting due to SIGINT in shells).
+	exitUserAborted = 130
 )
 
 var (
@@ -42,31 +69,679 @@ var (
 	red   = chalk.Red
 
 	fl      = flag.NewFlagSet("kubectl foreach", flag.ContinueOnError)
-	repl    = fl.String("I", "", "string to replace in cmd args with context name (like xargs -I)")
-	workers = fl.Int("c", 0, "parallel runs (default: as many as matched contexts)")
+	repl    stringList
+	workers = new(int) // from -c, after workerLimit.resolve with a percentage
 	quiet   = fl.Bool("q", false, "accept confirmation prompts")
+	yes     = fl.Bool("yes", false, "run without listing the matched contexts or asking to confirm")
+
+	replMapFile    = fl.String("repl-map", "", "JSON file mapping context names to values for --repl-map-token")
+	replMapToken   = fl.String("repl-map-token", "", "string to replace in cmd args with the value of the context from --repl-map")
+	replMapMissing = fl.String("repl-map-missing", "error", "what to do with contexts not in --repl-map (error, context)")
+
+	paramsFile    = fl.String("params-csv", "", "CSV file of values of contexts to replace {{column}} with in cmd args")
+	paramsMissing = fl.String("params-missing", "error", "what to do with contexts not in --params-csv (error, skip)")
+
+	replaceStdin = fl.Bool("replace-stdin", false, "pass stdin to kubectl in each context, with {{context}} and -I tokens replaced")
+
+	successCodes = exitCodes{0: true}
+	providerCaps = defaultProviderLimits
+	minSuccess   successThreshold
+
+	contextMetadata   stringList
+	selectFile        = fl.String("select-file", "", "YAML file with lists of patterns to include and exclude contexts with")
+	labelSelector     = fl.String("selector", "", "only run in contexts whose labels match (k=v,k!=v,...)")
+	warnConflicts     = fl.Bool("warn-metadata-conflicts", false, "warn when --context-metadata files set different values for a label")
+	prefixAnnotations = fl.String("prefix-annotations", "", "comma-separated context fields to show in the output prefix")
+	outputMode        = fl.String("output-mode", "stream", "how to print the output of contexts: stream (as it's printed) or grouped (each context at once when it finishes)")
+	prefixField       = fl.String("prefix-field", "", "context field to show in the output prefix instead of the context name")
+	snapshotSave      = fl.String("snapshot-save", "", "save the contexts in kubeconfig to a snapshot file")
+	snapshotCompare   = fl.String("snapshot-compare", "", "only run in contexts added or changed since the snapshot file")
+	commandFile       = fl.String("command-file", "", "read arguments to kubectl from a file instead of after '--'")
+	emitScriptFile    = fl.String("emit-script", "", "write the commands to a shell script instead of running them")
+	scriptSet         = fl.String("script-set", "-e", "options for the 'set' builtin at the start of the script from --emit-script")
+	byProvider        = fl.Bool("provider-aware-concurrency", false, "limit parallel runs on API servers of each provider (GKE, EKS, AKS) with --provider-limits")
+	breakerThreshold  = fl.Int("circuit-breaker-threshold", 0, "skip contexts whose API server failed this many times in a row (0: disabled)")
+	htmlReport        = fl.String("html", "", "write an HTML report of the results to a file")
+	captureLimit      = fl.Int("capture-limit", 1<<20, "max bytes of output to keep per context for reports")
+	skipUnreachable   = fl.Bool("skip-unreachable", false, "do not count contexts whose API server is unreachable as failed")
+	confirmSummary    = fl.Int("confirm-summary", 0, "show counts of context groups instead of listing more than this many contexts before the prompt (0: always list)")
+	groupBy           = fl.String("group-by", "", "regular expression whose first group in the context name is the group of the context")
+	classifyFailures  = fl.Bool("classify-failures", false, "classify failures (not-found, forbidden, timeout, other) by the errors of kubectl")
+	failurePatterns   = fl.String("failure-patterns", "", "JSON file with patterns to classify failures with, instead of the defaults")
+	promptMessage     = fl.String("prompt-message", "", "message to show before the matched contexts and confirmation prompt")
+	promptTimeout     = fl.Duration("prompt-timeout", 0, "abort if the confirmation prompt isn't answered in this long (0: wait forever)")
+	stateFile         = fl.String("state-file", "", "file to record the result of the last run in each context")
+	sqlitePath        = fl.String("sqlite", "", "append the results of the run in each context to the SQLite database at PATH (needs sqlite3)")
+	orderBy           = fl.String("order-by", "", "order to run contexts in (last-duration)")
+	orderMissing      = fl.String("order-missing", "last", "where to place contexts without recorded results for --order-by (first, last)")
+	orderFile         = fl.String("order-file", "", "file with context names in the order to run them in")
+	orderUnlisted     = fl.String("order-unlisted", "last", "what to do with matched contexts not in --order-file (last, drop)")
+	sortContexts      = fl.Bool("sort", false, "run the matched contexts in lexical order")
+	shuffleContexts   = fl.Bool("shuffle", false, "run the matched contexts in a random order (see --seed)")
+	shuffleSeed       = fl.Int64("seed", 0, "shuffle the matched contexts with this seed, e.g. to repeat the order of a --shuffle run")
+	maxProcs          = fl.String("max-procs", "", "limit parallel runs if -c isn't given: auto (4 per CPU) or NUM")
+	statsdAddr        = fl.String("statsd", "", "send metrics of contexts to a StatsD server at host:port")
+	confirmMutating   = fl.Bool("force-confirm-mutating", false, "prompt for mutating commands even if $"+envDisablePrompts+" is set")
+	wrapCmd           = fl.String("wrap", "", "command to run kubectl with in each context, e.g. \"time\"")
+	pipeCmd           = fl.String("pipe", "", "shell command to pipe the output of kubectl through in each context")
+	excludeCurrent    = fl.Bool("exclude-current", false, "do not run in the current context of kubeconfig (same as the ^@current pattern)")
+	currentGuard      = fl.Bool("exclude-current-guard", false, "fail if the current context is matched, unless --allow-current is given")
+	allowCurrent      = fl.Bool("allow-current", false, "allow running in the current context with --exclude-current-guard")
+	fdPerContext      = fl.Bool("fd-per-context", false, "write stdout of each context to its own file descriptor from 3 on (not supported on Windows)")
+	revalidate        = fl.Bool("revalidate", false, "check matched contexts still exist in kubeconfig before running")
+	ignoreCase        = fl.Bool("ignore-case", false, "match NAME, GLOB and /PATTERN/ patterns of contexts ignoring case")
+	contextsFrom      = fl.String("contexts-from", "", "read the contexts to match from FILE (- for stdin) instead of kubeconfig")
+	allowUnknown      = fl.Bool("allow-unknown-contexts", false, "run in contexts from --contexts-from that are not in kubeconfig")
+	contextsURL       = fl.String("contexts-from-url", "", "only use contexts listed in the response of an HTTP GET to URL")
+	urlHeaders        stringList
+	ctxPrefixes       stringList
+	hashIgnoreFlag    stringList
+	ctxSuffixes       stringList
+	focusPatterns     stringList
+	urlTimeout        = fl.Duration("url-timeout", 30*time.Second, "timeout for --contexts-from-url")
+	urlCacheTTL       = fl.Duration("url-cache-ttl", 0, "reuse the response of --contexts-from-url for this long (0: no caching)")
+	startAfter        = fl.String("start-after", "", "only print output of each context starting from the line that matches REGEX")
+	nice              = fl.Int("nice", 0, "niceness to run kubectl with (not supported on Windows)")
+	failOnEmpty       = fl.Bool("fail-on-empty-output", false, "fail contexts where the command succeeded without printing anything to stdout")
+	emptyWhitespace   = fl.Bool("empty-includes-whitespace", true, "consider output with only whitespace empty for --fail-on-empty-output")
+	outputFormat      = fl.String("output", "", "print the results in FORMAT (csv, json, ndjson) instead of the output of commands")
+	fullOutput        = fl.Bool("full-output", false, "with --output=ndjson, include all of stdout and stderr of each context instead of up to --capture-limit bytes")
+	verifyCmd         = fl.String("verify", "", "kubectl arguments to verify the command with in each context it succeeded")
+	legendFormat      = fl.String("emit-legend", "", "write the colors of contexts in the output prefix in FORMAT (json)")
+	legendFile        = fl.String("legend-file", "", "file to write --emit-legend to (default: stderr)")
+	timestamps        = fl.Bool("timestamps", false, "start each output line with the time it was printed")
+	noColor           = fl.Bool("no-color", false, "disable colors in the output prefix and messages ($NO_COLOR)")
+	colorBy           = fl.String("color-by", "name", "assign colors to contexts by the hash of their name (name), or in the order they run (index)")
+	colorShuffle      = fl.Bool("color-shuffle", false, "assign colors to contexts in a random order (see --color-seed)")
+	colorSeed         = fl.Int64("color-seed", 0, "shuffle the order of colors with this seed, e.g. to repeat the colors of a --color-shuffle run")
+	filterCmd         = fl.String("filter-command", "", "only run in contexts where the shell command exits with 0 ($"+envProbeContext+" is the context)")
+	filterCacheDir    = fl.String("filter-cache-dir", "", "directory to cache results of --filter-command in (default: in the user cache directory)")
+	filterCacheTTL    = fl.Duration("filter-cache-ttl", 0, "reuse results of --filter-command for this long (0: no caching)")
+	cmdTimeout        = fl.Duration("timeout", 0, "kill the command in a context if it runs longer than this (0: no timeout)")
+	serverDryRun      = fl.Bool("server-dry-run", false, "add --dry-run=server to the command, so each API server validates the changes without persisting them")
+	mergeRes          = fl.Bool("merge-resources", false, "print the items in the -o json/yaml output of all contexts as one List, with identical items merged")
+	summary           = fl.Bool("summary", false, "print a table of the status, exit code and duration of each context at the end")
+	aggregateErrors   = fl.Bool("aggregate-errors", false, "print a summary of the errors of failed contexts, with the contexts listed once per error")
+	showAllErrors     = fl.Bool("show-all-errors", false, "like --aggregate-errors, but list the error of each failed context instead of grouping them")
+	allowedVerbs      = fl.String("allowed-verbs", "", "comma-separated kubectl verbs that are allowed to run (default: $"+envAllowedVerbs+", or all)")
+	startJitter       = fl.Duration("start-jitter", 0, "wait a random duration up to this long before starting the command in each context")
+	ramp              = fl.Duration("ramp", 0, "wait this long between starting the command in successive contexts")
+	shellArray        = fl.String("shell-array", "", "print the matched contexts as an array variable of SHELL (bash, zsh, fish) and exit")
+	sanitizeControl   = fl.Bool("sanitize-control", false, "remove terminal control sequences (cursor movement, screen clears) from the output of commands, keeping colors")
+	baselineCtx       = fl.String("baseline-context", "", "run in this context first and only show contexts whose output differs from it")
+	drainTimeout      = fl.Duration("drain-timeout", 0, "on interrupt, wait this long for running commands before cancelling them (0: cancel right away)")
+	interruptGrace    = fl.Duration("interrupt-grace", 5*time.Second, "when cancelling a command, interrupt it and wait this long for it to exit before killing it (0: kill right away)")
+	kubectlPath       = fl.String("kubectl", "", "kubectl binary to run, e.g. oc (default: $"+envKubectl+", or kubectl)")
+	expectFile        = fl.String("expect-contexts-file", "", "fail if the matched contexts differ from the names in this file, unless --accept-changes is given")
+	acceptChanges     = fl.Bool("accept-changes", false, "run even if the matched contexts differ from --expect-contexts-file")
+	maxFailures       = fl.Int("max-failures", 0, "cancel the run once the command failed in this many contexts (0: never)")
+	cmdRetries        = fl.Int("retries", 0, "run the command again in a context up to this many times if it fails")
+	retryBackoff      = fl.Duration("retry-backoff", time.Second, "wait this long before the first --retries attempt, doubled before each next one")
+	retryPasses       = fl.Int("retry-failed-passes", 0, "after running in all contexts, run again in the failed ones up to this many times")
+	retryPassDelay    = fl.Duration("retry-pass-delay", 0, "wait this long before each pass of --retry-failed-passes")
+	keepOrder         = fl.Bool("keep-order", false, "print the grouped output of contexts in the order they are matched (implies --output-mode=grouped)")
+	sidecarFile       = fl.String("prefix-sidecar", "", "print stdout without the prefix, and write the context of each line to FILE as JSON lines")
+	tailLines         = fl.Int("tail-buffer", 0, "keep only the last NUM lines of output of each context in memory, shown on request and when it finishes")
+	outputDir         = fl.String("output-dir", "", "also write the output of each context to DIR/CONTEXT.log, without the prefix")
+	kubeconfigFlag    = fl.String("kubeconfig", "", "kubeconfig file to read the contexts from and pass to kubectl in each context")
+	controlFile       = fl.String("control-file", "", "read the number of parallel runs from this file every second, to change it while running")
+	outputHash        = fl.Bool("output-hash", false, "compute the sha256 of stdout in each context, shown in --summary, --output=csv and --state-file")
+	checkOnly         = fl.Bool("check", false, "check which matched contexts are reachable with a cheap kubectl request, and exit without running the command")
+	listOnly          = fl.Bool("list", false, "print the matched contexts and the command for one of them, and exit without running it")
+	interactivePick   = fl.Bool("interactive", false, "pick some of the matched contexts to run in with a fuzzy selector on the terminal")
+	interactiveSelect = fl.Bool("interactive-select", false, "allow adding or removing patterns at the confirmation prompt, re-previewing the matched contexts")
 )
 
+// guardCurrent returns an error if the current context is in kubeCtxs with
+// --exclude-current-guard.
+func guardCurrent(ctx context.Context, kubeCtxs []string) error {
+	if !*currentGuard || *allowCurrent {
+		return nil
+	}
+	cur, err := currentContext(ctx)
+	if err != nil {
+		return err
+	}
+	if cur != "" && len(missingContexts([]string{cur}, kubeCtxs)) == 0 {
+		return fmt.Errorf("current context %q is matched (--exclude-current-guard), use --allow-current to run in it", cur)
+	}
+	return nil
+}
+
+// checkKnown returns an error listing the contexts in kubeCtxs that are not in
+// kubeconfig if they are read with --contexts-from, so that unknown names fail
+// before anything runs, unless --allow-unknown-contexts is given.
+func checkKnown(ctx context.Context, kubeCtxs []string) error {
+	if *contextsFrom == "" || *allowUnknown {
+		return nil
+	}
+	known, err := kubeContexts(ctx)
+	if err != nil {
+		return err
+	}
+	if unknown := missingContexts(kubeCtxs, known); len(unknown) > 0 {
+		return fmt.Errorf("context(s) not in kubeconfig: %s (use --allow-unknown-contexts to run in them anyway)",
+			strings.Join(unknown, ", "))
+	}
+	return nil
+}
+
+// checkExpected returns an error if kubeCtxs differ from the contexts in the
+// --expect-contexts-file, unless --accept-changes is given.
+func checkExpected(kubeCtxs []string) error {
+	if *expectFile == "" {
+		return nil
+	}
+	expected, err := readExpectedContexts(*expectFile)
+	if err != nil {
+		return err
+	}
+	d := diffContexts(expected, kubeCtxs)
+	if d.empty() {
+		return nil
+	}
+	fmt.Fprintf(os.Stderr, "Matched contexts differ from %s:\n", *expectFile)
+	d.write(os.Stderr)
+	if *acceptChanges {
+		fmt.Fprintln(os.Stderr, gray("warning: running anyway (--accept-changes)"))
+		return nil
+	}
+	return fmt.Errorf("matched contexts differ from --expect-contexts-file (%d added, %d removed), use --accept-changes to run anyway",
+		len(d.added), len(d.removed))
+}
+
+// wrapper is the command from --wrap to run kubectl with.
+var wrapper []string
+
+// kubectlBin is the kubectl binary from --kubectl or $KUBECTL.
+var kubectlBin = "kubectl"
+
+// kubeconfigPath is the kubeconfig file from --kubeconfig passed to kubectl, if
+// not empty.
+var kubeconfigPath string
+
+// runCmd runs kubectl with the arguments in runAll, replaced by selftest and in
+// tests.
+var runCmd foreach.Runner = foreach.RunnerFunc(run)
+
+// configCmd runs the "kubectl config" commands that read kubeconfig.
+var configCmd foreach.Runner = foreach.RunnerFunc(runConfig)
+
+// contextFiles are the files to write stdout of each context to, in order,
+// with --fd-per-context.
+var contextFiles []*os.File
+
+// contextIndex is the position of each context in the first run, if not nil.
+// runAll uses it for the colors and --fd-per-context files of contexts, so
+// that they stay the same when only some contexts run again, as with
+// --retry-failed-passes.
+var contextIndex map[string]int
+
+// resultStream is where runAll writes the result of each context when it
+// finishes, with --output=ndjson.
+var resultStream io.Writer
+
+// kubectlStdin returns the stdin of kubectl in each context in runAll, with
+// --replace-stdin.
+var kubectlStdin func(kctx string) []byte
+
+// hashIgnore are the patterns from --hash-ignore.
+var hashIgnore []*regexp.Regexp
+
 func printErrAndExit(msg string) {
+	printErrAndExitCode(exitSetupError, msg)
+}
+
+func printErrAndExitCode(code int, msg string) {
 	fmt.Fprintf(os.Stderr, "%s%s\n", red("error: "), msg)
-	os.Exit(1)
+	os.Exit(code)
 }
 
 func printUsage(w io.Writer) {
-	_, _ = fmt.Fprint(w, `Usage:
+	_, _ = fmt.Fprint(w, strings.ReplaceAll(`Usage:
     kubectl foreach [OPTIONS] [PATTERN]... -- [KUBECTL_ARGS...]
+    kubectl foreach [OPTIONS] --command-file=FILE [PATTERN]...
+    kubectl foreach selftest [-n=NUM] [-c=NUM] [-lines=NUM]
 
 Patterns can be used to match context names from kubeconfig:
       (empty): matches all contexts
          NAME: matches context with exact name
     /PATTERN/: matches context with regular expression
+         GLOB: matches contexts with a shell-style glob, a NAME with * (any characters,
+               also /) or ? (one character), e.g. 'prod-*' or '*-us-?'. [...] matches
+               one of the characters in it, [!...] none of them
         ^NAME: remove context with exact name from the matched results
    ^/PATTERN/: remove contexts matching the regular expression from the results
+        ^GLOB: remove contexts matching the glob from the results
+     @current: matches the current context of kubeconfig (^@current removes it)
+  'KEY:VALUE ...': matches contexts for which all KEY:VALUE terms match, where VALUE
+               is a NAME, GLOB or /PATTERN/, and KEY is one of name, ns (namespace of the
+               context, "default" if not set), cluster, user or server. Can be used
+               with ^ to remove contexts, e.g. 'name:/^prod-/ ns:default'
     
 Options:
-    -c=NUM     Limit parallel executions (default: 0, unlimited)
-    -I=VAL     Replace VAL occurring in KUBECTL_ARGS with context name
+    -c=NUM|PERCENT
+               Limit parallel executions to NUM, or to PERCENT (e.g. 50%) of the matched
+               contexts, rounded up to at least 1 (default: 0, all matched contexts at once)
+    -I=VAL     Replace VAL occurring in KUBECTL_ARGS with context name (can be repeated
+               to replace several tokens). {{context}} in KUBECTL_ARGS is always replaced
+               with the context name, and --context isn't added when it or -I is used
     -q         Disable and accept confirmation prompts ($KUBECTL_FOREACH_DISABLE_PROMPTS) 
+    -y, --yes  Run without listing the matched contexts or asking to confirm (-q still
+               lists them)
+    -l, --list Print the matched contexts (one per line) and the kubectl command for the
+               first one, after -I and other replacements, and exit without running it.
+               '--' and KUBECTL_ARGS can be omitted to only print the contexts
+    --check    Check which of the matched contexts are reachable, with a cheap request
+               (kubectl version --request-timeout=5s) in each context in parallel, print
+               a table of the results and exit without running the command ('--' and
+               KUBECTL_ARGS can be omitted). Exits with 1 if any are unreachable
+    --max-procs=auto|NUM
+               Without -c, run in up to NUM contexts at a time, or 4 per CPU with auto,
+               instead of all matched contexts at once (e.g. to not run out of file
+               descriptors with hundreds of contexts). The limit is printed before running
+    --control-file=FILE
+               Read the number of parallel runs (0: unlimited) from FILE every second,
+               so it can be changed while running, e.g. echo 5 > FILE. Until FILE
+               exists, -c is used. Invalid values are reported and ignored
+    --output-dir=DIR
+               Also write stdout and stderr of each context to DIR/CONTEXT.log (created
+               if missing), without the prefix. Characters like / and : in context names
+               are replaced with _ in file names
+    --prefix-sidecar=FILE
+               Print stdout of commands without the prefix, and write the context of each
+               line to FILE as JSON lines of {"line": N, "offset": BYTES, "length": BYTES,
+               "context": NAME}. stderr keeps the prefix. See README for the format
+    --tail-buffer=NUM
+               Keep only the last NUM lines of the output (stdout and stderr) of each
+               context in memory instead of printing it, and print them when the context
+               finishes. While running, type a context name (or its start) and enter to
+               show its lines, or enter to list contexts, if stdin is a terminal.
+               SIGUSR1 shows the lines of all contexts (not on Windows)
+    --output-mode=stream|grouped
+               Print the output of each context as soon as it's printed, interleaved
+               with other contexts (stream), or as one block once the command in the
+               context exits (grouped). Grouped output is kept in memory until then
+               (default: stream)
+    --keep-order
+               With grouped output, print the output of contexts in the order they are
+               matched (or --order-by/--order-file), holding finished contexts until the
+               ones before them finish. Commands still run in parallel. Implies
+               --output-mode=grouped
+    -t, --timeout=DURATION
+               Kill kubectl in a context if it's still running after DURATION (e.g. 30s,
+               2m), and fail the context with a timeout, so that unreachable clusters
+               don't block the run. Applies to each context (and --verify) on its own
+               (default: 0, no timeout)
+    --max-failures=NUM
+               Once the command failed in NUM contexts, kill the commands still running
+               and don't start it in the remaining contexts, which are listed at the end,
+               e.g. to stop a broken rollout early (default: 0, never)
+    --retries=NUM
+               If kubectl exits with a failure in a context, run it again in that context
+               up to NUM times before failing the context, e.g. for flaky API servers.
+               Not retried after --timeout or an interrupt. The number of attempts is
+               shown in --summary (default: 0)
+    --retry-backoff=DURATION
+               Wait DURATION before the first retry of --retries, and twice as long
+               before each next one (default: 1s)
+    --retry-failed-passes=NUM
+               After running in all contexts, run the command again in the contexts that
+               failed (not the unreachable ones), up to NUM more passes, until all of them
+               succeed. The summary and exit code are from the last run in each context
+               (default: 0)
+    --retry-pass-delay=DURATION
+               Wait DURATION before each pass of --retry-failed-passes (default: 0)
+    --provider-aware-concurrency
+               Also limit parallel runs in contexts of each managed provider (GKE, EKS,
+               AKS, detected from the server URL and cluster name), whose control planes
+               may throttle many clients, with the limits from --provider-limits.
+               Contexts waiting for their provider don't take a slot of -c, so the ones
+               of other providers can start before them
+    --provider-limits=PROVIDER=NUM,...
+               Max parallel runs per provider (gke, eks, aks, other), where 0 is unlimited
+               (default: gke=10, eks=10, aks=10, other=0)
+    --repl-map=FILE
+               Read a JSON file mapping context names to values, e.g. {"prod-us": "p-123"},
+               to replace --repl-map-token with in KUBECTL_ARGS
+    --repl-map-token=VAL
+               Replace VAL occurring in KUBECTL_ARGS with the value of the context from
+               --repl-map (can be used together with -I)
+    --repl-map-missing=error|context
+               For matched contexts missing from --repl-map, fail before running anything
+               or use the context name as the value (default: error)
+    --params-csv=FILE
+               Read a CSV file with a header row, whose first column is the context name
+               and the others are named values of the context, e.g. "context,replicas",
+               to replace {{NAME}} with the value of the NAME column in KUBECTL_ARGS
+    --params-missing=error|skip
+               For matched contexts missing from --params-csv, fail before running
+               anything or don't run in them (default: error)
+    --replace-stdin
+               Read stdin once and pass it to kubectl in each context, with {{context}} and
+               the -I tokens replaced with the context name, e.g. for "apply -f -" with a
+               manifest piped to stdin. Needs -q or -y, as the prompt can't be answered
+    --success-codes=CODE,...
+               Exit codes of kubectl to consider successful, in addition to 0
+    --min-success=NUM|PERCENT
+               Exit with 0 if at least NUM (e.g. 8) or PERCENT (e.g. 90%) of the contexts
+               succeed, even if the command failed in others (default: all contexts)
+    --context-metadata=FILE
+               Read labels of contexts from a JSON file, e.g. {"ctx": {"region": "us-east"}}.
+               Can be repeated to merge labels from multiple files, where the files
+               given later override the labels of earlier ones
+    --warn-metadata-conflicts
+               Warn when --context-metadata files set a label to different values
+    --ignore-case
+               Match NAME, GLOB and /PATTERN/ patterns (also in KEY:VALUE terms, --select-file
+               and --focus) ignoring case, e.g. /prod/ matches Prod-EU. This applies to
+               ^ patterns too, so ^prod-us also removes PROD-US
+    --prefix=STR, --suffix=STR
+               Also match contexts whose name starts (or ends) with STR, without writing
+               a /PATTERN/. Can be repeated to match any of the prefixes (or suffixes).
+               If both are given, contexts must have one of each, e.g. --prefix=prod-
+               --suffix=-us. ^ patterns still remove contexts from the results
+    --select-file=FILE
+               Also match contexts with the patterns in the "include" list of the YAML
+               FILE and remove the ones matching its "exclude" list (without ^), e.g.
+               include: [/^prod-/], exclude: [prod-legacy]
+    --selector=KEY=VAL,KEY!=VAL,...
+               Only run in matched contexts whose labels match all the requirements
+    --prefix-annotations=FIELD,...
+               Show the given fields next to the context name in the output prefix.
+               Fields are looked up in the context labels first, then in kubeconfig
+               (cluster, user, namespace, server).
+    --prefix-field=FIELD
+               Show the given field (looked up like --prefix-annotations) in the output
+               prefix instead of the context name, e.g. to group output by region.
+               Contexts without the field use their name
+    --expect-contexts-file=FILE
+               Fail if the matched contexts differ from the names in FILE (one per line,
+               e.g. from --list, or a JSON array), listing the added and removed ones,
+               so that no cluster is added or removed after an approval
+    --accept-changes
+               Run even if the matched contexts differ from --expect-contexts-file
+    --snapshot-save=FILE
+               After the run, save the names, servers and users of the contexts in
+               kubeconfig the command succeeded in to FILE. Contexts that failed or
+               didn't run keep the state saved in FILE before, if any, so that they
+               are still added or changed for --snapshot-compare with the same FILE
+    --snapshot-compare=FILE
+               Only run in matched contexts that are added or changed since the snapshot
+               in FILE was saved
+    --command-file=FILE
+               Read KUBECTL_ARGS from FILE instead of after '--' (cannot be used together).
+               Arguments are separated by whitespace or newlines and can be quoted with
+               '...' or "..."; backslash escapes a character and '#' starts a comment.
+               Variables and globs are not expanded.
+    --shell-array=SHELL
+               Print the matched contexts as a "contexts" array variable in the syntax
+               of SHELL (bash, zsh, fish) and exit without running anything, e.g.
+               eval "$(kubectl foreach --shell-array=bash /prod/)". No '--' is needed
+    --emit-script=FILE
+               Write an executable shell script with the command for each matched context
+               to FILE and exit without running anything
+    --script-set=OPTS
+               Shell options to 'set' at the start of the script (default: -e, empty to omit)
+    --circuit-breaker-threshold=NUM
+               After NUM consecutive failures of contexts sharing an API server, skip the
+               remaining contexts on that server. Skipped contexts are reported as
+               "skipped" and count as failures (default: 0, disabled)
+    --html=FILE
+               Write a self-contained HTML report with the status and output of each
+               context to FILE after the run
+    --capture-limit=BYTES
+               Max output to keep in memory per context for reports (default: 1MiB)
+    --skip-unreachable
+               Report contexts whose API server cannot be reached (e.g. "connection
+               refused", "no such host", "i/o timeout" errors) as "unreachable", and
+               do not count them as failures in the exit code
+    --classify-failures
+               Classify each failed context by the errors kubectl printed as not-found,
+               forbidden, timeout or other, and show the reasons in the reports, the state
+               file and the number of failures with each reason at the end
+    --failure-patterns=FILE
+               Classify failures with the patterns in FILE instead of the defaults, as a
+               JSON list of {"reason": "...", "pattern": "REGEX"} tried in order
+    --prompt-message=TEXT
+               Show TEXT (can have multiple lines) before the list of matched contexts and
+               the confirmation prompt ($KUBECTL_FOREACH_PROMPT_MESSAGE)
+    --prompt-timeout=DURATION
+               Abort without running if the confirmation prompt isn't answered within
+               DURATION (e.g. 1m), such as when stdin is not attached to a terminal
+               (default: 0, wait forever)
+    --confirm-summary=NUM
+               If more than NUM contexts match, show the number of contexts in each group
+               from --group-by instead of listing them before the confirmation prompt.
+               Answer "l" to the prompt to list them (default: 0, always list)
+    -i, --interactive
+               Pick the contexts to run in from the matched ones (all contexts without
+               patterns) on the terminal: type /QUERY to list the contexts whose names
+               have the characters of QUERY in order, numbers (N or N-M) to select or
+               unselect them, * for all that match, and enter when done
+    --interactive-select
+               Answer "e" to the confirmation prompt to add (+PATTERN) or remove (-N)
+               patterns and preview the contexts they match before continuing
+    --group-by=REGEX
+               Group contexts by the first group (or the whole match) of REGEX in their
+               name, e.g. '^(prod|staging|dev)-'. Contexts not matching are in "other"
+    --state-file=FILE
+               Record the status and duration of the last run in each context to FILE
+    --sqlite=PATH
+               Append the status, exit code, duration and command of each context to the
+               "results" table (created if missing) of the SQLite database at PATH, with
+               the id and time of the run, to query the history of runs. Needs the sqlite3
+               command
+    --order-by=last-duration
+               Run contexts in order of their duration in the last run recorded in
+               --state-file, fastest first (e.g. to see canary results sooner)
+    --order-missing=first|last
+               Run contexts without a recorded duration first or last (default: last),
+               keeping their order from kubeconfig
+    --order-file=FILE
+               Run the matched contexts in the order they are listed in FILE (one name per
+               line, or a JSON array), e.g. for rollouts with dependencies between clusters.
+               It's an error if FILE lists contexts that are not matched
+    --order-unlisted=last|drop
+               Run matched contexts not in --order-file last (in their own order) or not
+               at all (default: last)
+    --sort     Run the matched contexts in lexical order of their names, instead of the
+               order in kubeconfig
+    --shuffle  Run the matched contexts in a random order, e.g. to spread the load on
+               backends shared by the clusters. The seed is printed, to repeat the order
+               with --seed
+    --seed=NUM
+               Shuffle the matched contexts with seed NUM (implies --shuffle)
+    --statsd=HOST:PORT
+               Send counters (contexts.success, contexts.failure) and timings
+               (context.duration) prefixed with "kubectl_foreach." to a StatsD server
+               over UDP, tagged with the context and kubectl verb
+    --merge-resources
+               For commands with -o json or -o yaml, print the items in the output of all
+               contexts as one List instead, annotated with the contexts they came from
+               (kubectl-foreach/contexts). Items that are the same in several contexts
+               (ignoring uid, resourceVersion, creationTimestamp, ...) are listed once.
+               Contexts whose output can't be parsed are reported and fail the run
+    --summary
+               At the end, print a table of the status, exit code and duration of the
+               command in each context to stderr, with failed contexts in red (and their
+               reason with --classify-failures)
+    --aggregate-errors
+               At the end, print the last error line of each failed context, grouped
+               by error (with the names of contexts and API server URLs ignored), so
+               that an error repeated in many contexts is shown once with their names
+    --show-all-errors
+               List the error of each context on its own in the --aggregate-errors
+               summary, instead of grouping them
+    --allowed-verbs=VERB,...
+               Refuse to run (before anything is run) if the kubectl verb of the command
+               or --verify is not in the list, e.g. --allowed-verbs=get,describe,logs
+               ($KUBECTL_FOREACH_ALLOWED_VERBS). Meant as a guardrail, it doesn't limit
+               --pipe, --wrap or --filter-command
+    --server-dry-run
+               Add --dry-run=server to the command in each context, so that the changes
+               are validated by each API server (admission, schemas) without persisting
+               them. A warning is printed for commands that don't modify the cluster
+    --force-confirm-mutating
+               Ignore $KUBECTL_FOREACH_DISABLE_PROMPTS for commands that can modify the
+               cluster (apply, delete, patch, scale, ...), so they are still confirmed
+               unless -q is given explicitly
+    --output-hash
+               Compute the sha256 of stdout in each context, shown in --summary (first
+               12 digits), --output=csv and --state-file, to detect drift between runs
+               without keeping the output
+    --hash-ignore=REGEX
+               Remove matches of REGEX from each line (without the newline) before
+               --output-hash, e.g. timestamps or '\s+[0-9]+[smhd]$' for the AGE column.
+               Can be repeated
+    --kubeconfig=FILE
+               Read the contexts from the kubeconfig FILE, and pass --kubeconfig=FILE to
+               kubectl in each context (before --context=NAME, or the KUBECTL_ARGS with -I)
+    --kubectl=PATH
+               Run PATH (e.g. oc, or kubectl-1.28) instead of kubectl, also to read the
+               contexts (default: $KUBECTL, or kubectl from PATH)
+    --wrap=CMD
+               Run kubectl in each context as an argument of CMD (split like --command-file),
+               e.g. --wrap=time or --wrap='strace -f'. The exit code of CMD is used
+    --pipe=CMD
+               Pipe stdout of kubectl in each context through the shell command CMD
+               (e.g. jq) before it's printed. The context fails if CMD fails
+    --exclude-current
+               Do not run in the current context of kubeconfig, even if it's matched,
+               e.g. to run everywhere except the cluster you're working on (same as
+               the ^@current pattern)
+    --exclude-current-guard
+               Fail before running anything if the current context of kubeconfig is matched,
+               e.g. to not accidentally include the cluster you're working on in a broad
+               pattern (can be set in a shell alias)
+    --allow-current
+               Run even if the current context is matched with --exclude-current-guard
+    --fd-per-context
+               Write stdout of the Nth context (in the order they run) to file descriptor
+               N+2 without the prefix, so it can be redirected by the shell, e.g.
+               3>a.log 4>b.log. The file descriptors must be open for all matched
+               contexts. Not supported on Windows
+    --revalidate
+               Re-read kubeconfig right before running and fail if any matched context
+               no longer exists (e.g. kubeconfig changed while the prompt was open)
+    --sanitize-control
+               Remove terminal control sequences, such as cursor movements, screen clears
+               and carriage returns, from the output of commands (e.g. plugins with
+               progress bars), so they don't corrupt the prefixed output. Colors are kept
+    --timestamps
+               Start each line of the output (before the context name) with the local
+               time kubectl printed it, as HH:MM:SS.mmm, to correlate the output of
+               contexts. Lines are timestamped as printed, also with grouped output
+    --no-color Print context names and messages without colors, e.g. in CI logs (also
+               if $NO_COLOR is set, to any value)
+    --color-by=name|index
+               Assign colors to contexts by the hash of their name, so that a context has
+               the same color in every run (name, two contexts can get the same color),
+               or in the order they run (index) (default: name)
+    --focus=PATTERN
+               Show the names of contexts matching PATTERN (in the syntax of the patterns
+               above) in bold in the output prefix, and the others dimmed, to follow a
+               few contexts in a large run. Can be repeated to focus on any of them
+    --color-shuffle
+               Assign colors to contexts in a random order in each run, instead of the
+               same order every time. The seed is printed, to repeat the colors with
+               --color-seed. This only changes how the output looks
+    --color-seed=NUM
+               Shuffle the order of colors with seed NUM, so that runs with the same seed
+               (and contexts) get the same colors
+    --baseline-context=NAME
+               Run the command in context NAME first, then in the matched contexts, and
+               only print a unified diff of the output of each context that differs from
+               NAME. Stops without running the rest if the command fails in NAME
+    --drain-timeout=DURATION
+               On the first interrupt (Ctrl-C), do not start any more contexts and wait
+               up to DURATION (e.g. 30s) for the running commands to finish, then cancel
+               the remaining ones and list them. Interrupt again to cancel right away
+               (default: 0, cancel on the first interrupt)
+    --interrupt-grace=DURATION
+               Cancel commands (on interrupt, --timeout or --max-failures) by sending
+               them an interrupt, and kill the ones still running after DURATION, so
+               that kubectl can close port-forwards and proxies (default: 5s, 0: kill
+               right away; commands are always killed right away on Windows)
+    --filter-command=CMD
+               Only run in the matched contexts where the shell command CMD exits with 0,
+               e.g. 'kubectl --context=$KUBECTL_CONTEXT get crd foos.example.com'. CMD is
+               run for the contexts in parallel (up to -c), with $KUBECTL_CONTEXT set
+    --filter-cache-ttl=DURATION
+               Reuse the result of --filter-command for a context if it's newer than
+               DURATION (e.g. 1h), cached per command and context (default: 0, no caching)
+    --filter-cache-dir=DIR
+               Directory for the --filter-command cache (default: kubectl-foreach/filter
+               in the user cache directory)
+    --contexts-from=FILE
+               Match the patterns against the context names in FILE (one per line, or a
+               JSON array), or stdin if FILE is -, instead of the contexts in kubeconfig,
+               e.g. for a curated list of clusters. With -, -q is needed. It's an error
+               if matched contexts are not in kubeconfig, before anything runs
+    --allow-unknown-contexts
+               Run in the contexts from --contexts-from even if they are not in
+               kubeconfig, e.g. with --wrap or -I commands that don't need them
+    --contexts-from-url=URL
+               Only use the contexts listed in the response of a GET request to URL, as
+               a JSON array or one name per line. Listed contexts not in kubeconfig are
+               ignored with a warning. A timeout or non-2xx response is an error
+    --header='NAME: VALUE'
+               Add a header to the --contexts-from-url request (can be repeated)
+    --url-timeout=DURATION
+               Timeout of the --contexts-from-url request (default: 30s)
+    --url-cache-ttl=DURATION
+               Reuse the --contexts-from-url response (cached per URL in the user cache
+               directory) if it's newer than DURATION (default: 0, no caching)
+    --start-after=REGEX
+               Discard stdout of kubectl in each context until a line matches REGEX, then
+               print it from that line on (e.g. '^NAME\s' to skip warnings printed before
+               a table). Contexts with no matching line print nothing
+    --start-jitter=DURATION
+               Wait a random duration between 0 and DURATION (e.g. 2s) before starting the
+               command in each context, so that commands hitting a shared backend (e.g. a
+               token endpoint) don't all start at the same moment. Contexts still run in
+               parallel, up to -c
+    --ramp=DURATION
+               Wait DURATION (e.g. 500ms) between starting the command in one context and
+               the next, to spread the load on API servers behind the same endpoint. Only
+               the starts are delayed, and at most -c commands still run at once
+               (default: 0, start right away)
+    --nice=NUM
+               Set the niceness of kubectl (and --pipe) processes to NUM, from -20 (highest
+               priority, needs root) to 19 (lowest), to keep a large fan-out from starving
+               the rest of the machine. Not supported on Windows
+    --fail-on-empty-output
+               Fail contexts where the command exits with 0 without printing anything to
+               stdout (e.g. "get pods" finds no pods)
+    --empty-includes-whitespace=true|false
+               Whether stdout with only whitespace is empty for --fail-on-empty-output
+               (default: true)
+    --output=csv
+               Instead of the output of the commands, print a CSV with the context, status,
+               exit code, duration (in seconds) and number of output lines of each context
+               after the run. Errors of kubectl are still printed to stderr
+    --output=json
+               Instead of the output of the commands (and --summary), print a JSON
+               array with the context, exit code, duration (in ms), stdout, stderr and
+               error of each context after the run, and its attempts, --output-hash and
+               --classify-failures reason if any. Unless stdin is a terminal, colors
+               and the confirmation prompt are disabled
+    --output=ndjson
+               Like --output=json, but print the result of each context as a line of
+               JSON as soon as it finishes. stdout and stderr are truncated to
+               --capture-limit bytes
+    --full-output
+               With --output=ndjson, do not truncate stdout and stderr
+    --verify=ARGS
+               After the command succeeds in a context, run kubectl with ARGS (split like
+               --command-file, with -I replaced) in the same context, e.g.
+               --verify='rollout status deploy/foo'. The context fails if it fails
+    --emit-legend=json
+               Before running, write the color of each context in the output prefix as
+               JSON (context, gchalk style names and the ANSI escape sequence), e.g. for
+               log viewers to color the output the same way
+    --legend-file=FILE
+               Write --emit-legend to FILE instead of stderr
     -h/--help  Print help
 
 Examples:
@@ -80,7 +755,37 @@ Examples:
     kubectl foreach /prod/ ^/foo/ -- get nodes
 
     # use 'kubectl tail' plugin to follow logs of pods in contexts named *test*
-    kubectl foreach -I _ /test/ -- tail --context=_ -l app=foo`+"\n")
+    kubectl foreach -I _ /test/ -- tail --context=_ -l app=foo
+
+    # show differences in all contexts. kubectl diff exits 1 when there are differences
+    kubectl foreach --success-codes=0,1 -- diff -f deploy.yaml
+
+    # run only in contexts added to kubeconfig since the last run
+    kubectl foreach --snapshot-compare=ctx.json --snapshot-save=ctx.json -- apply -f baseline.yaml
+
+    # run the kubectl command saved in a file
+    kubectl foreach --command-file=cmd.txt /prod/
+
+    # write the commands to a script to review and run later
+    kubectl foreach --emit-script=rollout.sh /prod/ -- rollout restart deploy/foo
+
+    # save the output of each context to an HTML report
+    kubectl foreach --html=report.html -- get pods -A
+
+    # roll out to the fastest contexts of the last run first, one at a time
+    kubectl foreach --state-file=state.json --order-by=last-duration -c 1 -- apply -f app.yaml
+
+    # get the names of the images running in each context with jq
+    kubectl foreach --pipe="jq -r '.items[].spec.containers[].image'" -- get pods -o json
+
+    # get nodes in contexts labeled env=prod in either of the metadata files
+    kubectl foreach --context-metadata=teams.json --context-metadata=regions.json --selector=env=prod -- get nodes
+
+    # measure the overhead of the tool itself (without kubectl) in 5000 contexts
+    kubectl foreach selftest -n=5000
+
+    # show the region and env labels of each context next to its output
+    kubectl foreach --context-metadata=labels.json --prefix-annotations=region,env -- get nodes`+"\n", "kubectl foreach", invocationName(os.Args[0])))
 	os.Exit(0)
 }
 
@@ -88,125 +793,1273 @@ func main() {
 	log.SetOutput(os.Stderr)
 	log.SetFlags(0)
 	fl.Usage = func() { printUsage(os.Stderr) }
+	if _, ok := os.LookupEnv(envNoColor); ok {
+		disableColors()
+	}
+	if len(os.Args) > 1 && os.Args[1] == "selftest" && !hasSeparator(os.Args[1:]) {
+		if err := selftest(os.Args[2:], os.Stdout); err != nil {
+			printErrAndExit(err.Error())
+		}
+		return
+	}
+	fl.Var(&successCodes, "success-codes", "comma-separated exit codes of kubectl considered successful in addition to 0")
+	concurrency := workerLimit{n: workers}
+	fl.Var(&concurrency, "c", "parallel runs, or percentage of matched contexts (default: as many as matched contexts)")
+	fl.Var(&repl, "I", "string to replace in cmd args with context name, like xargs -I (can be repeated)")
+	fl.Var(&contextMetadata, "context-metadata", "JSON file with labels of contexts (can be repeated)")
+	fl.Var(&providerCaps, "provider-limits", "parallel runs per provider for --provider-aware-concurrency (gke=N,eks=N,aks=N,other=N)")
+	fl.Var(&minSuccess, "min-success", "succeed if at least this many (N) or this ratio (N%) of contexts succeed")
+	fl.DurationVar(cmdTimeout, "t", 0, "short for --timeout")
+	fl.BoolVar(listOnly, "l", false, "short for --list")
+	fl.BoolVar(interactivePick, "i", false, "short for --interactive")
+	fl.BoolVar(yes, "y", false, "short for --yes")
+	fl.Var(&hashIgnoreFlag, "hash-ignore", "regular expression whose matches are removed from each line before --output-hash (can be repeated)")
+	fl.Var(&ctxPrefixes, "prefix", "match contexts whose name starts with this (can be repeated)")
+	fl.Var(&ctxSuffixes, "suffix", "match contexts whose name ends with this (can be repeated)")
+	fl.Var(&focusPatterns, "focus", "show the names of contexts matching this pattern in bold and the others dimmed (can be repeated)")
+	fl.Var(&urlHeaders, "header", "'Name: value' header for --contexts-from-url (can be repeated)")
 
-	if err := fl.Parse(os.Args[1:]); err != nil {
+	err := fl.Parse(os.Args[1:])
+	if err != nil {
 		if errors.Is(err, flag.ErrHelp) {
 			printUsage(os.Stderr)
 		}
 		printErrAndExit(err.Error())
 	}
-	_, kubectlArgs, err := separateArgs(os.Args[1:])
-	if err != nil {
-		printErrAndExit(fmt.Errorf("failed to parse command-line arguments: %w. see -h/--help", err).Error())
+	// --output=json is for automation, where nobody sees the colors or can answer
+	// the prompt
+	jsonUnattended := jsonOutput() && !stdinIsTerminal()
+	if *noColor || jsonUnattended {
+		disableColors()
+	}
+	if *fdPerContext {
+		if !fdsSupported {
+			printErrAndExit("--fd-per-context is not supported on this platform")
+		}
+		contextFiles = inheritedFiles()
+	}
+	var kubectlArgs []string
+	if *commandFile != "" {
+		if hasSeparator(os.Args[1:]) {
+			printErrAndExit("--command-file cannot be used with '--' and arguments to kubectl")
+		}
+		kubectlArgs, err = readCommandFile(*commandFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	} else if (*shellArray == "" && !*listOnly && !*checkOnly) || hasSeparator(os.Args[1:]) {
+		_, kubectlArgs, err = separateArgs(os.Args[1:])
+		if err != nil {
+			printErrAndExit(fmt.Errorf("failed to parse command-line arguments: %w. see -h/--help", err).Error())
+		}
+	}
+
+	if *colorBy != "name" && *colorBy != "index" {
+		printErrAndExit(fmt.Sprintf("invalid --color-by %q (name, index)", *colorBy))
+	}
+	if *colorShuffle || *colorSeed != 0 {
+		seed := *colorSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("color seed: %d", seed)))
+		}
+		shuffleColors(seed)
 	}
 
-	ctx := context.Background()
-	ctx, _ = signal.NotifyContext(ctx, os.Interrupt)
-	// initialize signal handler after
-	go func() {
-		<-ctx.Done()
-		fmt.Fprintln(os.Stderr, gray("received exit signal"))
-	}()
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt)
+	sd := newShutdown(sig, *drainTimeout, os.Stderr)
+	ctx := sd.drain
 
-	if *workers < 0 {
-		printErrAndExit("-c < 0")
+	if *captureLimit < 0 {
+		printErrAndExit("--capture-limit < 0")
+	}
+	if *maxProcs != "" {
+		n, err := parseMaxProcs(*maxProcs, runtime.NumCPU())
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		concurrency.max = n
+	}
+	if *nice != 0 && !niceSupported {
+		printErrAndExit("--nice is not supported on this platform")
+	} else if *nice < -20 || *nice > 19 {
+		printErrAndExit("--nice must be between -20 and 19")
+	}
+	patterns := foreach.DefaultFailurePatterns
+	if *failurePatterns != "" {
+		patterns, err = loadFailurePatterns(*failurePatterns)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if *confirmSummary < 0 {
+		printErrAndExit("--confirm-summary < 0")
+	}
+	if *promptTimeout < 0 {
+		printErrAndExit("--prompt-timeout < 0")
+	}
+	if _, err := regexp.Compile(*groupBy); err != nil {
+		printErrAndExit(fmt.Sprintf("invalid --group-by: %v", err))
+	}
+	if _, err := regexp.Compile(*startAfter); err != nil {
+		printErrAndExit(fmt.Sprintf("invalid --start-after: %v", err))
+	}
+	if *kubectlPath != "" {
+		kubectlBin = *kubectlPath
+	} else if v := os.Getenv(envKubectl); v != "" {
+		kubectlBin = v
+	}
+	if _, err := exec.LookPath(kubectlBin); err != nil {
+		printErrAndExit(fmt.Sprintf("kubectl binary %q not found (set --kubectl or $%s): %v", kubectlBin, envKubectl, err))
+	}
+	if *sqlitePath != "" {
+		if _, err := exec.LookPath(sqliteBin); err != nil {
+			printErrAndExit(fmt.Sprintf("--sqlite needs the %s command: %v", sqliteBin, err))
+		}
+	}
+	if *wrapCmd != "" {
+		wrapper, err = splitCommand(*wrapCmd)
+		if err != nil {
+			printErrAndExit(fmt.Sprintf("failed to parse --wrap: %v", err))
+		}
+	}
+	allowed := *allowedVerbs
+	if allowed == "" {
+		allowed = os.Getenv(envAllowedVerbs)
+	}
+	if verbs := splitList(allowed); len(verbs) > 0 && kubectlArgs != nil {
+		if err := checkVerb(kubectlArgs, verbs); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	var verify func(string) []string
+	if *verifyCmd != "" {
+		verifyArgs, err := splitCommand(*verifyCmd)
+		if err != nil {
+			printErrAndExit(fmt.Sprintf("failed to parse --verify: %v", err))
+		}
+		if verbs := splitList(allowed); len(verbs) > 0 {
+			if err := checkVerb(verifyArgs, verbs); err != nil {
+				printErrAndExit(fmt.Sprintf("--verify: %v", err))
+			}
+		}
+		verify = foreach.ReplaceArgs(verifyArgs, repl...)
+	}
+	if (*replMapFile == "") != (*replMapToken == "") {
+		printErrAndExit("--repl-map and --repl-map-token must be used together")
+	}
+	if *replMapMissing != "error" && *replMapMissing != "context" {
+		printErrAndExit(fmt.Sprintf("invalid --repl-map-missing %q (error, context)", *replMapMissing))
+	}
+	if *paramsMissing != "error" && *paramsMissing != "skip" {
+		printErrAndExit(fmt.Sprintf("invalid --params-missing %q (error, skip)", *paramsMissing))
+	}
+	if *outputMode != "stream" && *outputMode != "grouped" {
+		printErrAndExit(fmt.Sprintf("invalid --output-mode %q (stream, grouped)", *outputMode))
+	}
+	if *keepOrder {
+		*outputMode = "grouped"
+	}
+	if *prefixField != "" && *prefixAnnotations != "" {
+		printErrAndExit("--prefix-field cannot be used with --prefix-annotations")
+	}
+	if *cmdTimeout < 0 {
+		printErrAndExit("--timeout < 0")
+	}
+	if *interruptGrace < 0 {
+		printErrAndExit("--interrupt-grace < 0")
+	}
+	if *startJitter < 0 {
+		printErrAndExit("--start-jitter < 0")
+	}
+	if *ramp < 0 {
+		printErrAndExit("--ramp < 0")
+	}
+	var mergeFormat string
+	if *mergeRes {
+		if mergeFormat = outputFormatOf(kubectlArgs); mergeFormat == "" {
+			printErrAndExit("--merge-resources needs a kubectl command with -o json or -o yaml")
+		}
+		if *baselineCtx != "" || *outputFormat != "" {
+			printErrAndExit("--merge-resources cannot be used with --baseline-context or --output")
+		}
+	}
+	if *baselineCtx != "" && *fdPerContext {
+		printErrAndExit("--baseline-context cannot be used with --fd-per-context")
+	}
+	for _, v := range append(append([]string{}, ctxPrefixes...), ctxSuffixes...) {
+		if v == "" {
+			printErrAndExit("empty string cannot be used with --prefix or --suffix")
+		}
+	}
+	if *retryPasses < 0 {
+		printErrAndExit("--retry-failed-passes < 0")
+	} else if *retryPasses > 0 && *baselineCtx != "" {
+		printErrAndExit("--retry-failed-passes cannot be used with --baseline-context")
+	}
+	if *maxFailures < 0 {
+		printErrAndExit("--max-failures < 0")
+	} else if *maxFailures > 0 && *retryPasses > 0 {
+		printErrAndExit("--max-failures cannot be used with --retry-failed-passes")
+	}
+	if *cmdRetries < 0 {
+		printErrAndExit("--retries < 0")
+	}
+	if *retryBackoff < 0 {
+		printErrAndExit("--retry-backoff < 0")
+	}
+	if *retryPassDelay < 0 {
+		printErrAndExit("--retry-pass-delay < 0")
+	}
+	if *acceptChanges && *expectFile == "" {
+		printErrAndExit("--accept-changes needs --expect-contexts-file")
+	}
+	if *sidecarFile != "" && (*outputMode == "grouped" || *tailLines > 0 || *fdPerContext || *baselineCtx != "" ||
+		*outputFormat != "" || *mergeRes) {
+		printErrAndExit("--prefix-sidecar cannot be used with --output-mode=grouped, --tail-buffer, --fd-per-context, " +
+			"--baseline-context, --output or --merge-resources")
+	}
+	if *tailLines < 0 {
+		printErrAndExit("--tail-buffer < 0")
+	} else if *tailLines > 0 && (*outputMode == "grouped" || *fdPerContext || *baselineCtx != "") {
+		printErrAndExit("--tail-buffer cannot be used with --output-mode=grouped, --fd-per-context or --baseline-context")
+	}
+	if *outputDir != "" {
+		if err := os.MkdirAll(*outputDir, 0o755); err != nil {
+			printErrAndExit(fmt.Sprintf("failed to create --output-dir: %v", err))
+		}
+	}
+	if *kubeconfigFlag != "" {
+		if hasFlag(kubectlArgs, "--kubeconfig") {
+			printErrAndExit("--kubeconfig cannot be used with a command that has --kubeconfig")
+		}
+		if _, err := os.Stat(*kubeconfigFlag); err != nil {
+			printErrAndExit(fmt.Sprintf("failed to read --kubeconfig: %v", err))
+		}
+		kubeconfigPath = *kubeconfigFlag
+	}
+	if *controlFile != "" {
+		if _, err := readControlFile(*controlFile); err != nil && !errors.Is(err, fs.ErrNotExist) {
+			printErrAndExit(err.Error())
+		}
+	}
+	if len(hashIgnoreFlag) > 0 && !*outputHash {
+		printErrAndExit("--hash-ignore needs --output-hash")
+	}
+	for _, v := range hashIgnoreFlag {
+		re, err := regexp.Compile(v)
+		if err != nil {
+			printErrAndExit(fmt.Sprintf("invalid --hash-ignore: %v", err))
+		}
+		hashIgnore = append(hashIgnore, re)
+	}
+	if *listOnly && (*shellArray != "" || *emitScriptFile != "") {
+		printErrAndExit("--list cannot be used with --shell-array or --emit-script")
+	}
+	if *checkOnly && (*listOnly || *shellArray != "" || *emitScriptFile != "") {
+		printErrAndExit("--check cannot be used with --list, --shell-array or --emit-script")
+	}
+	shuffle := *shuffleContexts || *shuffleSeed != 0
+	if *sortContexts && shuffle {
+		printErrAndExit("--sort cannot be used with --shuffle or --seed")
+	}
+	if (*sortContexts || shuffle) && (*orderBy != "" || *orderFile != "") {
+		printErrAndExit("--sort and --shuffle cannot be used with --order-by or --order-file")
+	}
+	if *interactiveSelect {
+		if *sortContexts || shuffle {
+			printErrAndExit("--interactive-select cannot be used with --sort or --shuffle")
+		}
+		if *orderBy != "" || *orderFile != "" || *snapshotSave != "" || *snapshotCompare != "" ||
+			*filterCmd != "" || *baselineCtx != "" || *fdPerContext || *replMapFile != "" || *paramsFile != "" {
+			printErrAndExit("--interactive-select cannot be used with --order-by, --order-file, --snapshot-save, " +
+				"--snapshot-compare, --filter-command, --baseline-context, --fd-per-context, --repl-map or --params-csv")
+		}
+		if !*quiet && !*yes && !stdinIsTerminal() {
+			printErrAndExit("--interactive-select needs a terminal on stdin")
+		}
+	}
+	if *interactivePick {
+		if *interactiveSelect {
+			printErrAndExit("--interactive cannot be used with --interactive-select")
+		}
+		if !stdinIsTerminal() {
+			printErrAndExit("--interactive needs a terminal on stdin")
+		}
+	}
+	if *contextsFrom != "" && *revalidate {
+		printErrAndExit("--revalidate cannot be used with --contexts-from")
+	}
+	if *outputFormat != "" && *outputFormat != "csv" && !jsonOutput() {
+		printErrAndExit(fmt.Sprintf("unsupported --output format %q (supported: csv, json, ndjson)", *outputFormat))
+	}
+	if *fullOutput && *outputFormat != "ndjson" {
+		printErrAndExit("--full-output needs --output=ndjson")
+	}
+	if *legendFormat != "" && *legendFormat != "json" {
+		printErrAndExit(fmt.Sprintf("unsupported --emit-legend format %q (supported: json)", *legendFormat))
+	}
+	if *replaceStdin {
+		if *contextsFrom == "-" {
+			printErrAndExit("--replace-stdin cannot be used with --contexts-from=-, which also reads stdin")
+		} else if stdinIsTerminal() {
+			printErrAndExit("--replace-stdin needs the input piped to stdin, not a terminal")
+		}
 	}
 
-	ctxs, err := kubeContexts(ctx)
+	var ctxs []string
+	if *contextsFrom != "" {
+		ctxs, err = readContextsFrom(*contextsFrom, os.Stdin)
+	} else {
+		ctxs, err = kubeContexts(ctx)
+	}
 	if err != nil {
 		printErrAndExit(err.Error())
 	}
-	var filters []filter
-
+	if *contextsURL != "" {
+		ctxs, err = contextsFromURL(ctx, ctxs)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
 	// re-parse flags to extract positional arguments of the tool, minus '--' + kubectl args
 	if err := fl.Parse(trimSuffix(os.Args[1:], append([]string{"--"}, kubectlArgs...))); err != nil {
 		printErrAndExit(err.Error())
 	}
-	for _, arg := range fl.Args() {
-		f, err := parseFilter(arg)
+	var fileFilters []foreach.Filter
+	if *selectFile != "" {
+		fileFilters, err = loadSelectFile(*selectFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	var sel selector
+	if *labelSelector != "" {
+		sel, err = parseSelector(*labelSelector)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	var details *contextDetails
+	loadDetails := func() contextDetails {
+		if details == nil {
+			d, err := loadContextDetails(ctx)
+			if err != nil {
+				printErrAndExit(err.Error())
+			}
+			details = &d
+		}
+		return *details
+	}
+
+	var cur *string
+	// resolve sets the fields of compound filters and the current context of
+	// @current filters in filters.
+	resolve := func(filters []foreach.Filter) {
+		if foreach.NeedsFields(filters) {
+			d := loadDetails()
+			for _, c := range foreach.Compounds(filters) {
+				c.Fields = d.field
+			}
+		}
+		if cs := foreach.Currents(filters); len(cs) > 0 {
+			if cur == nil {
+				v, err := currentContext(ctx)
+				if err != nil {
+					printErrAndExit(err.Error())
+				}
+				cur = &v
+			}
+			for _, c := range cs {
+				c.Name = *cur
+			}
+		}
+	}
+
+	// matchPatterns returns the contexts matched by the patterns, --select-file,
+	// --prefix, --suffix and --selector, without the current context with
+	// --exclude-current.
+	matchPatterns := func(patterns []string) ([]string, error) {
+		var filters []foreach.Filter
+		for _, arg := range patterns {
+			f, err := foreach.ParseFilter(arg, *ignoreCase)
+			if err != nil {
+				return nil, err
+			}
+			filters = append(filters, f)
+		}
+		filters = append(filters, fileFilters...)
+		if len(ctxPrefixes) > 0 || len(ctxSuffixes) > 0 {
+			filters = append(filters, foreach.Affix{Prefixes: ctxPrefixes, Suffixes: ctxSuffixes})
+		}
+		if *excludeCurrent {
+			filters = append(filters, foreach.Exclude{Filter: &foreach.Current{}})
+		}
+		resolve(filters)
+		out := foreach.MatchContexts(ctxs, filters)
+		if sel != nil {
+			out = sel.filter(out, loadDetails().labels)
+		}
+		return out, nil
+	}
+	ctxMatches, err := matchPatterns(fl.Args())
+	if err != nil {
+		printErrAndExit(err.Error())
+	}
+	if *interactivePick && len(ctxMatches) > 0 {
+		tty, err := openTTY()
+		if err != nil {
+			printErrAndExit(fmt.Sprintf("failed to open the terminal for --interactive: %v", err))
+		}
+		ctxMatches = newPicker(ctxMatches).pick(tty, tty)
+		tty.Close()
+		if len(ctxMatches) == 0 {
+			printErrAndExit("no contexts selected")
+		}
+	}
+	if len(focusPatterns) > 0 {
+		var filters []foreach.Filter
+		for _, arg := range focusPatterns {
+			f, err := foreach.ParseFilter(arg, *ignoreCase)
+			if err != nil {
+				printErrAndExit(fmt.Sprintf("invalid --focus: %v", err))
+			}
+			filters = append(filters, f)
+		}
+		resolve(filters)
+		focused = make(map[string]bool)
+		for _, c := range foreach.MatchContexts(ctxs, filters) {
+			focused[c] = true
+		}
+		if len(foreach.MatchContexts(ctxMatches, filters)) == 0 {
+			fmt.Fprintln(os.Stderr, gray("warning: --focus matches none of the matched contexts"))
+		}
+	}
+
+	var state runState
+	if *stateFile != "" {
+		state, err = loadState(*stateFile)
 		if err != nil {
 			printErrAndExit(err.Error())
 		}
-		filters = append(filters, f)
+	}
+	switch *orderBy {
+	case "":
+	case "last-duration":
+		if state == nil {
+			printErrAndExit("--order-by=last-duration requires --state-file")
+		}
+		if *orderMissing != "first" && *orderMissing != "last" {
+			printErrAndExit(fmt.Sprintf("invalid --order-missing value %q", *orderMissing))
+		}
+		ctxMatches = orderByDuration(ctxMatches, state, *orderMissing == "first")
+	default:
+		printErrAndExit(fmt.Sprintf("invalid --order-by value %q", *orderBy))
+	}
+	if *orderFile != "" {
+		if *orderUnlisted != "last" && *orderUnlisted != "drop" {
+			printErrAndExit(fmt.Sprintf("invalid --order-unlisted value %q", *orderUnlisted))
+		}
+		names, err := readOrderFile(*orderFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		ctxMatches, err = orderByList(ctxMatches, names, *orderUnlisted == "drop")
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if *sortContexts {
+		ctxMatches = sortedContexts(ctxMatches)
+	} else if shuffle {
+		seed := *shuffleSeed
+		if seed == 0 {
+			seed = time.Now().UnixNano()
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("shuffle seed: %d", seed)))
+		}
+		ctxMatches = shuffledContexts(ctxMatches, seed)
 	}
 
-	ctxMatches := matchContexts(ctxs, filters)
+	var kubeSnapshot snapshot // saved to --snapshot-save after the run
+	if *snapshotSave != "" || *snapshotCompare != "" {
+		ctxMatches, kubeSnapshot, err = handleSnapshots(ctx, ctxMatches)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	if *filterCmd != "" {
+		concurrency.resolve(len(ctxMatches)) // of the contexts to probe, resolved again before running
+		ctxMatches, err = probeContexts(ctx, ctxMatches)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	var ctxParams params
+	if *paramsFile != "" {
+		ctxParams, err = loadParams(*paramsFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		found, missing := withParams(ctxMatches, ctxParams)
+		if len(missing) > 0 && *paramsMissing == "error" {
+			printErrAndExit(fmt.Sprintf("context(s) not in --params-csv: %s", strings.Join(missing, ", ")))
+		} else if len(missing) > 0 {
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("warning: skipping context(s) not in --params-csv: %s", strings.Join(missing, ", "))))
+		}
+		ctxMatches = found
+	}
 
 	if len(ctxMatches) == 0 {
 		printErrAndExit("query matched no contexts from kubeconfig")
 	}
+	if err := checkExpected(ctxMatches); err != nil {
+		printErrAndExit(err.Error())
+	}
+	if *baselineCtx != "" {
+		ctxMatches, err = withBaseline(*baselineCtx, ctxMatches, ctxs)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	if *shellArray != "" {
+		if err := writeShellArray(os.Stdout, *shellArray, "contexts", ctxMatches); err != nil {
+			printErrAndExit(err.Error())
+		}
+		return
+	}
+
+	if *fdPerContext && len(contextFiles) < len(ctxMatches) {
+		printErrAndExit(fmt.Sprintf("--fd-per-context needs file descriptors 3 to %d open for %d context(s), found %d",
+			len(ctxMatches)+2, len(ctxMatches), len(contextFiles)))
+	}
+
+	if err := guardCurrent(ctx, ctxMatches); err != nil {
+		printErrAndExit(err.Error())
+	}
+	if err := checkKnown(ctx, ctxMatches); err != nil {
+		printErrAndExit(err.Error())
+	}
+
+	for _, r := range repl {
+		if r == "" {
+			continue
+		}
+		if bad := replTokenInFlags(kubectlArgs, r); len(bad) > 0 {
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("warning: -I=%s also replaces parts of %s, use a token that doesn't appear in flags (e.g. {})",
+				r, strings.Join(bad, ", "))))
+		}
+	}
+	argMaker := foreach.ReplaceArgs(kubectlArgs, repl...)
+	if *serverDryRun {
+		if hasDryRun(kubectlArgs) {
+			printErrAndExit("--server-dry-run cannot be used with a command that has --dry-run")
+		}
+		if !isMutating(kubectlArgs) {
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("warning: --server-dry-run has no effect on %q, which doesn't modify the cluster", kubectlVerb(kubectlArgs))))
+		}
+		argMaker = withFlag(argMaker, "--dry-run=server")
+	}
+	if *replMapFile != "" {
+		values, err := loadReplMap(*replMapFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		if missing := unmapped(ctxMatches, values); len(missing) > 0 && *replMapMissing == "error" {
+			printErrAndExit(fmt.Sprintf("context(s) not in --repl-map: %s", strings.Join(missing, ", ")))
+		}
+		argMaker = mapArgs(argMaker, *replMapToken, values)
+		if verify != nil {
+			verify = mapArgs(verify, *replMapToken, values)
+		}
+	}
+	if ctxParams != nil {
+		argMaker = paramArgs(argMaker, ctxParams)
+		if verify != nil {
+			verify = paramArgs(verify, ctxParams)
+		}
+	}
+
+	if *checkOnly {
+		concurrency.resolve(len(ctxMatches))
+		results, _ := runAll(sd.kill, ctxMatches, foreach.ReplaceArgs(checkArgs, ""), nil, func(s string) string { return s },
+			nil, nil, nil, sd, nil, io.Discard, &synchronizedWriter{Writer: os.Stderr})
+		_ = writeCheck(os.Stdout, results)
+		if n := unreachableCount(results); n > 0 {
+			printErrAndExit(fmt.Sprintf("%d of %d context(s) not reachable", n, len(results)))
+		}
+		return
+	}
+	if *listOnly {
+		var sample func(string) []string
+		if len(kubectlArgs) > 0 {
+			sample = argMaker
+		}
+		if err := writeList(os.Stdout, os.Stderr, ctxMatches, sample); err != nil {
+			printErrAndExit(err.Error())
+		}
+		return
+	}
+	if *emitScriptFile != "" {
+		if err := emitScript(*emitScriptFile, ctxMatches, argMaker, *scriptSet); err != nil {
+			printErrAndExit(err.Error())
+		}
+		fmt.Fprintf(os.Stderr, "Wrote commands for %d context(s) to %s\n", len(ctxMatches), *emitScriptFile)
+		return
+	}
+
+	c := confirmer{in: stdinFeed(), out: os.Stderr, message: *promptMessage, summaryOver: *confirmSummary, timeout: *promptTimeout}
+	if *groupBy != "" {
+		c.groupBy = regexp.MustCompile(*groupBy)
+	}
+	if c.summarized(len(ctxMatches)) {
+		c.expand = ctxMatches
+	}
+	if c.message == "" {
+		c.message = os.Getenv(envPromptMessage)
+	}
+	if !*yes {
+		c.preview(ctxMatches)
+	}
+	promptsDisabled := os.Getenv(envDisablePrompts) != "" || jsonUnattended
+	if *confirmMutating && isMutating(kubectlArgs) {
+		promptsDisabled = false
+	}
+	if *yes {
+		promptsDisabled = true
+	}
+	if *contextsFrom == "-" && !*quiet && !promptsDisabled {
+		printErrAndExit("--contexts-from=- reads the contexts from stdin, so the prompt can't be answered (use -q or -y)")
+	}
+	if *replaceStdin && !*quiet && !promptsDisabled {
+		printErrAndExit("--replace-stdin reads the input of kubectl from stdin, so the prompt can't be answered (use -q or -y)")
+	}
+	if *interactiveSelect && !*quiet && !promptsDisabled {
+		c.editor = &patternEditor{patterns: fl.Args(), rematch: matchPatterns, matches: ctxMatches}
+	}
+	if !*quiet && !promptsDisabled {
+		if err := c.confirm(ctx); errors.Is(err, errPromptTimeout) {
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("\naborted: no answer in %v (--prompt-timeout)", *promptTimeout)))
+			os.Exit(exitUserAborted)
+		} else if errors.Is(err, errNoAnswer) {
+			fmt.Fprintln(os.Stderr, gray("\naborted: stdin was closed without an answer"))
+			os.Exit(exitUserAborted)
+		} else if errors.Is(err, errUserRefused) {
+			fmt.Fprintln(os.Stderr, gray("aborted by user"))
+			os.Exit(exitUserAborted)
+		} else if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if c.editor != nil {
+		ctxMatches = c.editor.matches
+		if len(ctxMatches) == 0 {
+			printErrAndExit("query matched no contexts from kubeconfig")
+		}
+		if err := guardCurrent(ctx, ctxMatches); err != nil {
+			printErrAndExit(err.Error())
+		}
+		if err := checkKnown(ctx, ctxMatches); err != nil {
+			printErrAndExit(err.Error())
+		}
+		if err := checkExpected(ctxMatches); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	if *revalidate {
+		cur, err := kubeContexts(ctx)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		if missing := missingContexts(ctxMatches, cur); len(missing) > 0 {
+			printErrAndExit(fmt.Sprintf("context(s) no longer in kubeconfig: %s", strings.Join(missing, ", ")))
+		}
+	}
 
-	fmt.Fprintln(os.Stderr, "Will run command in context(s):")
-	for _, c := range ctxMatches {
-		fmt.Fprintf(os.Stderr, "%s", gray(fmt.Sprintf("  - %s\n", c)))
+	label := func(s string) string { return s }
+	if fields := splitList(*prefixAnnotations); len(fields) > 0 {
+		label = annotatedLabel(loadDetails(), fields)
+	} else if *prefixField != "" {
+		label = fieldLabel(loadDetails(), *prefixField)
 	}
-	if !*quiet && os.Getenv(envDisablePrompts) == "" {
-		fmt.Fprintf(os.Stderr, "Continue? [Y/n]: ")
-		if err := prompt(ctx, os.Stdin); err != nil {
+
+	if *legendFormat != "" {
+		if err := emitLegend(*legendFile, *legendFormat, ctxMatches); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	var cb *circuitBreaker
+	if *breakerThreshold < 0 {
+		printErrAndExit("--circuit-breaker-threshold < 0")
+	} else if *breakerThreshold > 0 {
+		kc, err := kubeConfig(ctx)
+		if err != nil {
 			printErrAndExit(err.Error())
 		}
+		cb = newCircuitBreaker(*breakerThreshold, kc)
+	}
+
+	var pl *providerLimiter
+	if *byProvider {
+		pl = newProviderLimiter(loadDetails().kubeconfig, providerCaps)
 	}
 
-	syncOut := &synchronizedWriter{Writer: os.Stdout}
+	var metrics *statsdClient
+	if *statsdAddr != "" {
+		metrics, err = newStatsdClient(*statsdAddr)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+
+	if *sidecarFile != "" {
+		var f *os.File
+		prefixSidecar, f, err = openSidecar(*sidecarFile)
+		if err != nil {
+			printErrAndExit(err.Error())
+		}
+		defer f.Close()
+	}
+
+	var out io.Writer = os.Stdout
+	if *outputFormat != "" || *mergeRes {
+		out = io.Discard
+	}
+	syncOut := &synchronizedWriter{Writer: out}
 	syncErr := &synchronizedWriter{Writer: os.Stderr}
+	if *outputFormat == "ndjson" {
+		resultStream = &synchronizedWriter{Writer: os.Stdout}
+	}
+	if *replaceStdin {
+		in, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			printErrAndExit(fmt.Sprintf("failed to read stdin: %v", err))
+		}
+		kubectlStdin = replaceInput(in, repl)
+	}
 
-	err = runAll(ctx, ctxMatches, replaceArgs(kubectlArgs, *repl), syncOut, syncErr)
+	concurrency.resolve(len(ctxMatches))
+	if concurrency.max > 0 && !concurrency.set {
+		n := *workers
+		if n > len(ctxMatches) {
+			n = len(ctxMatches)
+		}
+		fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("running in up to %d context(s) at a time (--max-procs=%s)", n, *maxProcs)))
+	}
+
+	var results []result
+	if *baselineCtx != "" {
+		base, baseErr := runAll(sd.kill, ctxMatches[:1], argMaker, verify, label, cb, pl, metrics, sd, patterns, io.Discard, syncErr)
+		if baseErr != nil {
+			printErrAndExit(fmt.Sprintf("baseline %v", baseErr))
+		}
+		results, err = runAll(sd.kill, ctxMatches[1:], argMaker, verify, label, cb, pl, metrics, sd, patterns, io.Discard, syncErr)
+		differ, diffErr := writeBaselineDiffs(syncOut, base[0], results)
+		if diffErr != nil {
+			printErrAndExit(diffErr.Error())
+		}
+		fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("%d of %d context(s) differ from baseline %q", differ, len(results), *baselineCtx)))
+		results = append(base, results...)
+	} else {
+		contextIndex = make(map[string]int, len(ctxMatches))
+		for i, c := range ctxMatches {
+			contextIndex[c] = i
+		}
+		results, err = runAll(sd.kill, ctxMatches, argMaker, verify, label, cb, pl, metrics, sd, patterns, syncOut, syncErr)
+		last, ran, reported := results, 1, 0 // results of the last pass, and the passes run and reported
+		for pass := 1; pass <= *retryPasses && err != nil && !sd.draining(); pass++ {
+			writePassResult(os.Stderr, pass, last)
+			reported = pass
+			if *retryPassDelay > 0 {
+				fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("waiting %v before pass %d", *retryPassDelay, pass+1)))
+				select {
+				case <-time.After(*retryPassDelay):
+				case <-sd.drain.Done():
+				}
+				if sd.draining() {
+					break
+				}
+			}
+			failed := failedContexts(results)
+			fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("--- pass %d of %d: running again in %d failed context(s) ---",
+				pass+1, 1+*retryPasses, len(failed))))
+			last, _ = runAll(sd.kill, failed, argMaker, verify, label, cb, pl, metrics, sd, patterns, syncOut, syncErr)
+			results = mergeRetried(results, last)
+			err = failures(results)
+			ran = pass + 1
+		}
+		if ran > 1 && reported != ran {
+			writePassResult(os.Stderr, ran, last)
+		}
+	}
+	if *summary && !jsonOutput() {
+		_ = writeSummary(os.Stderr, results)
+	}
+	if *aggregateErrors || *showAllErrors {
+		writeErrorSummary(os.Stderr, groupErrors(results, *showAllErrors))
+	}
+	if state != nil {
+		state.update(results, time.Now())
+		if err := state.save(*stateFile); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if *snapshotSave != "" {
+		if err := saveSnapshot(kubeSnapshot, results); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if *sqlitePath != "" {
+		now := time.Now()
+		if err := saveSQLite(context.Background(), *sqlitePath, newRunID(now), now, results, argMaker); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	switch *outputFormat {
+	case "csv":
+		if err := writeCSV(os.Stdout, results); err != nil {
+			printErrAndExit(err.Error())
+		}
+	case "json":
+		if err := writeJSON(os.Stdout, results); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if *mergeRes {
+		doc, errs := mergeResources(mergeFormat, results)
+		for _, e := range errs {
+			fmt.Fprintf(os.Stderr, "%s%s\n", red("error: "), e)
+		}
+		_, _ = os.Stdout.Write(doc)
+		if len(errs) > 0 && err == nil {
+			err = fmt.Errorf("failed to merge the output of all contexts: %w", errUnparsable)
+		}
+	}
+	if *htmlReport != "" {
+		if err := saveHTMLReport(*htmlReport, kubectlArgs, results); err != nil {
+			printErrAndExit(err.Error())
+		}
+	}
+	if commandFailed(err) && minSuccess.met(results) {
+		fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("%s (ignored: --min-success=%s met)", err, minSuccess.String())))
+		return
+	}
 	if err != nil {
-		printErrAndExit(err.Error())
+		printErrAndExitCode(exitCode(err), err.Error())
 	}
 }
 
-func replaceArgs(args []string, repl string) func(ctx string) []string {
-	return func(ctx string) []string {
-		if repl == "" {
-			return append([]string{"--context=" + ctx}, args...)
+// replTokenInFlags returns the arguments that look like flags and contain the
+// -I token in their name (before any "="), which replaceArgs would corrupt,
+// e.g. "--namespace" with -I=n.
+func replTokenInFlags(args []string, repl string) []string {
+	var out []string
+	for _, a := range args {
+		if !strings.HasPrefix(a, "-") || a == repl {
+			continue
 		}
-		out := make([]string, len(args))
-		for i := range args {
-			out[i] = strings.Replace(args[i], repl, ctx, -1)
+		name, _, _ := strings.Cut(a, "=")
+		if strings.Contains(name, repl) {
+			out = append(out, a)
 		}
-		return out
 	}
+	return out
 }
 
 func kubeContexts(ctx context.Context) ([]string, error) {
-	cmd := exec.CommandContext(ctx, "kubectl", "config", "get-contexts", "-o=name")
-	var b bytes.Buffer
-	cmd.Stdout = &b
-	cmd.Stderr = os.Stderr // TODO might be redundant
-	if err := cmd.Run(); err != nil {
-		return nil, fmt.Errorf("failed to get contexts: %w", err)
-	}
-	return strings.Split(strings.TrimSpace(b.String()), "\n"), nil
+	return foreach.Contexts(ctx, configCmd, os.Stderr) // TODO stderr might be redundant
+}
+
+// handleSnapshots compares kubeconfig against the --snapshot-compare file and
+// returns the added or changed contexts in kubeCtxs, along with the current
+// state of kubeconfig for saveSnapshot.
+func handleSnapshots(ctx context.Context, kubeCtxs []string) ([]string, snapshot, error) {
+	kc, err := kubeConfig(ctx)
+	if err != nil {
+		return nil, nil, err
+	}
+	cur := newSnapshot(kc)
+	if *snapshotCompare != "" {
+		old, err := loadSnapshot(*snapshotCompare)
+		if err != nil {
+			return nil, nil, err
+		}
+		d := diffSnapshots(old, cur)
+		fmt.Fprintf(os.Stderr, "Contexts changed since snapshot %s:\n", *snapshotCompare)
+		d.write(os.Stderr)
+		kubeCtxs = d.targets(kubeCtxs)
+	}
+	return kubeCtxs, cur, nil
 }
 
-func runAll(ctx context.Context, kubeCtxs []string, argMaker func(string) []string, stdout, stderr io.Writer) error {
-	n := len(kubeCtxs)
-	if *workers > 0 {
-		n = *workers
+// saveSnapshot saves the state of kubeconfig in cur to the --snapshot-save
+// file after a run, for the contexts in results that succeeded. The other
+// contexts keep their state in the file, if any.
+func saveSnapshot(cur snapshot, results []result) error {
+	old, err := loadSnapshot(*snapshotSave)
+	if errors.Is(err, fs.ErrNotExist) {
+		old = snapshot{}
+	} else if err != nil {
+		return err
 	}
+	return old.update(cur, results).save(*snapshotSave)
+}
 
-	wg, _ := errgroup.WithContext(ctx)
-	wg.SetLimit(n)
+// loadContextDetails reads the kubeconfig and the labels of contexts from the
+// --context-metadata files, if specified.
+func loadContextDetails(ctx context.Context) (contextDetails, error) {
+	var d contextDetails
+	kc, err := kubeConfig(ctx)
+	if err != nil {
+		return d, err
+	}
+	d.kubeconfig = kc
+	var ms []metadata
+	for _, f := range contextMetadata {
+		m, err := loadMetadata(f)
+		if err != nil {
+			return d, err
+		}
+		ms = append(ms, m)
+	}
+	var onConflict func(ctx, key, old, new string)
+	if *warnConflicts {
+		onConflict = func(ctx, key, old, new string) {
+			fmt.Fprintf(os.Stderr, "%s", gray(fmt.Sprintf("warning: label %q of context %q is overridden (%q -> %q)\n", key, ctx, old, new)))
+		}
+	}
+	d.labels = mergeMetadata(ms, onConflict)
+	return d, nil
+}
 
-	maxLen := maxLen(kubeCtxs)
-	leftPad := func(s string, origLen int) string {
-		return strings.Repeat(" ", maxLen-origLen) + s
+// probeContexts returns the contexts in kubeCtxs where --filter-command
+// succeeds.
+func probeContexts(ctx context.Context, kubeCtxs []string) ([]string, error) {
+	check := func(kctx string) (bool, error) { return runProbe(ctx, *filterCmd, kctx, os.Stderr) }
+	if *filterCacheTTL > 0 {
+		dir := *filterCacheDir
+		if dir == "" {
+			d, err := os.UserCacheDir()
+			if err != nil {
+				return nil, fmt.Errorf("failed to find cache directory: %w", err)
+			}
+			dir = filepath.Join(d, "kubectl-foreach", "filter")
+		}
+		check = cachedProbe(dir, *filterCmd, *filterCacheTTL, time.Now(), check)
 	}
+	return filterByProbe(kubeCtxs, *workers, check)
+}
 
+// contextsFromURL returns the contexts in kubeCtxs listed by --contexts-from-url.
+func contextsFromURL(ctx context.Context, kubeCtxs []string) ([]string, error) {
+	fetch := func() ([]byte, error) { return fetchContextNames(ctx, *contextsURL, urlHeaders, *urlTimeout) }
+	if *urlCacheTTL > 0 {
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return nil, fmt.Errorf("failed to find cache directory: %w", err)
+		}
+		dir = filepath.Join(dir, "kubectl-foreach", "contexts")
+		fetch = func() ([]byte, error) {
+			return cachedFetch(dir, urlCacheKey(*contextsURL, urlHeaders), *urlCacheTTL, time.Now(), func() ([]byte, error) {
+				return fetchContextNames(ctx, *contextsURL, urlHeaders, *urlTimeout)
+			})
+		}
+	}
+	b, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	names, err := parseContextNames(b)
+	if err != nil {
+		return nil, err
+	}
+	if missing := missingContexts(names, kubeCtxs); len(missing) > 0 {
+		fmt.Fprintln(os.Stderr, gray(fmt.Sprintf("warning: context(s) from url not in kubeconfig: %s", strings.Join(missing, ", "))))
+	}
+	return intersectContexts(kubeCtxs, names), nil
+}
+
+// missingContexts returns the contexts in want that are not in have.
+func missingContexts(want, have []string) []string {
+	m := make(map[string]bool, len(have))
+	for _, v := range have {
+		m[v] = true
+	}
+	var out []string
+	for _, v := range want {
+		if !m[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// jsonOutput reports whether the results are printed as JSON, with
+// --output=json or ndjson.
+func jsonOutput() bool {
+	return *outputFormat == "json" || *outputFormat == "ndjson"
+}
+
+// captureOutput reports whether the output of commands should be kept in
+// results.
+func captureOutput() bool {
+	return *htmlReport != ""
+}
+
+// runAll runs the command in all contexts with foreach.Run, even if it fails
+// in some, and returns the results in the same order, along with a
+// contextFailures error if it failed in any. If verify is not nil, the command
+// it returns is run after the command succeeds in a context, and the context
+// fails if it fails.
+func runAll(ctx context.Context, kubeCtxs []string, argMaker, verify func(string) []string, label func(string) string,
+	cb *circuitBreaker, pl *providerLimiter, metrics *statsdClient, sd *shutdown, patterns []foreach.FailurePattern, stdout, stderr io.Writer) ([]result, error) {
+	opts := foreach.Options{
+		Contexts:        kubeCtxs,
+		ArgsFunc:        argMaker,
+		Verify:          verify,
+		Stdin:           kubectlStdin,
+		Runner:          runCmd,
+		Concurrency:     *workers,
+		Ramp:            *ramp,
+		StartJitter:     *startJitter,
+		Timeout:         *cmdTimeout,
+		Retries:         *cmdRetries,
+		RetryBackoff:    *retryBackoff,
+		SuccessCodes:    successCodes.codes(),
+		MaxFailures:     *maxFailures,
+		FailOnEmpty:     *failOnEmpty,
+		EmptyWhitespace: *emptyWhitespace,
+		SkipUnreachable: *skipUnreachable,
+		Stdout:          stdout,
+		Stderr:          stderr,
+		Timestamps:      *timestamps,
+		Grouped:         *outputMode == "grouped",
+		KeepOrder:       *keepOrder,
+		SanitizeControl: *sanitizeControl,
+		NoteStyle:       func(s string) string { return gray(s) },
+		OutputLimit:     *captureLimit,
+		Hash:            *outputHash,
+		HashIgnore:      hashIgnore,
+	}
+	if cb != nil {
+		opts.Breaker = cb
+	}
+	if pl != nil {
+		opts.Acquire = pl.acquire
+	}
+	if sd != nil {
+		opts.Drain = sd.drain
+	}
+	if *startAfter != "" {
+		opts.StartAfter = regexp.MustCompile(*startAfter)
+	}
+	if *classifyFailures {
+		opts.FailurePatterns = patterns
+	}
+	if captureOutput() && *captureLimit > 0 {
+		opts.Capture |= foreach.CaptureOutput
+	}
+	if *baselineCtx != "" || *mergeRes || jsonOutput() {
+		opts.Capture |= foreach.CaptureStdout
+	}
+	if *skipUnreachable || *classifyFailures || *aggregateErrors || *showAllErrors || jsonOutput() {
+		opts.Capture |= foreach.CaptureStderr
+	}
+	if jsonOutput() {
+		opts.Stderr = io.Discard // it's in the results
+	}
+	if *controlFile != "" {
+		limit := *workers
+		if v, err := readControlFile(*controlFile); err == nil {
+			limit = v
+		}
+		opts.Semaphore = foreach.NewSemaphore(limit)
+		watchCtx, stopWatch := context.WithCancel(ctx)
+		defer stopWatch()
+		go watchControlFile(watchCtx, *controlFile, controlPollInterval, opts.Semaphore, stderr)
+	}
+
+	labels := make([]string, len(kubeCtxs))
 	for i, kctx := range kubeCtxs {
-		kctx := kctx
-		ctx := ctx
-		i := i
-		colFn := colors[i%len(colors)]
-		wg.Go(func() error {
-			prefix := []byte(leftPad(colFn(kctx), len(kctx)) + " | ")
-			wo := &prefixingWriter{prefix: prefix, w: stdout}
-			we := &prefixingWriter{prefix: prefix, w: stderr}
-			return run(ctx, argMaker(kctx), wo, we)
-		})
-	}
-	return wg.Wait()
+		labels[i] = label(kctx)
+	}
+	maxLen := maxLen(labels)
+	// pos returns the position of the ith context in the first run
+	pos := func(i int) int {
+		if j, ok := contextIndex[kubeCtxs[i]]; ok {
+			return j
+		}
+		return i
+	}
+	opts.Prefix = func(i int, kctx string) string {
+		colFn := focusColor(colors[colorIndex(pos(i), kctx)], kctx)
+		return strings.Repeat(" ", maxLen-len(labels[i])) + colFn(labels[i]) + " | "
+	}
+
+	var tails []*tailBuffer
+	if *tailLines > 0 {
+		tails = make([]*tailBuffer, len(kubeCtxs))
+		for i := range tails {
+			tails[i] = newTailBuffer(*tailLines)
+		}
+		v := tailViewer{contexts: kubeCtxs, tails: tails, out: stdout}
+		done := make(chan struct{})
+		defer close(done)
+		if stdinIsTerminal() {
+			hint := fmt.Sprintf("output is kept in memory, type a context name and press enter to show its last %d line(s)", *tailLines)
+			if tailDumpSignal != "" {
+				hint += fmt.Sprintf(" (or send %s to show all)", tailDumpSignal)
+			}
+			fmt.Fprintln(stderr, gray(hint))
+			go v.watch(stdinFeed().reader(done), done)
+		}
+		sig := make(chan os.Signal, 1)
+		notifyTailDump(sig)
+		defer signal.Stop(sig)
+		go func() {
+			for {
+				select {
+				case <-done:
+					return
+				case <-sig:
+					v.dumpAll()
+				}
+			}
+		}()
+		opts.Print = func(i int, _ string) (io.Writer, io.Writer) {
+			if jsonOutput() {
+				return tails[i], io.Discard
+			}
+			return tails[i], tails[i]
+		}
+	}
+
+	var fileNames []string
+	if *outputDir != "" {
+		fileNames = outputFileNames(kubeCtxs)
+	}
+	forced := make([]bool, len(kubeCtxs)) // cancelled when the drain timeout expired
+	opts.Output = func(i int, kctx string, wo, we io.Writer) (io.Writer, io.Writer, func(foreach.Result), error) {
+		var closers []func() error
+		if prefixSidecar != nil {
+			sw := &sidecarWriter{s: prefixSidecar, context: kctx, w: stdout}
+			closers = append(closers, sw.flush)
+			wo = sw
+		}
+		if contextFiles != nil {
+			wo = contextFiles[pos(i)]
+		}
+		if fileNames != nil {
+			f, err := createOutputFile(*outputDir, fileNames[i])
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			closers = append(closers, f.Close)
+			fw := &synchronizedWriter{Writer: f}
+			wo, we = io.MultiWriter(wo, fw), io.MultiWriter(we, fw)
+		}
+		return wo, we, func(r foreach.Result) {
+			if r.Attempts > 0 && r.Err != nil && sd.forced() {
+				forced[i] = true
+				fmt.Fprintln(we, gray("cancelled: drain timeout expired"))
+			}
+			for _, c := range closers {
+				_ = c()
+			}
+		}, nil
+	}
+	ndjsonLimit := *captureLimit
+	if *fullOutput {
+		ndjsonLimit = -1
+	}
+	opts.OnResult = func(i int, r foreach.Result) {
+		res := newResult(r)
+		if tails != nil {
+			lines, total := tails[i].tail()
+			var b strings.Builder
+			if len(lines) < total {
+				b.WriteString(opts.Prefix(i, r.Context) + gray(fmt.Sprintf("(%d earlier line(s) not shown)", total-len(lines))) + "\n")
+			}
+			for _, l := range lines {
+				b.WriteString(l)
+			}
+			_, _ = io.WriteString(stdout, b.String())
+		}
+		if r.Attempts > 0 {
+			metrics.observe(res, kubectlVerb(argMaker(r.Context)))
+		}
+		if resultStream != nil {
+			_ = writeNDJSON(resultStream, res, ndjsonLimit)
+		}
+	}
+
+	runResults, err := foreach.Run(ctx, opts)
+	var failed foreach.Failures
+	if err != nil && !errors.As(err, &failed) {
+		return nil, err
+	}
+	results := make([]result, len(runResults))
+	for i, r := range runResults {
+		results[i] = newResult(r)
+	}
+	err = failures(results)
+	var cancelled []string
+	for i, v := range forced {
+		if v {
+			cancelled = append(cancelled, kubeCtxs[i])
+		}
+	}
+	if s := reasonCounts(results); s != "" {
+		fmt.Fprintln(stderr, gray("failures: "+s))
+	}
+	if len(cancelled) > 0 {
+		fmt.Fprintln(stderr, gray(fmt.Sprintf("cancelled at drain timeout: %s", strings.Join(cancelled, ", "))))
+	}
+	var failedCount int
+	var skipped []string
+	for _, r := range results {
+		if r.status() == statusFailed {
+			failedCount++
+		} else if errors.Is(r.err, foreach.ErrMaxFailures) {
+			skipped = append(skipped, r.context)
+		}
+	}
+	if *maxFailures > 0 && failedCount >= *maxFailures {
+		msg := fmt.Sprintf("aborted the run after %d context(s) failed (--max-failures)", *maxFailures)
+		if len(skipped) > 0 {
+			msg += ", not run in: " + strings.Join(skipped, ", ")
+		}
+		fmt.Fprintln(stderr, red("error: ")+msg)
+	}
+	return results, err
+}
+
+// exitCode returns the exit code of the tool for an error returned from runAll.
+func exitCode(err error) int {
+	var failures contextFailures
+	if errors.As(err, &failures) {
+		for _, r := range failures {
+			if exitCode(r.err) == exitSetupError {
+				return exitSetupError
+			}
+		}
+		if n := len(failures) + 1; n < exitManyFailed {
+			return n
+		}
+		return exitManyFailed
+	}
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) || errors.Is(err, foreach.ErrSkipped) || errors.Is(err, foreach.ErrEmptyOutput) ||
+		errors.Is(err, errUnparsable) || errors.Is(err, foreach.ErrTimeout) {
+		return exitCommandFailed
+	}
+	return exitSetupError
 }
 
 func maxLen(s []string) int {
@@ -219,49 +2072,178 @@ func maxLen(s []string) int {
 	return max
 }
 
-func run(ctx context.Context, args []string, stdout, stderr io.Writer) (err error) {
-	cmd := exec.CommandContext(ctx, "kubectl", args...)
+func run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) (err error) {
+	argv := commandArgv(wrapper, args)
+	cmd := exec.Command(argv[0], argv[1:]...)
+	cmd.Stdin = stdin
+	if *pipeCmd != "" {
+		return runPiped(ctx, cmd, *pipeCmd, stdout, stderr)
+	}
 	cmd.Stdout = stdout
 	cmd.Stderr = stderr
-	return cmd.Run()
+	if err := startCmd(cmd); err != nil {
+		return err
+	}
+	return foreach.Wait(ctx, cmd, *interruptGrace)
 }
 
-// prompt returns an error if user rejects or if ctx cancels.
-func prompt(ctx context.Context, r io.Reader) error {
-	pr, pw := io.Pipe()
-	go func() {
-		if _, err := io.Copy(pw, r); err != nil {
-			pw.Close()
-		}
-	}()
-	defer pw.Close()
-
-	scanDone := make(chan error, 1)
-
-	go func() {
-		s := bufio.NewScanner(pr)
-		for s.Scan() {
-			if err := s.Err(); err != nil {
-				scanDone <- err
-				return
+// startCmd starts cmd in its own process group and sets its niceness from
+// --nice.
+func startCmd(cmd *exec.Cmd) error {
+	setProcessGroup(cmd)
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	if *nice != 0 {
+		if err := setNice(cmd.Process.Pid, *nice); err != nil {
+			_ = cmd.Process.Kill()
+			_ = cmd.Wait()
+			return fmt.Errorf("failed to set niceness: %w", err)
+		}
+	}
+	return nil
+}
+
+// confirmer shows the matched contexts and asks the user to confirm running
+// the command in them.
+type confirmer struct {
+	in      io.Reader // answers are read from here
+	out     io.Writer // questions are written here
+	message string    // shown before the contexts, if not empty
+
+	summaryOver int            // show counts of groups instead of more contexts than this (0: never)
+	groupBy     *regexp.Regexp // groups of contexts in the summary, if not nil
+	expand      []string       // contexts listed if the user answers "l", if not nil
+	editor      *patternEditor // edits the patterns if the user answers "e" (and lists its matches on "l"), if not nil
+	timeout     time.Duration  // refuse if the prompt isn't answered in this long (0: never)
+}
+
+// summarized reports whether the preview of n contexts shows a summary.
+func (c confirmer) summarized(n int) bool {
+	return c.summaryOver > 0 && n > c.summaryOver
+}
+
+func (c confirmer) preview(kubeCtxs []string) {
+	if c.message != "" {
+		fmt.Fprintln(c.out, strings.TrimRight(c.message, "\n"))
+	}
+	if c.summarized(len(kubeCtxs)) {
+		fmt.Fprintf(c.out, "Will run command in %d context(s):\n", len(kubeCtxs))
+		if c.groupBy != nil {
+			for _, g := range groupCounts(c.groupBy, kubeCtxs) {
+				fmt.Fprintf(c.out, "%s", gray(fmt.Sprintf("  %s: %d\n", g.key, g.count)))
 			}
-			v := s.Text()
-			if v == "y" || v == "Y" || v == "" {
-				scanDone <- nil
-				return
+		}
+		return
+	}
+	fmt.Fprintln(c.out, "Will run command in context(s):")
+	c.list(kubeCtxs)
+}
+
+func (c confirmer) list(kubeCtxs []string) {
+	for _, v := range kubeCtxs {
+		fmt.Fprintf(c.out, "%s", gray(fmt.Sprintf("  - %s\n", v)))
+	}
+}
+
+// confirm returns an error if user rejects or if ctx cancels.
+func (c confirmer) confirm(ctx context.Context) error {
+	actions := make(map[string]func(*bufio.Scanner))
+	q := "Continue? [Y/n"
+	if c.expand != nil || c.editor != nil {
+		q += "/l(ist contexts)"
+		actions["l"] = func(*bufio.Scanner) {
+			if c.editor != nil {
+				c.list(c.editor.matches)
+			} else {
+				c.list(c.expand)
 			}
-			break
+			fmt.Fprint(c.out, q)
+		}
+	}
+	if c.editor != nil {
+		q += "/e(dit patterns)"
+		actions["e"] = func(s *bufio.Scanner) {
+			c.editor.edit(s, c.out)
+			c.preview(c.editor.matches)
+			fmt.Fprint(c.out, q)
 		}
-		scanDone <- errors.New("user refused execution")
-	}()
+	}
+	q += "]: "
+	fmt.Fprint(c.out, q)
+	return promptWithActions(ctx, c.in, actions, c.timeout)
+}
+
+// errUserRefused is returned from prompt when the user rejects.
+var errUserRefused = errors.New("user refused execution")
+
+// errPromptTimeout is returned from prompt when it's not answered in time, and
+// is also an errUserRefused.
+var errPromptTimeout = fmt.Errorf("no answer to the prompt: %w", errUserRefused)
 
+// errNoAnswer is returned from prompt when stdin is closed before an answer, and
+// is also an errUserRefused.
+var errNoAnswer = fmt.Errorf("stdin closed without an answer to the prompt: %w", errUserRefused)
+
+// prompt returns an error if user rejects or if ctx cancels.
+func prompt(ctx context.Context, r io.Reader) error {
+	return promptWithActions(ctx, r, nil, 0)
+}
+
+// promptWithActions is like prompt, but when the user answers with a key of
+// actions (in any case), it calls the action, which can read more lines from
+// the scanner, and reads another answer. If timeout is not 0, it returns
+// errPromptTimeout if there's no answer in that long.
+func promptWithActions(ctx context.Context, r io.Reader, actions map[string]func(*bufio.Scanner), timeout time.Duration) error {
+	feed, ok := r.(*lineFeed)
+	if !ok {
+		feed = newLineFeed(r)
+	}
+	stop := make(chan struct{}) // leaves the lines after the answer to the next reader of feed
+	defer close(stop)
+
+	scanDone := make(chan error, 1) // gets the only result of scanAnswer
+	go func() { scanDone <- scanAnswer(bufio.NewScanner(feed.reader(stop)), actions) }()
+
+	var expired <-chan time.Time
+	if timeout > 0 {
+		t := time.NewTimer(timeout)
+		defer t.Stop()
+		expired = t.C
+	}
 	select {
 	case res := <-scanDone:
 		return res
 	case <-ctx.Done():
-		pr.Close()
 		return fmt.Errorf("prompt canceled")
+	case <-expired:
+		return errPromptTimeout
+	}
+}
+
+// scanAnswer reads answers to the prompt from s until one accepts ("y", "yes"
+// or empty, in any case) or refuses ("n", "no", or anything else that's not
+// a key of actions, to be safe). It returns nil if accepted, errUserRefused if
+// refused, errNoAnswer at EOF, or the error of reading.
+func scanAnswer(s *bufio.Scanner, actions map[string]func(*bufio.Scanner)) error {
+	for s.Scan() {
+		v := strings.ToLower(strings.TrimSpace(s.Text()))
+		switch v {
+		case "", "y", "yes":
+			return nil
+		case "n", "no":
+			return errUserRefused
+		}
+		action, ok := actions[v]
+		if !ok {
+			return errUserRefused
+		}
+		action(s)
+	}
+	if err := s.Err(); err != nil {
+		return err
 	}
+	return errNoAnswer
 }
 
 func trimSuffix(a []string, suffix []string) []string {
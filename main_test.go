@@ -15,14 +15,27 @@
 package main
 
 import (
+	"bufio"
+	"bytes"
 	"context"
 	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"runtime"
+	"sort"
 	"strings"
+	"sync"
 	"testing"
 	"testing/iotest"
 	"time"
 
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestTrimSuffix(t *testing.T) {
@@ -61,6 +74,7 @@ func TestPrompt(t *testing.T) {
 
 		err = prompt(context.Background(), strings.NewReader("J\n"))
 		assert.EqualError(t, err, "user refused execution")
+		assert.ErrorIs(t, err, errUserRefused)
 	})
 
 	t.Run("user accept", func(t *testing.T) {
@@ -72,6 +86,53 @@ func TestPrompt(t *testing.T) {
 	t.Run("faulty reader", func(t *testing.T) {
 		assert.Error(t, prompt(context.TODO(), iotest.ErrReader(errors.New("phony error"))))
 	})
+
+	t.Run("answers", func(t *testing.T) {
+		for in, want := range map[string]error{
+			"y\n":         nil,
+			"Y\n":         nil,
+			"yes\n":       nil,
+			"YES\n":       nil,
+			"\n":          nil,
+			"  y \r\n":    nil,
+			"y":           nil, // no newline before EOF
+			"n\n":         errUserRefused,
+			"N\n":         errUserRefused,
+			"no\n":        errUserRefused,
+			"No\ny\n":     errUserRefused, // the first answer counts
+			"maybe\n":     errUserRefused,
+			"":            errNoAnswer,
+			"l\n":         errUserRefused, // not an action here
+			"interrupted": errUserRefused,
+		} {
+			assert.Equal(t, want, prompt(context.TODO(), strings.NewReader(in)), "%q", in)
+		}
+	})
+
+	t.Run("EOF", func(t *testing.T) {
+		err := prompt(context.TODO(), strings.NewReader(""))
+		assert.ErrorIs(t, err, errNoAnswer)
+		assert.ErrorIs(t, err, errUserRefused)
+	})
+
+	t.Run("actions then EOF", func(t *testing.T) {
+		var listed int
+		actions := map[string]func(*bufio.Scanner){"l": func(*bufio.Scanner) { listed++ }}
+		assert.Equal(t, errNoAnswer, promptWithActions(context.TODO(), strings.NewReader("l\nL\n"), actions, 0))
+		assert.Equal(t, 2, listed)
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		ch := make(chan struct{})
+		defer close(ch)
+		start := time.Now()
+		err := promptWithActions(context.Background(), blockingReader{ch}, nil, 50*time.Millisecond)
+		assert.ErrorIs(t, err, errPromptTimeout)
+		assert.ErrorIs(t, err, errUserRefused, "treated as a refusal")
+		assert.GreaterOrEqual(t, time.Since(start), 50*time.Millisecond)
+
+		assert.NoError(t, promptWithActions(context.Background(), strings.NewReader("y\n"), nil, time.Minute))
+	})
 }
 
 type blockingReader struct{ close <-chan struct{} }
@@ -82,17 +143,612 @@ func (b blockingReader) Read(p []byte) (n int, err error) {
 
 }
 
-func Test_replaceArgs(t *testing.T) {
-	t.Run("no replace", func(t *testing.T) {
-		assert.Equal(t, []string{"--context=ctx"}, replaceArgs(nil, "")("ctx"))
-		assert.Equal(t, []string{"--context=ctx", "arg1", "arg2"}, replaceArgs([]string{"arg1", "arg2"}, "")("ctx"))
+func Test_replTokenInFlags(t *testing.T) {
+	args := []string{"get", "pods", "--namespace=foo", "-n", "bar", "--context=n"}
+	// -I=n corrupts the flags
+	assert.Equal(t, []string{"get", "pods", "--ctxamespace=foo", "-ctx", "bar", "--coctxtext=ctx"}, foreach.ReplaceArgs(args, "n")("ctx"))
+	assert.Equal(t, []string{"--namespace=foo", "-n", "--context=n"}, replTokenInFlags(args, "n"))
+
+	assert.Equal(t, []string{"--context=-"}, replTokenInFlags([]string{"get", "-", "--context=-"}, "-"))
+	assert.Empty(t, replTokenInFlags([]string{"--context=_", "get", "pods", "-l", "app=_"}, "_"))
+	assert.Empty(t, replTokenInFlags([]string{"tail", "--context={}", "{}"}, "{}"))
+}
+
+func Test_exitCode(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	assert.Equal(t, exitCommandFailed, exitCode(exitErr))
+	assert.Equal(t, exitCommandFailed, exitCode(fmt.Errorf("wrapped: %w", exitErr)))
+	assert.Equal(t, exitCommandFailed, exitCode(fmt.Errorf("wrapped: %w", foreach.ErrSkipped)))
+	assert.Equal(t, exitCommandFailed, exitCode(fmt.Errorf("wrapped: %w", foreach.ErrEmptyOutput)))
+
+	execErr := exec.Command("kubectl-foreach-nonexistent-binary").Run()
+	assert.Equal(t, exitSetupError, exitCode(execErr))
+	assert.Equal(t, exitSetupError, exitCode(errors.New("phony error")))
+}
+
+func TestConfirmer(t *testing.T) {
+	t.Run("preview", func(t *testing.T) {
+		var out bytes.Buffer
+		confirmer{out: &out}.preview([]string{"a", "b"})
+		assert.Contains(t, out.String(), "Will run command in context(s):\n")
+		assert.Contains(t, out.String(), "  - a\n")
+		assert.Contains(t, out.String(), "  - b\n")
+	})
+	t.Run("preview with message", func(t *testing.T) {
+		var out bytes.Buffer
+		confirmer{out: &out, message: "WARNING: production!\nFollow the change process.\n"}.preview([]string{"a"})
+		assert.True(t, strings.HasPrefix(out.String(),
+			"WARNING: production!\nFollow the change process.\nWill run command in context(s):\n"), out.String())
+	})
+	t.Run("summary", func(t *testing.T) {
+		var out bytes.Buffer
+		c := confirmer{out: &out, summaryOver: 2, groupBy: regexp.MustCompile(`^([a-z]+)-`)}
+		c.preview([]string{"prod-a", "dev-a", "prod-b"})
+		assert.Equal(t, "Will run command in 3 context(s):\n  prod: 2\n  dev: 1\n", out.String())
+
+		out.Reset()
+		c.preview([]string{"prod-a", "dev-a"})
+		assert.Equal(t, "Will run command in context(s):\n  - prod-a\n  - dev-a\n", out.String(), "under the threshold")
+	})
+	t.Run("list after summary", func(t *testing.T) {
+		var out bytes.Buffer
+		c := confirmer{in: strings.NewReader("l\ny\n"), out: &out, expand: []string{"a", "b"}}
+		assert.NoError(t, c.confirm(context.Background()))
+		q := "Continue? [Y/n/l(ist contexts)]: "
+		assert.Equal(t, q+"  - a\n  - b\n"+q, out.String())
+
+		c = confirmer{in: strings.NewReader("l\n"), out: io.Discard}
+		assert.EqualError(t, c.confirm(context.Background()), "user refused execution", "no list to expand")
+	})
+	t.Run("edit patterns", func(t *testing.T) {
+		var out bytes.Buffer
+		e := &patternEditor{patterns: []string{"a"}, matches: []string{"a"},
+			rematch: func(p []string) ([]string, error) { return p, nil }}
+		c := confirmer{in: strings.NewReader("e\n+b\n\nY\n"), out: &out, editor: e}
+		assert.NoError(t, c.confirm(context.Background()))
+		assert.Equal(t, []string{"a", "b"}, e.matches)
+		q := "Continue? [Y/n/l(ist contexts)/e(dit patterns)]: "
+		assert.True(t, strings.HasPrefix(out.String(), q+"Patterns:\n  1) a\n"), out.String())
+		assert.True(t, strings.HasSuffix(out.String(), "  - a\n  - b\n"+q), out.String())
+	})
+	t.Run("confirm", func(t *testing.T) {
+		var out bytes.Buffer
+		c := confirmer{in: strings.NewReader("y\n"), out: &out}
+		assert.NoError(t, c.confirm(context.Background()))
+		assert.Equal(t, "Continue? [Y/n]: ", out.String())
+	})
+	t.Run("decline", func(t *testing.T) {
+		c := confirmer{in: strings.NewReader("n\n"), out: io.Discard}
+		assert.EqualError(t, c.confirm(context.Background()), "user refused execution")
 	})
-	t.Run("no hits", func(t *testing.T) {
-		assert.Equal(t, []string{}, replaceArgs([]string{}, "X")("ctx"))
-		assert.Equal(t, []string{"arg1"}, replaceArgs([]string{"arg1"}, "X")("ctx"))
+	t.Run("cancel", func(t *testing.T) {
+		ch := make(chan struct{})
+		defer close(ch)
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		c := confirmer{in: blockingReader{ch}, out: io.Discard}
+		assert.EqualError(t, c.confirm(ctx), "prompt canceled")
 	})
-	t.Run("hits", func(t *testing.T) {
-		assert.Equal(t, []string{"a", "ctxctx", "actx"}, replaceArgs([]string{"a", "XX", "aX"}, "X")("ctx"))
-		assert.Equal(t, []string{"a", "ctx", "aX"}, replaceArgs([]string{"a", "XX", "aX"}, "XX")("ctx"))
+	t.Run("timeout", func(t *testing.T) {
+		ch := make(chan struct{})
+		defer close(ch)
+		c := confirmer{in: blockingReader{ch}, out: io.Discard, timeout: 10 * time.Millisecond}
+		assert.ErrorIs(t, c.confirm(context.Background()), errPromptTimeout)
 	})
 }
+
+func Test_missingContexts(t *testing.T) {
+	assert.Empty(t, missingContexts(nil, []string{"a"}))
+	assert.Empty(t, missingContexts([]string{"a", "b"}, []string{"b", "a", "c"}))
+	assert.Equal(t, []string{"a", "c"}, missingContexts([]string{"a", "b", "c"}, []string{"b"}))
+}
+
+// fakeKubectl puts a kubectl shell script with the given body first in PATH.
+func fakeKubectl(t *testing.T, body string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "kubectl"), []byte("#!/bin/sh\n"+body), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+}
+
+func TestRunAll_verify(t *testing.T) {
+	// "verify" fails in context b, "fail" fails everywhere
+	fakeKubectl(t, `case "$*" in
+	*"verify --context=b"*) echo not ready; exit 1;;
+	*fail*) exit 3;;
+	esac
+	echo ok`)
+	noLabel := func(s string) string { return s }
+	verify := func(c string) []string { return []string{"verify", "--context=" + c} }
+
+	var out bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), verify, noLabel,
+		nil, nil, nil, nil, nil, &out, io.Discard)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `verification failed in context "b"`)
+	assert.NoError(t, results[0].err)
+	assert.Equal(t, statusFailed, results[1].status())
+	assert.Equal(t, 1, results[1].exitCode())
+	assert.Contains(t, out.String(), "b | not ready")
+
+	// verification does not run after the command fails
+	results, _ = runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"fail"}, ""), verify, noLabel,
+		nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	assert.Equal(t, 3, results[0].exitCode())
+}
+
+func TestRunAll_successCodes(t *testing.T) {
+	defer func(v exitCodes) { successCodes = v }(successCodes)
+	successCodes = exitCodes{0: true, 1: true}
+	fakeKubectl(t, `case "$1" in
+	--context=a) exit 1;;
+	--context=b) exit 3;;
+	esac`)
+
+	results, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"diff"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, statusSucceeded, results[0].status())
+	assert.Equal(t, 1, results[0].exitCode(), "exit code is kept if it's in --success-codes")
+	assert.Equal(t, statusFailed, results[1].status())
+	assert.Equal(t, 3, results[1].exitCode())
+	assert.Equal(t, 0, results[2].exitCode())
+}
+
+func TestRunAll_classifyFailures(t *testing.T) {
+	defer func(v bool) { *classifyFailures = v }(*classifyFailures)
+	*classifyFailures = true
+	fakeKubectl(t, `case "$1" in
+	--context=a) echo 'Error from server (NotFound): pods "x" not found' >&2; exit 1;;
+	--context=b) echo 'Error from server (Forbidden): pods is forbidden' >&2; exit 1;;
+	--context=c) echo 'boom' >&2; exit 1;;
+	esac`)
+
+	var errOut bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b", "c", "d"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, foreach.DefaultFailurePatterns, io.Discard, &errOut)
+	require.Error(t, err)
+	assert.Equal(t, foreach.ReasonNotFound, results[0].reason)
+	assert.Equal(t, foreach.ReasonForbidden, results[1].reason)
+	assert.Equal(t, foreach.ReasonOther, results[2].reason)
+	assert.Empty(t, results[3].reason)
+	assert.Contains(t, errOut.String(), "failures: forbidden: 1, not-found: 1, other: 1\n")
+}
+
+func TestRunAll_failOnEmptyOutput(t *testing.T) {
+	defer func(v bool) { *failOnEmpty = v }(*failOnEmpty)
+	*failOnEmpty = true
+	fakeKubectl(t, `case "$1" in
+	--context=a) echo pod-1;;
+	--context=b) echo "No resources found" >&2;;
+	--context=c) echo "  ";;
+	esac`)
+
+	results, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get", "pods"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, 3, exitCode(err), "two contexts failed")
+	assert.Equal(t, statusSucceeded, results[0].status())
+	assert.ErrorIs(t, results[1].err, foreach.ErrEmptyOutput)
+	assert.ErrorIs(t, results[2].err, foreach.ErrEmptyOutput, "whitespace is empty by default")
+}
+
+func TestRunAll_contextFiles(t *testing.T) {
+	dir := t.TempDir()
+	var files []*os.File
+	for _, n := range []string{"a.log", "b.log"} {
+		f, err := os.Create(filepath.Join(dir, n))
+		require.NoError(t, err)
+		defer f.Close()
+		files = append(files, f)
+	}
+	defer func(v []*os.File) { contextFiles = v }(contextFiles)
+	contextFiles = files
+	fakeKubectl(t, `echo "out of $1"; echo "err of $1" >&2`)
+
+	var out, errOut bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &out, &errOut)
+	require.NoError(t, err)
+	for i, c := range []string{"a", "b"} {
+		b, err := os.ReadFile(files[i].Name())
+		require.NoError(t, err)
+		assert.Equal(t, "out of --context="+c+"\n", string(b), "raw output without prefix")
+		assert.Contains(t, errOut.String(), c+" | err of --context="+c+"\n", "stderr is still prefixed")
+	}
+	assert.Empty(t, out.String())
+}
+
+func TestRunAll_contextFilesRetried(t *testing.T) {
+	dir := t.TempDir()
+	var files []*os.File
+	for _, n := range []string{"a.log", "b.log"} {
+		f, err := os.Create(filepath.Join(dir, n))
+		require.NoError(t, err)
+		defer f.Close()
+		files = append(files, f)
+	}
+	defer func(v []*os.File) { contextFiles = v }(contextFiles)
+	defer func(v map[string]int) { contextIndex = v }(contextIndex)
+	contextFiles, contextIndex = files, map[string]int{"a": 0, "b": 1}
+	fakeKubectl(t, `echo "out of $1"`)
+
+	// only b runs again, as in a pass of --retry-failed-passes
+	_, err := runAll(context.Background(), []string{"b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.NoError(t, err)
+	b, err := os.ReadFile(files[1].Name())
+	require.NoError(t, err)
+	assert.Equal(t, "out of --context=b\n", string(b))
+	b, err = os.ReadFile(files[0].Name())
+	require.NoError(t, err)
+	assert.Empty(t, string(b))
+}
+
+func TestRunAll_wrapper(t *testing.T) {
+	defer func(v []string) { wrapper = v }(wrapper)
+	wrapper = []string{"env", "WRAPPED=1"}
+	fakeKubectl(t, `echo "wrapped=$WRAPPED"; exit 3`)
+
+	var out bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &out, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, 3, results[0].exitCode(), "exit code of the wrapped command")
+	assert.Equal(t, "a | wrapped=1\n", out.String())
+}
+
+func TestRunAll_grouped(t *testing.T) {
+	defer func(v string) { *outputMode = v }(*outputMode)
+	*outputMode = "grouped"
+	// b prints first, and a prints while b is still running
+	fakeKubectl(t, `case "$1" in
+	--context=a) sleep 0.2; echo a1; sleep 0.4; echo a2;;
+	--context=b) echo b1; sleep 0.4; echo b2;;
+	esac`)
+
+	var out bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &out, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "b | b1\nb | b2\na | a1\na | a2\n", out.String())
+}
+
+func TestRunAll_timeout(t *testing.T) {
+	defer func(v time.Duration) { *cmdTimeout = v }(*cmdTimeout)
+	*cmdTimeout = 200 * time.Millisecond
+	fakeKubectl(t, `case "$1" in
+	--context=a) echo fast;;
+	--context=b) exec sleep 10;;
+	esac`)
+
+	start := time.Now()
+	results, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	assert.Less(t, time.Since(start), 5*time.Second)
+	require.Error(t, err)
+	assert.Equal(t, exitCommandFailed, exitCode(err))
+	assert.NoError(t, results[0].err)
+	assert.ErrorIs(t, results[1].err, foreach.ErrTimeout)
+	assert.EqualError(t, results[1].err, `command in context "b": timed out after 200ms`)
+}
+
+func TestRunAll_outputHash(t *testing.T) {
+	defer func(v bool) { *outputHash = v }(*outputHash)
+	*outputHash = true
+	fakeKubectl(t, `case "$1" in
+	--context=a|--context=b) echo same;;
+	*) echo other;;
+	esac`)
+
+	results, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.NoError(t, err)
+	assert.Len(t, results[0].hash, 64)
+	assert.Equal(t, results[0].hash, results[1].hash)
+	assert.NotEqual(t, results[0].hash, results[2].hash)
+}
+
+func TestRunAll_outputDir(t *testing.T) {
+	dir := t.TempDir()
+	defer func(v string) { *outputDir = v }(*outputDir)
+	*outputDir = dir
+	fakeKubectl(t, `echo "out $1"; echo "err $1" >&2`)
+
+	var stdout bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b/c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &stdout, io.Discard)
+	require.NoError(t, err)
+	assert.Contains(t, stdout.String(), "| out --context=a\n", "still printed")
+	b, err := os.ReadFile(filepath.Join(dir, "a.log"))
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"out --context=a", "err --context=a"}, strings.Split(strings.TrimSpace(string(b)), "\n"),
+		"without the prefix")
+	b, err = os.ReadFile(filepath.Join(dir, "b_c.log"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "out --context=b/c\n")
+}
+
+func TestRunAll_tailBuffer(t *testing.T) {
+	defer func(v int) { *tailLines = v }(*tailLines)
+	*tailLines = 2
+	fakeKubectl(t, `printf '1\n2\n3\n'`)
+
+	var stdout bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &stdout, io.Discard)
+	require.NoError(t, err)
+	lines := strings.Split(strings.TrimSpace(stdout.String()), "\n")
+	require.Len(t, lines, 3, stdout.String())
+	assert.Contains(t, lines[0], "(1 earlier line(s) not shown)")
+	assert.True(t, strings.HasSuffix(lines[1], "| 2"), lines[1])
+	assert.True(t, strings.HasSuffix(lines[2], "| 3"), lines[2])
+}
+
+func TestRunAll_prefixSidecar(t *testing.T) {
+	var meta bytes.Buffer
+	defer func(v *sidecar) { prefixSidecar = v }(prefixSidecar)
+	prefixSidecar = newSidecar(&meta)
+	fakeKubectl(t, `echo "$1"; echo err >&2`)
+
+	var stdout, stderr bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &synchronizedWriter{Writer: &stdout}, &synchronizedWriter{Writer: &stderr})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"--context=a", "--context=b"}, strings.Split(strings.TrimSpace(stdout.String()), "\n"))
+	assert.Contains(t, stderr.String(), " | err\n", "stderr keeps the prefix")
+	for _, e := range readSidecar(t, meta.Bytes()) {
+		assert.Equal(t, "--context="+e.Context+"\n", stdout.String()[e.Offset:e.Offset+int64(e.Length)])
+	}
+}
+
+func TestRunAll_keepOrder(t *testing.T) {
+	defer func(v string) { *outputMode = v }(*outputMode)
+	defer func(v bool) { *keepOrder = v }(*keepOrder)
+	*outputMode, *keepOrder = "grouped", true
+	// c and b finish before a, which runs in parallel with them
+	fakeKubectl(t, `case "$1" in
+	--context=a) sleep 0.4; echo a1;;
+	--context=b) sleep 0.2; echo b1;;
+	--context=c) echo c1;;
+	esac`)
+
+	var out bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &out, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, "a | a1\nb | b1\nc | c1\n", out.String())
+}
+
+func TestRunAll_ramp(t *testing.T) {
+	defer func(v time.Duration) { *ramp = v }(*ramp)
+	*ramp = 100 * time.Millisecond
+	log := filepath.Join(t.TempDir(), "log")
+	// commands take longer than the ramp, so they still overlap
+	fakeKubectl(t, `echo "start $1" >> `+log+`; sleep 1; echo "end $1" >> `+log)
+
+	start := time.Now()
+	_, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, io.Discard)
+	require.NoError(t, err)
+	assert.GreaterOrEqual(t, time.Since(start), time.Second+2**ramp)
+	b, err := os.ReadFile(log)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"start --context=a", "start --context=b", "start --context=c"}, strings.Split(string(b), "\n")[:3])
+}
+
+func TestCheckKnown(t *testing.T) {
+	defer func(v string) { *contextsFrom = v }(*contextsFrom)
+	defer func(v bool) { *allowUnknown = v }(*allowUnknown)
+	fakeKubectl(t, `printf 'a\nb\n'`)
+
+	*contextsFrom = ""
+	assert.NoError(t, checkKnown(context.Background(), []string{"x"}), "contexts are from kubeconfig")
+
+	*contextsFrom = "fleet.txt"
+	assert.NoError(t, checkKnown(context.Background(), []string{"a", "b"}))
+	err := checkKnown(context.Background(), []string{"a", "x", "y"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "context(s) not in kubeconfig: x, y")
+
+	*allowUnknown = true
+	assert.NoError(t, checkKnown(context.Background(), []string{"a", "x"}))
+}
+
+func TestRunAll_retries(t *testing.T) {
+	defer func(v int) { *cmdRetries = v }(*cmdRetries)
+	defer func(v time.Duration) { *retryBackoff = v }(*retryBackoff)
+	*cmdRetries, *retryBackoff = 2, time.Millisecond
+	dir := t.TempDir()
+	// a fails on the first attempt, c on all of them
+	fakeKubectl(t, `n=`+dir+`/$1; echo x >> $n
+	case "$1" in
+	--context=a) [ $(wc -l < $n) -lt 2 ] && { echo flake >&2; exit 1; };;
+	--context=c) exit 3;;
+	esac
+	echo ok`)
+
+	var out bytes.Buffer
+	var errOut bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &synchronizedWriter{Writer: &out}, &synchronizedWriter{Writer: &errOut})
+	require.Error(t, err)
+	assert.NoError(t, results[0].err)
+	assert.Equal(t, 2, results[0].attempts)
+	assert.Equal(t, 1, results[1].attempts)
+	assert.Error(t, results[2].err)
+	assert.Equal(t, 3, results[2].attempts)
+	assert.Contains(t, errOut.String(), "a | failed (exit status 1), retrying in 1ms (attempt 2 of 3)\n")
+	assert.Contains(t, errOut.String(), "c | failed (exit status 3), retrying in 2ms (attempt 3 of 3)\n")
+	assert.Contains(t, out.String(), "a | ok\n")
+	assert.Equal(t, 1, strings.Count(out.String(), "a | ok"), "a succeeded once")
+}
+
+func TestRunAll_retriesKeepLastStderr(t *testing.T) {
+	defer func(v int) { *cmdRetries = v }(*cmdRetries)
+	defer func(v time.Duration) { *retryBackoff = v }(*retryBackoff)
+	defer func(v bool) { *skipUnreachable = v }(*skipUnreachable)
+	defer func(v bool) { *classifyFailures = v }(*classifyFailures)
+	*cmdRetries, *retryBackoff, *skipUnreachable, *classifyFailures = 1, time.Millisecond, true, true
+	dir := t.TempDir()
+	// the first attempt can't connect, the second one is forbidden
+	fakeKubectl(t, `n=`+dir+`/$1; echo x >> $n
+	[ $(wc -l < $n) -lt 2 ] && { echo "Unable to connect to the server: connection refused" >&2; exit 1; }
+	echo 'Error from server (Forbidden): pods is forbidden' >&2; exit 1`)
+
+	results, err := runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, foreach.DefaultFailurePatterns, io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, statusFailed, results[0].status(), "not unreachable from the stderr of the first attempt")
+	assert.Equal(t, foreach.ReasonForbidden, results[0].reason)
+	assert.Equal(t, "Error from server (Forbidden): pods is forbidden\n", results[0].stderr.String())
+}
+
+func TestRunAll_maxFailures(t *testing.T) {
+	defer func(v int) { *maxFailures = v }(*maxFailures)
+	defer func(v int) { *workers = v }(*workers)
+	*maxFailures, *workers = 1, 2
+	// a fails right away, c is still running then, and d doesn't start
+	fakeKubectl(t, `case "$1" in
+	--context=a) exit 1;;
+	--context=c) exec sleep 5;;
+	esac
+	echo ok`)
+
+	var errOut bytes.Buffer
+	start := time.Now()
+	results, err := runAll(context.Background(), []string{"a", "c", "d"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, io.Discard, &synchronizedWriter{Writer: &errOut})
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second, "c is killed")
+	assert.Equal(t, statusFailed, results[1].status())
+	assert.Equal(t, statusSkipped, results[2].status())
+	assert.Contains(t, errOut.String(), "c | cancelled: 1 context(s) failed\n")
+	assert.Contains(t, errOut.String(), "d | skipped: 1 context(s) failed\n")
+	assert.Contains(t, errOut.String(), "error: aborted the run after 1 context(s) failed (--max-failures), not run in: d\n")
+}
+
+func TestRunAll_providerLimits(t *testing.T) {
+	defer func(v int) { *workers = v }(*workers)
+	*workers = 2
+	dir := t.TempDir()
+	// a waits for c, which only runs if b waiting for its provider doesn't
+	// take the second slot of -c
+	fakeKubectl(t, `case "$1" in
+	--context=a) for i in $(seq 50); do [ -f `+dir+`/c ] && { echo saw c; exit 0; }; sleep 0.1; done; exit 1;;
+	--context=c) touch `+dir+`/c;;
+	esac`)
+	pl := newProviderLimiter(map[string]contextInfo{
+		"a": {cluster: "gke_p_z_a"},
+		"b": {cluster: "gke_p_z_b"},
+		"c": {cluster: "kind"},
+	}, providerLimits{providerGKE: 1})
+
+	var out bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b", "c"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, pl, nil, nil, nil, &synchronizedWriter{Writer: &out}, io.Discard)
+	require.NoError(t, err)
+	assert.Contains(t, out.String(), "a | saw c\n")
+	for _, r := range results {
+		assert.NoError(t, r.err)
+	}
+
+	// Ctrl-C stops the context waiting for its provider
+	sig := make(chan os.Signal, 1)
+	sd := newShutdown(sig, 0, io.Discard)
+	fakeKubectl(t, `exec sleep 5`)
+	time.AfterFunc(100*time.Millisecond, func() { sig <- os.Interrupt })
+	start := time.Now()
+	results, err = runAll(sd.kill, []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, pl, nil, sd, nil, io.Discard, io.Discard)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+	statuses := []string{results[0].status(), results[1].status()}
+	sort.Strings(statuses)
+	assert.Equal(t, []string{statusFailed, statusSkipped}, statuses, "one of them ran, the other one didn't wait for it")
+}
+
+func TestRunAll_partialLastLine(t *testing.T) {
+	defer func(v int) { *cmdRetries = v }(*cmdRetries)
+	defer func(v time.Duration) { *retryBackoff = v }(*retryBackoff)
+	*cmdRetries, *retryBackoff = 1, time.Millisecond
+	fakeKubectl(t, `printf 'no newline'; printf 'error: partial' >&2; exit 1`)
+
+	var out, errOut bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &out, &errOut)
+	require.Error(t, err)
+	assert.Equal(t, "a | no newline\na | no newline\n", out.String())
+	assert.Equal(t, "a | error: partial\n"+
+		"a | failed (exit status 1), retrying in 1ms (attempt 2 of 2)\n"+
+		"a | error: partial\n", errOut.String())
+}
+
+func TestRunAll_replaceStdin(t *testing.T) {
+	defer func(v int) { *cmdRetries = v }(*cmdRetries)
+	defer func(v time.Duration) { *retryBackoff = v }(*retryBackoff)
+	defer func(v func(string) []byte) { kubectlStdin = v }(kubectlStdin)
+	*cmdRetries, *retryBackoff = 1, time.Millisecond
+	kubectlStdin = replaceInput([]byte("name: {{context}}\n"), nil)
+	fakeKubectl(t, `case "$*" in
+	verify*) cat; echo verified;;
+	*context=a*) cat; exit 1;;
+	*) cat;;
+	esac`)
+	verify := func(c string) []string { return []string{"verify", "--context=" + c} }
+
+	var out bytes.Buffer
+	results, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"apply", "-f", "-"}, ""), verify,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &synchronizedWriter{Writer: &out}, io.Discard)
+	require.Error(t, err)
+	assert.Equal(t, 2, results[0].attempts)
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	sort.Strings(lines)
+	assert.Equal(t, []string{"a | name: a", "a | name: a", "b | name: b", "b | verified"}, lines)
+}
+
+func TestRunAll_interrupt(t *testing.T) {
+	fakeKubectl(t, `trap 'echo "closing port-forward"; exit 0' INT
+	sleep 10 >/dev/null 2>&1 &
+	wait $!`)
+
+	var out bytes.Buffer
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(500*time.Millisecond, cancel)
+	start := time.Now()
+	results, err := runAll(ctx, []string{"a", "b"}, foreach.ReplaceArgs([]string{"port-forward", "svc/web", "8080"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &synchronizedWriter{Writer: &out}, io.Discard)
+	require.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+	assert.Contains(t, out.String(), "a | closing port-forward\n")
+	assert.Contains(t, out.String(), "b | closing port-forward\n")
+	for _, r := range results {
+		assert.ErrorIs(t, r.err, context.Canceled)
+	}
+}
+
+func TestRunAll_runner(t *testing.T) {
+	defer func(r foreach.Runner) { runCmd = r }(runCmd)
+	var mu sync.Mutex
+	var got [][]string
+	runCmd = foreach.RunnerFunc(func(_ context.Context, args []string, _ io.Reader, stdout, _ io.Writer) error {
+		mu.Lock()
+		defer mu.Unlock()
+		got = append(got, args)
+		_, err := io.WriteString(stdout, "ok\n")
+		return err
+	})
+
+	var out bytes.Buffer
+	_, err := runAll(context.Background(), []string{"a", "b"}, foreach.ReplaceArgs([]string{"--context=_", "get", "cm", "_-config"}, "_"), nil,
+		func(s string) string { return s }, nil, nil, nil, nil, nil, &synchronizedWriter{Writer: &out}, io.Discard)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, [][]string{
+		{"--context=a", "get", "cm", "a-config"},
+		{"--context=b", "get", "cm", "b-config"},
+	}, got)
+	assert.ElementsMatch(t, []string{"a | ok", "b | ok"}, strings.Split(strings.TrimSpace(out.String()), "\n"))
+}
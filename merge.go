@@ -0,0 +1,195 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// mergedContextsAnnotation is set on the items merged by --merge-resources to
+// the contexts they were found in.
+const mergedContextsAnnotation = "kubectl-foreach/contexts"
+
+// instanceFields are the metadata fields that differ between copies of the
+// same resource in different clusters, which are ignored when merging them.
+var instanceFields = []string{"uid", "resourceVersion", "creationTimestamp", "generation", "managedFields", "selfLink"}
+
+// errUnparsable is used when the output of the command in a context could not
+// be parsed for --merge-resources.
+var errUnparsable = errors.New("unparsable output")
+
+// outputFormatOf returns the -o/--output format of the arguments to kubectl
+// if it's json or yaml, or an empty string.
+func outputFormatOf(args []string) string {
+	var v string
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		switch {
+		case a == "--":
+			i = len(args)
+		case a == "-o" || a == "--output":
+			if i+1 < len(args) {
+				v = args[i+1]
+				i++
+			}
+		case strings.HasPrefix(a, "--output="):
+			v = strings.TrimPrefix(a, "--output=")
+		case strings.HasPrefix(a, "-o"):
+			v = strings.TrimPrefix(strings.TrimPrefix(a, "-o"), "=")
+		}
+	}
+	if v == "json" || v == "yaml" {
+		return v
+	}
+	return ""
+}
+
+// parseItems returns the items of a List, or the object itself, in the output
+// of kubectl in format (json or yaml).
+func parseItems(format string, b []byte) ([]map[string]interface{}, error) {
+	var docs []map[string]interface{}
+	if format == "json" {
+		dec := json.NewDecoder(bytes.NewReader(b))
+		for {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			docs = append(docs, m)
+		}
+	} else {
+		dec := yaml.NewDecoder(bytes.NewReader(b))
+		for {
+			var m map[string]interface{}
+			if err := dec.Decode(&m); errors.Is(err, io.EOF) {
+				break
+			} else if err != nil {
+				return nil, err
+			}
+			if m != nil {
+				docs = append(docs, m)
+			}
+		}
+	}
+	var out []map[string]interface{}
+	for _, d := range docs {
+		items, ok := d["items"].([]interface{})
+		if !ok {
+			out = append(out, d)
+			continue
+		}
+		for _, it := range items {
+			m, ok := it.(map[string]interface{})
+			if !ok {
+				return nil, fmt.Errorf("item is not an object: %v", it)
+			}
+			out = append(out, m)
+		}
+	}
+	return out, nil
+}
+
+// mergeKey returns a key that is the same for copies of an item that only
+// differ in their instanceFields.
+func mergeKey(item map[string]interface{}) (string, error) {
+	c := make(map[string]interface{}, len(item))
+	for k, v := range item {
+		c[k] = v
+	}
+	if md, ok := item["metadata"].(map[string]interface{}); ok {
+		mc := make(map[string]interface{}, len(md))
+		for k, v := range md {
+			mc[k] = v
+		}
+		for _, f := range instanceFields {
+			delete(mc, f)
+		}
+		c["metadata"] = mc
+	}
+	b, err := json.Marshal(c) // sorts the keys of maps
+	return string(b), err
+}
+
+// mergeResources merges the items in the output of the contexts that
+// succeeded into a List in format, where identical items (apart from their
+// instanceFields) are listed once, annotated with the contexts they're in.
+// The contexts whose output could not be parsed are returned as errors.
+func mergeResources(format string, results []result) ([]byte, []error) {
+	var errs []error
+	var items []map[string]interface{}
+	var contexts [][]string
+	index := make(map[string]int)
+	for _, r := range results {
+		if r.err != nil || r.stdout == nil {
+			continue
+		}
+		parsed, err := parseItems(format, r.stdout.Bytes())
+		if err != nil {
+			errs = append(errs, fmt.Errorf("failed to parse %s output of context %q: %w", format, r.context, err))
+			continue
+		}
+		for _, it := range parsed {
+			key, err := mergeKey(it)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("failed to merge output of context %q: %w", r.context, err))
+				break
+			}
+			if i, ok := index[key]; ok {
+				contexts[i] = append(contexts[i], r.context)
+				continue
+			}
+			index[key] = len(items)
+			items = append(items, it)
+			contexts = append(contexts, []string{r.context})
+		}
+	}
+	list := make([]interface{}, len(items))
+	for i, it := range items {
+		md, ok := it["metadata"].(map[string]interface{})
+		if !ok {
+			md = make(map[string]interface{})
+			it["metadata"] = md
+		}
+		ann, ok := md["annotations"].(map[string]interface{})
+		if !ok {
+			ann = make(map[string]interface{})
+			md["annotations"] = ann
+		}
+		ann[mergedContextsAnnotation] = strings.Join(contexts[i], ",")
+		list[i] = it
+	}
+	doc := map[string]interface{}{"apiVersion": "v1", "kind": "List", "items": list}
+	var b []byte
+	var err error
+	if format == "json" {
+		b, err = json.MarshalIndent(doc, "", "    ")
+		b = append(b, '\n')
+	} else {
+		b, err = yaml.Marshal(doc)
+	}
+	if err != nil {
+		errs = append(errs, fmt.Errorf("failed to write merged resources: %w", err))
+	}
+	return b, errs
+}
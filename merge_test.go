@@ -0,0 +1,99 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_outputFormatOf(t *testing.T) {
+	for args, want := range map[string]string{
+		"get pods":                       "",
+		"get pods -o json":               "json",
+		"get pods -oyaml":                "yaml",
+		"get pods -o=yaml":               "yaml",
+		"get pods --output json":         "json",
+		"get pods --output=yaml":         "yaml",
+		"get pods -o wide":               "",
+		"get pods -o json -- -o yaml":    "json",
+		"get pods -o yaml --output=json": "json",
+	} {
+		assert.Equal(t, want, outputFormatOf(strings.Fields(args)), args)
+	}
+}
+
+func Test_parseItems(t *testing.T) {
+	items, err := parseItems("json", []byte(`{"kind":"List","items":[{"kind":"Pod"},{"kind":"Service"}]}`))
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	items, err = parseItems("json", []byte(`{"kind":"Pod"}`))
+	require.NoError(t, err)
+	assert.Equal(t, []map[string]interface{}{{"kind": "Pod"}}, items)
+
+	items, err = parseItems("yaml", []byte("kind: Pod\n---\nkind: List\nitems:\n- kind: Service\n"))
+	require.NoError(t, err)
+	assert.Len(t, items, 2)
+
+	_, err = parseItems("json", []byte(`{"kind":`))
+	assert.Error(t, err)
+	_, err = parseItems("yaml", []byte("kind: [\n"))
+	assert.Error(t, err)
+}
+
+func Test_mergeResources(t *testing.T) {
+	out := func(s string) *bytes.Buffer { return bytes.NewBufferString(s) }
+	results := []result{
+		{context: "a", stdout: out(`{"kind":"List","items":[
+			{"kind":"ConfigMap","metadata":{"name":"x","uid":"1","resourceVersion":"10"},"data":{"k":"v"}},
+			{"kind":"ConfigMap","metadata":{"name":"y","uid":"2"},"data":{"k":"a"}}]}`)},
+		{context: "b", stdout: out(`{"kind":"List","items":[
+			{"kind":"ConfigMap","metadata":{"name":"x","uid":"3","resourceVersion":"99"},"data":{"k":"v"}},
+			{"kind":"ConfigMap","metadata":{"name":"y","uid":"4"},"data":{"k":"b"}}]}`)},
+		{context: "c", stdout: out(`not json`)},
+		{context: "d", stdout: out(`{}`), err: errors.New("exit status 1")},
+	}
+	doc, errs := mergeResources("json", results)
+	require.Len(t, errs, 1)
+	assert.Contains(t, errs[0].Error(), `context "c"`)
+
+	var list struct {
+		Items []struct {
+			Metadata struct {
+				Name        string            `json:"name"`
+				Annotations map[string]string `json:"annotations"`
+			} `json:"metadata"`
+		} `json:"items"`
+	}
+	require.NoError(t, json.Unmarshal(doc, &list))
+	require.Len(t, list.Items, 3)
+	var got []string
+	for _, it := range list.Items {
+		got = append(got, it.Metadata.Name+"@"+it.Metadata.Annotations[mergedContextsAnnotation])
+	}
+	assert.Equal(t, []string{"x@a,b", "y@a", "y@b"}, got)
+
+	doc, errs = mergeResources("yaml", []result{{context: "a", stdout: out("kind: Pod\nmetadata:\n  name: p\n")}})
+	require.Empty(t, errs)
+	assert.Contains(t, string(doc), "kubectl-foreach/contexts: a\n")
+	assert.Contains(t, string(doc), "kind: List\n")
+}
@@ -0,0 +1,180 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// metadata holds user-provided labels of contexts, keyed by context name.
+type metadata map[string]map[string]string
+
+// loadMetadata reads a JSON file mapping context names to their labels, e.g.
+//
+//	{"prod-us": {"region": "us-east", "env": "prod"}}
+func loadMetadata(path string) (metadata, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read context metadata: %w", err)
+	}
+	var m metadata
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse context metadata file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// mergeMetadata merges the labels of contexts, where the later ones override
+// the labels of the earlier ones. onConflict, if not nil, is called for each
+// overridden label with a different value.
+func mergeMetadata(ms []metadata, onConflict func(ctx, key, old, new string)) metadata {
+	out := make(metadata)
+	for _, m := range ms {
+		for ctx, labels := range m {
+			if out[ctx] == nil {
+				out[ctx] = make(map[string]string, len(labels))
+			}
+			for k, v := range labels {
+				if old, ok := out[ctx][k]; ok && old != v && onConflict != nil {
+					onConflict(ctx, k, old, v)
+				}
+				out[ctx][k] = v
+			}
+		}
+	}
+	return out
+}
+
+// requirement is a "key=value" (or "key!=value" if negated) term of a selector.
+type requirement struct {
+	key, value string
+	negated    bool
+}
+
+// selector matches labels of contexts against all of its requirements.
+type selector []requirement
+
+// parseSelector parses comma-separated "key=value" or "key!=value" terms.
+func parseSelector(s string) (selector, error) {
+	var out selector
+	for _, v := range splitList(s) {
+		var r requirement
+		if i := strings.Index(v, "!="); i != -1 {
+			r = requirement{key: v[:i], value: v[i+2:], negated: true}
+		} else if i := strings.IndexByte(v, '='); i != -1 {
+			r = requirement{key: v[:i], value: v[i+1:]}
+		} else {
+			return nil, fmt.Errorf("invalid selector term %q: need key=value or key!=value", v)
+		}
+		r.key, r.value = strings.TrimSpace(r.key), strings.TrimSpace(r.value)
+		if r.key == "" {
+			return nil, fmt.Errorf("invalid selector term %q: empty key", v)
+		}
+		out = append(out, r)
+	}
+	if len(out) == 0 {
+		return nil, fmt.Errorf("empty selector")
+	}
+	return out, nil
+}
+
+func (s selector) matches(labels map[string]string) bool {
+	for _, r := range s {
+		if (labels[r.key] == r.value) == r.negated {
+			return false
+		}
+	}
+	return true
+}
+
+// filter returns the contexts whose labels match the selector.
+func (s selector) filter(kubeCtxs []string, m metadata) []string {
+	var out []string
+	for _, c := range kubeCtxs {
+		if s.matches(m[c]) {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// contextDetails resolves fields of a context from user-provided labels,
+// falling back to the fields in kubeconfig.
+type contextDetails struct {
+	labels     metadata
+	kubeconfig map[string]contextInfo
+}
+
+func (d contextDetails) field(ctx, key string) (string, bool) {
+	if v, ok := d.labels[ctx][key]; ok {
+		return v, true
+	}
+	return d.kubeconfig[ctx].field(key)
+}
+
+// annotatedLabel returns the context name followed by the values of the
+// specified fields, such as "prod-us (us-east,prod)". Missing fields are
+// rendered as "-".
+func annotatedLabel(d contextDetails, fields []string) func(string) string {
+	return func(ctx string) string {
+		if len(fields) == 0 {
+			return ctx
+		}
+		vals := make([]string, len(fields))
+		for i, f := range fields {
+			v, ok := d.field(ctx, f)
+			if !ok {
+				v = "-"
+			}
+			vals[i] = v
+		}
+		return ctx + " (" + strings.Join(vals, ",") + ")"
+	}
+}
+
+// fieldLabel returns the value of the field of a context, or the context name
+// if it doesn't have the field.
+func fieldLabel(d contextDetails, field string) func(string) string {
+	return func(ctx string) string {
+		if v, ok := d.field(ctx, field); ok && v != "" {
+			return v
+		}
+		return ctx
+	}
+}
+
+// splitList splits a comma-separated flag value, ignoring empty items.
+func splitList(s string) []string {
+	var out []string
+	for _, v := range strings.Split(s, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// stringList is a flag.Value that collects the values of a repeated flag.
+type stringList []string
+
+func (s *stringList) String() string { return strings.Join(*s, ",") }
+
+func (s *stringList) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
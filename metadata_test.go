@@ -0,0 +1,117 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadMetadata(t *testing.T) {
+	dir := t.TempDir()
+	t.Run("missing file", func(t *testing.T) {
+		_, err := loadMetadata(filepath.Join(dir, "missing.json"))
+		assert.Error(t, err)
+	})
+	t.Run("malformed", func(t *testing.T) {
+		f := filepath.Join(dir, "bad.json")
+		require.NoError(t, os.WriteFile(f, []byte(`{"a": "b"}`), 0o644))
+		_, err := loadMetadata(f)
+		assert.Error(t, err)
+	})
+	t.Run("valid", func(t *testing.T) {
+		f := filepath.Join(dir, "ok.json")
+		require.NoError(t, os.WriteFile(f, []byte(`{"a": {"region": "us"}}`), 0o644))
+		m, err := loadMetadata(f)
+		require.NoError(t, err)
+		assert.Equal(t, metadata{"a": {"region": "us"}}, m)
+	})
+}
+
+func Test_annotatedLabel(t *testing.T) {
+	d := contextDetails{
+		labels:     metadata{"a": {"region": "us-east", "cluster": "override"}},
+		kubeconfig: map[string]contextInfo{"a": {name: "a", cluster: "c1", namespace: "ns1"}},
+	}
+	assert.Equal(t, "a", annotatedLabel(d, nil)("a"))
+	assert.Equal(t, "a (us-east,-)", annotatedLabel(d, []string{"region", "env"})("a"))
+	assert.Equal(t, "a (override,ns1)", annotatedLabel(d, []string{"cluster", "namespace"})("a"))
+	assert.Equal(t, "b (-)", annotatedLabel(d, []string{"region"})("b"))
+}
+
+func Test_fieldLabel(t *testing.T) {
+	d := contextDetails{
+		labels:     metadata{"a": {"region": "us-east"}, "b": {"region": ""}},
+		kubeconfig: map[string]contextInfo{"a": {name: "a", cluster: "c1"}},
+	}
+	assert.Equal(t, "us-east", fieldLabel(d, "region")("a"))
+	assert.Equal(t, "c1", fieldLabel(d, "cluster")("a"))
+	assert.Equal(t, "b", fieldLabel(d, "region")("b"), "empty value")
+	assert.Equal(t, "c", fieldLabel(d, "region")("c"), "missing field")
+}
+
+func Test_splitList(t *testing.T) {
+	assert.Equal(t, []string(nil), splitList(""))
+	assert.Equal(t, []string{"a", "b"}, splitList("a, b,,"))
+}
+
+func Test_mergeMetadata(t *testing.T) {
+	var conflicts []string
+	got := mergeMetadata([]metadata{
+		{"a": {"team": "x", "env": "prod"}, "b": {"team": "y"}},
+		{"a": {"region": "us", "env": "staging"}, "c": {"region": "eu"}},
+		{"a": {"region": "us"}},
+	}, func(ctx, key, old, new string) {
+		conflicts = append(conflicts, ctx+"/"+key+": "+old+" -> "+new)
+	})
+	assert.Equal(t, metadata{
+		"a": {"team": "x", "env": "staging", "region": "us"},
+		"b": {"team": "y"},
+		"c": {"region": "eu"},
+	}, got)
+	assert.Equal(t, []string{"a/env: prod -> staging"}, conflicts)
+
+	assert.Empty(t, mergeMetadata(nil, nil))
+}
+
+func Test_parseSelector(t *testing.T) {
+	s, err := parseSelector("env=prod, region!=eu")
+	require.NoError(t, err)
+	assert.Equal(t, selector{
+		{key: "env", value: "prod"},
+		{key: "region", value: "eu", negated: true},
+	}, s)
+
+	for _, in := range []string{"", "env", "=prod", "!=eu"} {
+		_, err := parseSelector(in)
+		assert.Error(t, err, in)
+	}
+}
+
+func TestSelector(t *testing.T) {
+	s, err := parseSelector("env=prod,region!=eu")
+	require.NoError(t, err)
+	assert.True(t, s.matches(map[string]string{"env": "prod", "region": "us"}))
+	assert.True(t, s.matches(map[string]string{"env": "prod"}))
+	assert.False(t, s.matches(map[string]string{"env": "prod", "region": "eu"}))
+	assert.False(t, s.matches(nil))
+
+	m := metadata{"a": {"env": "prod"}, "b": {"env": "dev"}, "c": {"env": "prod", "region": "eu"}}
+	assert.Equal(t, []string{"a"}, s.filter([]string{"a", "b", "c", "d"}, m))
+}
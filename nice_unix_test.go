@@ -0,0 +1,39 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_startCmd_nice(t *testing.T) {
+	defer func(v int) { *nice = v }(*nice)
+	*nice = 7
+
+	var b bytes.Buffer
+	cmd := exec.Command("sh", "-c", "sleep 0.2; nice")
+	cmd.Stdout = &b
+	require.NoError(t, startCmd(cmd))
+	require.NoError(t, cmd.Wait())
+	assert.Equal(t, "7", strings.TrimSpace(b.String()))
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"strings"
+)
+
+// readOrderFile reads the context names from an --order-file, one per line
+// (or as a JSON array).
+func readOrderFile(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read order file: %w", err)
+	}
+	names, err := parseContextNames(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse order file %s: %w", path, err)
+	}
+	return names, nil
+}
+
+// orderByList returns the contexts in the order of names. Contexts not in
+// names are placed last in their original order, or dropped if dropUnlisted
+// is true. It's an error if names has contexts that are not in kubeCtxs.
+func orderByList(kubeCtxs, names []string, dropUnlisted bool) ([]string, error) {
+	if missing := missingContexts(names, kubeCtxs); len(missing) > 0 {
+		return nil, fmt.Errorf("context(s) in order file are not matched: %s", strings.Join(missing, ", "))
+	}
+	listed := make(map[string]bool, len(names))
+	var out []string
+	for _, v := range names {
+		if !listed[v] {
+			listed[v] = true
+			out = append(out, v)
+		}
+	}
+	if dropUnlisted {
+		return out, nil
+	}
+	for _, v := range kubeCtxs {
+		if !listed[v] {
+			out = append(out, v)
+		}
+	}
+	return out, nil
+}
+
+// sortedContexts returns a copy of kubeCtxs in lexical order, for --sort.
+func sortedContexts(kubeCtxs []string) []string {
+	out := make([]string, len(kubeCtxs))
+	copy(out, kubeCtxs)
+	sort.Strings(out)
+	return out
+}
+
+// shuffledContexts returns a copy of kubeCtxs in a random order from seed, for
+// --shuffle. The same seed gives the same order for the same contexts.
+func shuffledContexts(kubeCtxs []string, seed int64) []string {
+	out := make([]string, len(kubeCtxs))
+	copy(out, kubeCtxs)
+	r := rand.New(rand.NewSource(seed))
+	r.Shuffle(len(out), func(i, j int) { out[i], out[j] = out[j], out[i] })
+	return out
+}
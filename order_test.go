@@ -0,0 +1,66 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_readOrderFile(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "order.txt")
+	require.NoError(t, os.WriteFile(f, []byte("# canary first\nc\na\n"), 0o644))
+	got, err := readOrderFile(f)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, got)
+
+	_, err = readOrderFile(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func Test_orderByList(t *testing.T) {
+	ctxs := []string{"a", "b", "c", "d"}
+
+	got, err := orderByList(ctxs, []string{"c", "a", "c"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a", "b", "d"}, got)
+
+	got, err = orderByList(ctxs, []string{"c", "a"}, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"c", "a"}, got)
+
+	_, err = orderByList(ctxs, []string{"c", "x"}, false)
+	assert.EqualError(t, err, "context(s) in order file are not matched: x")
+}
+
+func Test_sortedContexts(t *testing.T) {
+	ctxs := []string{"prod-us", "dev", "prod-eu"}
+	assert.Equal(t, []string{"dev", "prod-eu", "prod-us"}, sortedContexts(ctxs))
+	assert.Equal(t, []string{"prod-us", "dev", "prod-eu"}, ctxs, "input is not modified")
+	assert.Empty(t, sortedContexts(nil))
+}
+
+func Test_shuffledContexts(t *testing.T) {
+	ctxs := []string{"a", "b", "c", "d", "e", "f", "g", "h"}
+	got := shuffledContexts(ctxs, 42)
+	assert.ElementsMatch(t, ctxs, got)
+	assert.NotEqual(t, ctxs, got)
+	assert.Equal(t, got, shuffledContexts(ctxs, 42), "same seed, same order")
+	assert.Equal(t, []string{"a", "b", "c", "d", "e", "f", "g", "h"}, ctxs, "input is not modified")
+}
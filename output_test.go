@@ -1,4 +1,4 @@
-// Copyright 2022 Twitter, Inc.
+// Copyright 2022 Twitter, Inc
 //
 // Licensed under the Apache License, Version 2.0 (the "License");
 // you may not use this file except in compliance with the License.
@@ -39,29 +39,3 @@ func Test_synchronizedWriter(t *testing.T) {
 	wg.Wait()
 	assert.Equal(t, 1000, strings.Count(b.String(), seq))
 }
-
-func Test_prefixingWriter(t *testing.T) {
-	var b bytes.Buffer
-	pw := &prefixingWriter{prefix: []byte{'p', ':', ' '}, w: &b}
-
-	// single line (no trailing newline)
-	n, err := pw.Write([]byte("hello"))
-	assert.Equal(t, 5, n)
-	assert.NoError(t, err)
-
-	// multi line (no trailing newline) - continuation to single line + new line
-	n, err = pw.Write([]byte("a\nb"))
-	assert.Equal(t, 3, n)
-	assert.NoError(t, err)
-	assert.Equal(t, `p: helloa
-`, b.String())
-
-	n, err = pw.Write([]byte("eof\n"))
-	assert.Equal(t, 4, n)
-	assert.NoError(t, err)
-
-	assert.Equal(t, `p: helloa
-p: beof
-`, // expected trailing newline
-		b.String())
-}
@@ -0,0 +1,59 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// unsafeFileChars are replaced in context names to make file names, so that
+// names like "arn:aws:eks:us-east-1:123:cluster/prod" don't create
+// directories or invalid names on Windows.
+var unsafeFileChars = strings.NewReplacer("/", "_", `\`, "_", ":", "_", "*", "_", "?", "_",
+	`"`, "_", "<", "_", ">", "_", "|", "_")
+
+// outputFileNames returns the names of the --output-dir files of contexts, in
+// the same order. Contexts whose names become the same after replacing unsafe
+// characters get a number suffix.
+func outputFileNames(kubeCtxs []string) []string {
+	out := make([]string, len(kubeCtxs))
+	seen := make(map[string]bool, len(kubeCtxs))
+	for i, c := range kubeCtxs {
+		base := unsafeFileChars.Replace(c)
+		if base == "" || base == "." || base == ".." {
+			base = "_" + base
+		}
+		name := base
+		for n := 2; seen[name]; n++ {
+			name = base + "-" + strconv.Itoa(n)
+		}
+		seen[name] = true
+		out[i] = name + ".log"
+	}
+	return out
+}
+
+// createOutputFile creates the --output-dir file of a context.
+func createOutputFile(dir, name string) (*os.File, error) {
+	f, err := os.Create(filepath.Join(dir, name))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create output file: %w", err)
+	}
+	return f, nil
+}
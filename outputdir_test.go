@@ -0,0 +1,32 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_outputFileNames(t *testing.T) {
+	assert.Equal(t, []string{
+		"prod.log",
+		"arn_aws_eks_us-east-1_123_cluster_prod.log",
+		"a_b.log",
+		"a_b-2.log",
+		"_...log",
+		"a_b-3.log",
+	}, outputFileNames([]string{"prod", "arn:aws:eks:us-east-1:123:cluster/prod", "a/b", `a\b`, "..", "a_b"}))
+}
@@ -0,0 +1,99 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/csv"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// params holds the values of the columns of --params-csv, keyed by context
+// name and then by column name.
+type params map[string]map[string]string
+
+// loadParams reads a CSV file with a header row, whose first column is the
+// context name and the others are the names of the values of the context, e.g.
+//
+//	context,deployment,replicas
+//	prod-us,web,5
+func loadParams(path string) (params, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read params: %w", err)
+	}
+	defer f.Close()
+	rows, err := csv.NewReader(f).ReadAll()
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse params file %s: %w", path, err)
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("params file %s has no header row", path)
+	}
+	header := rows[0]
+	seen := make(map[string]bool, len(header))
+	for _, h := range header[1:] {
+		if h == "" {
+			return nil, fmt.Errorf("params file %s has a column with an empty name", path)
+		} else if seen[h] {
+			return nil, fmt.Errorf("params file %s has more than one %q column", path, h)
+		}
+		seen[h] = true
+	}
+	out := make(params, len(rows)-1)
+	for i, row := range rows[1:] {
+		if _, ok := out[row[0]]; ok {
+			return nil, fmt.Errorf("params file %s: line %d: context %q is listed more than once", path, i+2, row[0])
+		}
+		values := make(map[string]string, len(header)-1)
+		for j, h := range header[1:] {
+			values[h] = row[j+1]
+		}
+		out[row[0]] = values
+	}
+	return out, nil
+}
+
+// paramArgs returns the arguments from argMaker with each "{{name}}" replaced
+// by the value of the name column in the row of the context.
+func paramArgs(argMaker func(string) []string, p params) func(string) []string {
+	return func(ctx string) []string {
+		var pairs []string
+		for k, v := range p[ctx] {
+			pairs = append(pairs, "{{"+k+"}}", v)
+		}
+		r := strings.NewReplacer(pairs...)
+		args := argMaker(ctx)
+		out := make([]string, len(args))
+		for i := range args {
+			out[i] = r.Replace(args[i])
+		}
+		return out
+	}
+}
+
+// withParams returns the contexts that have a row in p, and the ones that
+// don't.
+func withParams(kubeCtxs []string, p params) (found, missing []string) {
+	for _, c := range kubeCtxs {
+		if _, ok := p[c]; ok {
+			found = append(found, c)
+		} else {
+			missing = append(missing, c)
+		}
+	}
+	return found, missing
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestLoadParams(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "params.csv")
+	require.NoError(t, os.WriteFile(path, []byte("context,deployment,replicas\nprod-us,web,5\nprod-eu,\"api,v2\",3\n"), 0o644))
+	p, err := loadParams(path)
+	require.NoError(t, err)
+	assert.Equal(t, params{
+		"prod-us": {"deployment": "web", "replicas": "5"},
+		"prod-eu": {"deployment": "api,v2", "replicas": "3"},
+	}, p)
+
+	for name, s := range map[string]string{
+		"empty":            "",
+		"ragged":           "context,a\nx,1,2\n",
+		"empty column":     "context,,b\nx,1,2\n",
+		"duplicate column": "context,a,a\nx,1,2\n",
+		"duplicate row":    "context,a\nx,1\nx,2\n",
+	} {
+		require.NoError(t, os.WriteFile(path, []byte(s), 0o644))
+		_, err := loadParams(path)
+		assert.Error(t, err, name)
+	}
+
+	_, err = loadParams(filepath.Join(t.TempDir(), "missing.csv"))
+	assert.Error(t, err)
+}
+
+func TestParamArgs(t *testing.T) {
+	p := params{"a": {"deployment": "web", "replicas": "5"}}
+	argMaker := paramArgs(foreach.ReplaceArgs([]string{"scale", "deploy/{{deployment}}", "--replicas={{replicas}}", "{{other}}"}, ""), p)
+	assert.Equal(t, []string{"--context=a", "scale", "deploy/web", "--replicas=5", "{{other}}"}, argMaker("a"))
+}
+
+func TestWithParams(t *testing.T) {
+	found, missing := withParams([]string{"a", "b", "c"}, params{"a": {}, "c": {}})
+	assert.Equal(t, []string{"a", "c"}, found)
+	assert.Equal(t, []string{"b"}, missing)
+}
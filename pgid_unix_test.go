@@ -0,0 +1,77 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunAll_signalDuringDrain(t *testing.T) {
+	defer func(v int) { *workers = v }(*workers)
+	*workers = 1
+	started := filepath.Join(t.TempDir(), "started")
+	t.Setenv("STARTED", started)
+	// prints its process group, and exits with 130 if it gets the Ctrl-C
+	fakeKubectl(t, `trap 'exit 130' INT
+	ps -o pgid= -p $$ | tr -d ' '
+	touch "$STARTED"
+	sleep 0.5 & wait $!
+	echo done`)
+
+	sig := make(chan os.Signal, 2)
+	signal.Notify(sig, os.Interrupt)
+	defer signal.Stop(sig)
+	sd := newShutdown(sig, 5*time.Second, io.Discard)
+	go func() {
+		for {
+			if _, err := os.Stat(started); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		// Ctrl-C would signal the process group of the test too, so the child
+		// being in another group is checked below
+		_ = syscall.Kill(os.Getpid(), syscall.SIGINT)
+	}()
+
+	var out bytes.Buffer
+	results, err := runAll(sd.kill, []string{"a", "b"}, foreach.ReplaceArgs([]string{"get"}, ""), nil,
+		func(s string) string { return s }, nil, nil, nil, sd, nil, &synchronizedWriter{Writer: &out}, io.Discard)
+	require.Error(t, err, "b is skipped")
+	assert.Equal(t, statusSucceeded, results[0].status(), "a finishes during the drain")
+	assert.ErrorIs(t, results[1].err, foreach.ErrSkipped)
+
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	require.Len(t, lines, 2)
+	assert.Equal(t, "a | done", lines[1])
+	pgid, err := strconv.Atoi(strings.TrimPrefix(lines[0], "a | "))
+	require.NoError(t, err)
+	assert.NotEqual(t, syscall.Getpgrp(), pgid, "kubectl runs in its own process group")
+}
@@ -0,0 +1,168 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// pickerMaxShown is the number of contexts the picker lists at most, to keep
+// the list on the screen for large kubeconfigs.
+const pickerMaxShown = 40
+
+// picker lets the user select some of the candidate contexts, narrowing down
+// the ones shown with a fuzzy query.
+type picker struct {
+	candidates []string
+	selected   map[string]bool
+	shown      []string // candidates matching the query, listed up to pickerMaxShown
+}
+
+func newPicker(candidates []string) *picker {
+	return &picker{candidates: candidates, selected: make(map[string]bool), shown: candidates}
+}
+
+// pick reads commands from in until an empty line, and returns the selected
+// contexts in the order of the candidates. "/QUERY" shows the contexts whose
+// names have the characters of QUERY in order ("/" shows all of them), "N" or
+// "N-M" (can be separated by spaces) select or unselect the listed contexts
+// with those numbers, and "*" selects all contexts matching the query (or
+// unselects them if they're all selected).
+func (p *picker) pick(in io.Reader, out io.Writer) []string {
+	s := bufio.NewScanner(in)
+	for {
+		p.print(out)
+		fmt.Fprintf(out, "Filter (/QUERY), toggle (N, N-M or *) or press enter when done (%d selected): ", len(p.selected))
+		if !s.Scan() {
+			break
+		}
+		v := strings.TrimSpace(s.Text())
+		if v == "" {
+			break
+		}
+		if err := p.command(v); err != nil {
+			fmt.Fprintln(out, red("error: ")+err.Error())
+		}
+	}
+	var picked []string
+	for _, c := range p.candidates {
+		if p.selected[c] {
+			picked = append(picked, c)
+		}
+	}
+	return picked
+}
+
+func (p *picker) command(v string) error {
+	switch {
+	case strings.HasPrefix(v, "/"):
+		p.shown = fuzzyFilter(p.candidates, strings.TrimSpace(v[1:]))
+		return nil
+	case v == "*":
+		all := true
+		for _, c := range p.shown {
+			all = all && p.selected[c]
+		}
+		for _, c := range p.shown {
+			p.toggle(c, !all)
+		}
+		return nil
+	}
+	listed := len(p.shown)
+	if listed > pickerMaxShown {
+		listed = pickerMaxShown
+	}
+	var picked []int
+	for _, f := range strings.Fields(v) {
+		from, to, err := parseRange(f, listed)
+		if err != nil {
+			return err
+		}
+		for i := from; i <= to; i++ {
+			picked = append(picked, i)
+		}
+	}
+	for _, i := range picked {
+		c := p.shown[i-1]
+		p.toggle(c, !p.selected[c])
+	}
+	return nil
+}
+
+func (p *picker) toggle(c string, on bool) {
+	if on {
+		p.selected[c] = true
+	} else {
+		delete(p.selected, c)
+	}
+}
+
+// parseRange parses "N" or "N-M" of items numbered from 1 to n.
+func parseRange(v string, n int) (from, to int, err error) {
+	a, b := v, v
+	if i := strings.IndexByte(v, '-'); i > 0 {
+		a, b = v[:i], v[i+1:]
+	}
+	from, err1 := strconv.Atoi(a)
+	to, err2 := strconv.Atoi(b)
+	if err1 != nil || err2 != nil {
+		return 0, 0, fmt.Errorf("invalid command %q, use /QUERY, N, N-M or *", v)
+	}
+	if from < 1 || to > n || from > to {
+		return 0, 0, fmt.Errorf("no contexts numbered %q (1-%d listed)", v, n)
+	}
+	return from, to, nil
+}
+
+func (p *picker) print(out io.Writer) {
+	if len(p.shown) == 0 {
+		fmt.Fprintln(out, "No contexts match the query.")
+		return
+	}
+	for i, c := range p.shown {
+		if i == pickerMaxShown {
+			fmt.Fprintln(out, gray(fmt.Sprintf("  ... and %d more, type /QUERY to narrow them down", len(p.shown)-i)))
+			break
+		}
+		mark := "[ ]"
+		if p.selected[c] {
+			mark = "[x]"
+		}
+		fmt.Fprintf(out, "  %s %d) %s\n", mark, i+1, c)
+	}
+}
+
+// fuzzyFilter returns the names that have the characters of query in the same
+// order, ignoring case, e.g. "pue" matches "prod-us-east".
+func fuzzyFilter(names []string, query string) []string {
+	q := []rune(strings.ToLower(query))
+	var out []string
+	for _, n := range names {
+		i := 0
+		for _, r := range strings.ToLower(n) {
+			if i < len(q) && r == q[i] {
+				i++
+			}
+		}
+		if i == len(q) {
+			out = append(out, n)
+		}
+	}
+	return out
+}
@@ -0,0 +1,79 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPicker(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+	ctxs := []string{"prod-us-east", "prod-eu-west", "staging-us-east", "dev"}
+
+	var out bytes.Buffer
+	got := newPicker(ctxs).pick(strings.NewReader("/east\n*\n/\n4\n2 9\n\n"), &out)
+	assert.Equal(t, []string{"prod-us-east", "staging-us-east", "dev"}, got)
+	assert.Contains(t, out.String(), "  [x] 1) prod-us-east\n  [ ] 2) prod-eu-west\n  [x] 3) staging-us-east\n  [x] 4) dev\n")
+	assert.Contains(t, out.String(), `error: no contexts numbered "9" (1-4 listed)`)
+
+	// * unselects the contexts if all of them are selected
+	got = newPicker(ctxs).pick(strings.NewReader("1-3\n/east\n*\n"), &out)
+	assert.Equal(t, []string{"prod-eu-west"}, got)
+
+	// EOF ends the selection
+	assert.Empty(t, newPicker(ctxs).pick(strings.NewReader("/x\nfoo\n"), &out))
+	assert.Contains(t, out.String(), "No contexts match the query.")
+}
+
+func TestPicker_longList(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+	var ctxs []string
+	for i := 0; i < pickerMaxShown+5; i++ {
+		ctxs = append(ctxs, fmt.Sprintf("c%d", i))
+	}
+	var out bytes.Buffer
+	assert.Empty(t, newPicker(ctxs).pick(strings.NewReader(fmt.Sprintf("%d\n", pickerMaxShown+1)), &out))
+	assert.Contains(t, out.String(), "  ... and 5 more, type /QUERY to narrow them down\n")
+	assert.NotContains(t, out.String(), ") c40\n")
+}
+
+func TestParseRange(t *testing.T) {
+	from, to, err := parseRange("2-4", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{2, 4}, []int{from, to})
+	from, to, err = parseRange("3", 5)
+	assert.NoError(t, err)
+	assert.Equal(t, []int{3, 3}, []int{from, to})
+	for _, v := range []string{"0", "6", "4-2", "a", "1-", "-1"} {
+		_, _, err := parseRange(v, 5)
+		assert.Error(t, err, v)
+	}
+}
+
+func TestFuzzyFilter(t *testing.T) {
+	names := []string{"prod-us-east", "Prod-EU-West", "dev"}
+	assert.Equal(t, []string{"prod-us-east"}, fuzzyFilter(names, "pusea"))
+	assert.Equal(t, []string{"prod-us-east", "Prod-EU-West"}, fuzzyFilter(names, "PRD"))
+	assert.Equal(t, names, fuzzyFilter(names, ""))
+	assert.Empty(t, fuzzyFilter(names, "ved"))
+}
@@ -0,0 +1,87 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"runtime"
+	"syscall"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// shellCommand returns a command that runs s with the shell of the OS.
+func shellCommand(ctx context.Context, s string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", s)
+	}
+	return exec.CommandContext(ctx, "sh", "-c", s)
+}
+
+// runPiped runs cmd with its stdout piped to the stdin of the filter shell
+// command, whose stdout is written to stdout. stderr of both commands are
+// written to stderr. If the filter fails, its error is returned, as cmd may
+// have failed only because the filter stopped reading. If ctx is done, both
+// commands are cancelled with foreach.Wait.
+func runPiped(ctx context.Context, cmd *exec.Cmd, filter string, stdout, stderr io.Writer) error {
+	stderr = &synchronizedWriter{Writer: stderr}
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		return err
+	}
+	f := shellCommand(context.Background(), filter) // cancelled by foreach.Wait
+	f.Stdin = pr
+	f.Stdout = stdout
+	f.Stderr = stderr
+	cmd.Stdout = pw
+	cmd.Stderr = stderr
+
+	if err := startCmd(f); err != nil {
+		pr.Close()
+		pw.Close()
+		return fmt.Errorf("failed to start pipe command: %w", err)
+	}
+	pr.Close() // the filter has its own copy now
+	err = startCmd(cmd)
+	pw.Close() // so the filter gets EOF when cmd exits
+	if err != nil {
+		_ = foreach.Wait(ctx, f, *interruptGrace)
+		return err
+	}
+	cmdErr := foreach.Wait(ctx, cmd, *interruptGrace)
+	filterErr := foreach.Wait(ctx, f, *interruptGrace)
+	if filterErr != nil {
+		return fmt.Errorf("pipe command failed: %w", filterErr)
+	}
+	if isBrokenPipe(cmdErr) {
+		return nil // the filter has stopped reading (e.g. head), like in shells
+	}
+	return cmdErr
+}
+
+// isBrokenPipe reports whether err is from a process killed by SIGPIPE.
+func isBrokenPipe(err error) bool {
+	var exitErr *exec.ExitError
+	if !errors.As(err, &exitErr) {
+		return false
+	}
+	ws, ok := exitErr.Sys().(syscall.WaitStatus)
+	return ok && ws.Signaled() && ws.Signal() == syscall.SIGPIPE
+}
@@ -0,0 +1,63 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_runPiped(t *testing.T) {
+	ctx := context.Background()
+	t.Run("filters stdout", func(t *testing.T) {
+		var stdout, stderr bytes.Buffer
+		cmd := exec.Command("sh", "-c", "echo a; echo b; echo err >&2")
+		err := runPiped(ctx, cmd, "grep b", &stdout, &stderr)
+		assert.NoError(t, err)
+		assert.Equal(t, "b\n", stdout.String())
+		assert.Equal(t, "err\n", stderr.String())
+	})
+	t.Run("command fails", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", "echo a; exit 3")
+		err := runPiped(ctx, cmd, "cat", &stdout, &bytes.Buffer{})
+		var exitErr *exec.ExitError
+		assert.True(t, errors.As(err, &exitErr))
+		assert.Equal(t, 3, exitErr.ExitCode())
+		assert.Equal(t, "a\n", stdout.String())
+	})
+	t.Run("filter fails", func(t *testing.T) {
+		cmd := exec.Command("sh", "-c", "echo a")
+		err := runPiped(ctx, cmd, "exit 4", &bytes.Buffer{}, &bytes.Buffer{})
+		assert.ErrorContains(t, err, "pipe command failed")
+	})
+	t.Run("command fails to start", func(t *testing.T) {
+		cmd := exec.Command("kubectl-foreach-nonexistent-binary")
+		err := runPiped(ctx, cmd, "cat", &bytes.Buffer{}, &bytes.Buffer{})
+		assert.Error(t, err)
+	})
+	t.Run("filter exits early", func(t *testing.T) {
+		var stdout bytes.Buffer
+		cmd := exec.Command("sh", "-c", "for i in 1 2 3; do echo $i; done")
+		err := runPiped(ctx, cmd, "head -n 1", &stdout, &bytes.Buffer{})
+		assert.NoError(t, err)
+		assert.Equal(t, "1\n", stdout.String())
+	})
+}
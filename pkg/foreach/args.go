@@ -0,0 +1,60 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import "strings"
+
+// ContextPlaceholder is replaced with the context name in the arguments of
+// ReplaceArgs, in addition to the repl tokens.
+const ContextPlaceholder = "{{context}}"
+
+// ReplaceArgs returns the kubectl arguments of a context. The occurrences of
+// ContextPlaceholder and of each non-empty repl token in args are replaced
+// with the context name. If there are no repl tokens and args don't have
+// ContextPlaceholder, the context is passed with --context before args.
+func ReplaceArgs(args []string, repl ...string) func(ctx string) []string {
+	tokens := []string{ContextPlaceholder}
+	for _, r := range repl {
+		if r != "" {
+			tokens = append(tokens, r)
+		}
+	}
+	prepend := len(tokens) == 1 && !hasArg(args, ContextPlaceholder)
+	return func(ctx string) []string {
+		if prepend {
+			return append([]string{"--context=" + ctx}, args...)
+		}
+		pairs := make([]string, 0, 2*len(tokens))
+		for _, t := range tokens {
+			pairs = append(pairs, t, ctx)
+		}
+		r := strings.NewReplacer(pairs...)
+		out := make([]string, len(args))
+		for i := range args {
+			out[i] = r.Replace(args[i])
+		}
+		return out
+	}
+}
+
+// hasArg reports whether any of args contains s.
+func hasArg(args []string, s string) bool {
+	for _, a := range args {
+		if strings.Contains(a, s) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,52 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReplaceArgs(t *testing.T) {
+	t.Run("no replace", func(t *testing.T) {
+		assert.Equal(t, []string{"--context=ctx"}, ReplaceArgs(nil, "")("ctx"))
+		assert.Equal(t, []string{"--context=ctx", "arg1", "arg2"}, ReplaceArgs([]string{"arg1", "arg2"}, "")("ctx"))
+	})
+	t.Run("no hits", func(t *testing.T) {
+		assert.Equal(t, []string{}, ReplaceArgs([]string{}, "X")("ctx"))
+		assert.Equal(t, []string{"arg1"}, ReplaceArgs([]string{"arg1"}, "X")("ctx"))
+	})
+	t.Run("hits", func(t *testing.T) {
+		assert.Equal(t, []string{"a", "ctxctx", "actx"}, ReplaceArgs([]string{"a", "XX", "aX"}, "X")("ctx"))
+		assert.Equal(t, []string{"a", "ctx", "aX"}, ReplaceArgs([]string{"a", "XX", "aX"}, "XX")("ctx"))
+	})
+	t.Run("empty token", func(t *testing.T) {
+		assert.Equal(t, []string{"--context=ctx", "X"}, ReplaceArgs([]string{"X"}, "")("ctx"))
+		assert.Equal(t, []string{"--context=ctx", "X"}, ReplaceArgs([]string{"X"}, "", "")("ctx"))
+	})
+	t.Run("multiple tokens", func(t *testing.T) {
+		assert.Equal(t, []string{"--context=ctx", "-n", "team-ctx"},
+			ReplaceArgs([]string{"--context={}", "-n", "team-_"}, "{}", "_")("ctx"))
+		assert.Equal(t, []string{"ctx-ctx"}, ReplaceArgs([]string{"XX-Y"}, "Y", "XX")("ctx"))
+		assert.Equal(t, []string{"a"}, ReplaceArgs([]string{"a"}, "X", "Y")("ctx"))
+	})
+	t.Run("context placeholder", func(t *testing.T) {
+		assert.Equal(t, []string{"--context=ctx", "-n", "team-ctx"},
+			ReplaceArgs([]string{"--context={{context}}", "-n", "team-{{context}}"})("ctx"))
+		assert.Equal(t, []string{"get", "ctx", "ctx"}, ReplaceArgs([]string{"get", "{{context}}", "X"}, "X")("ctx"))
+		assert.Equal(t, []string{"get", "{context}"}, ReplaceArgs([]string{"get", "{context}"}, "X")("ctx"))
+	})
+}
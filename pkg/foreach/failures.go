@@ -0,0 +1,136 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// ErrSkipped is the error of contexts in which the command didn't run, e.g.
+// because of the Breaker, or once Drain was done.
+var ErrSkipped = errors.New("skipped")
+
+// ErrMaxFailures is the error of contexts skipped because the command failed
+// in MaxFailures other contexts. It's an ErrSkipped.
+var ErrMaxFailures = fmt.Errorf("%w: too many failures", ErrSkipped)
+
+// ErrEmptyOutput is the error of commands that succeeded without printing
+// anything with FailOnEmpty.
+var ErrEmptyOutput = errors.New("empty output")
+
+// ErrTimeout is the error of commands killed after running longer than
+// Timeout.
+var ErrTimeout = errors.New("timed out")
+
+// ErrUnreachable is the error of commands that failed because the API server
+// of the context could not be reached, with SkipUnreachable.
+var ErrUnreachable = errors.New("unreachable")
+
+// unreachablePatterns are found in the errors of kubectl when it cannot
+// connect to the API server.
+var unreachablePatterns = []string{
+	"connection refused",
+	"no such host",
+	"i/o timeout",
+	"network is unreachable",
+	"no route to host",
+	"TLS handshake timeout",
+	"Unable to connect to the server",
+}
+
+// isUnreachable reports whether stderr of kubectl indicates a connection error.
+func isUnreachable(stderr string) bool {
+	for _, p := range unreachablePatterns {
+		if strings.Contains(stderr, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons of failures in Result.Reason.
+const (
+	ReasonNotFound  = "not-found"
+	ReasonForbidden = "forbidden"
+	ReasonTimeout   = "timeout"
+	ReasonOther     = "other"
+)
+
+// FailurePattern classifies failures whose stderr matches the pattern.
+type FailurePattern struct {
+	Reason  string
+	Pattern *regexp.Regexp
+}
+
+// DefaultFailurePatterns match common errors of kubectl. The first matching
+// pattern gives the reason.
+var DefaultFailurePatterns = []FailurePattern{
+	{Reason: ReasonForbidden, Pattern: regexp.MustCompile(`(?i)\bforbidden\b|\bunauthorized\b|must be logged in`)},
+	{Reason: ReasonNotFound, Pattern: regexp.MustCompile(`\(NotFound\)|\bnot found\b|doesn't have a resource type`)},
+	{Reason: ReasonTimeout, Pattern: regexp.MustCompile(`(?i)timeout|timed out|deadline exceeded`)},
+}
+
+// classifyFailure returns the reason of the first pattern matching stderr of
+// a failed command, or ReasonOther.
+func classifyFailure(stderr string, ps []FailurePattern) string {
+	for _, p := range ps {
+		if p.Pattern.MatchString(stderr) {
+			return p.Reason
+		}
+	}
+	return ReasonOther
+}
+
+// failed reports whether err of a context counts as a failure, and not as
+// skipped or unreachable.
+func failed(err error) bool {
+	return err != nil && !errors.Is(err, ErrSkipped) && !errors.Is(err, ErrUnreachable)
+}
+
+// failureLimit cancels the run once max contexts have failed, with
+// MaxFailures. A nil *failureLimit is never reached.
+type failureLimit struct {
+	max    int
+	cancel func()
+
+	mu     sync.Mutex
+	failed int
+}
+
+// record counts a failed context, and cancels the run at the limit.
+func (l *failureLimit) record() {
+	if l == nil {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.failed++; l.failed == l.max {
+		l.cancel()
+	}
+}
+
+// reached reports whether the run has been cancelled.
+func (l *failureLimit) reached() bool {
+	if l == nil {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.failed >= l.max
+}
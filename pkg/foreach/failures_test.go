@@ -0,0 +1,67 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_isUnreachable(t *testing.T) {
+	assert.True(t, isUnreachable(`The connection to the server 10.0.0.1:6443 was refused - did you specify the right host or port?
+Unable to connect to the server: dial tcp 10.0.0.1:6443: connect: connection refused`))
+	assert.True(t, isUnreachable("Unable to connect to the server: dial tcp: lookup foo.example on 127.0.0.53:53: no such host"))
+	assert.True(t, isUnreachable("Unable to connect to the server: dial tcp 10.0.0.1:443: i/o timeout"))
+	assert.False(t, isUnreachable(`Error from server (NotFound): pods "foo" not found`))
+	assert.False(t, isUnreachable(""))
+}
+
+func Test_classifyFailure(t *testing.T) {
+	for in, want := range map[string]string{
+		`Error from server (NotFound): deployments.apps "foo" not found`:                  ReasonNotFound,
+		`error: the server doesn't have a resource type "foos"`:                           ReasonNotFound,
+		`Error from server (Forbidden): pods is forbidden: User "x" cannot list resource`: ReasonForbidden,
+		`error: You must be logged in to the server (Unauthorized)`:                       ReasonForbidden,
+		`Error from server (Timeout): the server was unable to return a response in time`: ReasonTimeout,
+		`error: context deadline exceeded`:                                                ReasonTimeout,
+		`error: something else`:                                                           ReasonOther,
+		``:                                                                                ReasonOther,
+	} {
+		assert.Equal(t, want, classifyFailure(in, DefaultFailurePatterns), in)
+	}
+}
+
+func Test_failureLimit(t *testing.T) {
+	var nilLimit *failureLimit
+	nilLimit.record()
+	assert.False(t, nilLimit.reached())
+
+	cancelled := 0
+	l := &failureLimit{max: 2, cancel: func() { cancelled++ }}
+	l.record()
+	assert.False(t, l.reached())
+	l.record()
+	l.record()
+	assert.True(t, l.reached())
+	assert.Equal(t, 1, cancelled)
+}
+
+func TestErrMaxFailures(t *testing.T) {
+	assert.ErrorIs(t, ErrMaxFailures, ErrSkipped)
+	assert.False(t, failed(ErrMaxFailures))
+	assert.False(t, failed(ErrUnreachable))
+	assert.True(t, failed(ErrTimeout))
+}
@@ -0,0 +1,335 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package foreach runs kubectl commands in many contexts in parallel, and
+// matches the contexts to run in with the patterns of kubectl-foreach.
+package foreach
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// Filter matches context names. Contexts are matched if any of the additive
+// filters (or there are none) and none of the other filters match them.
+type Filter interface {
+	Match(string) bool
+	Additive() bool
+}
+
+// Exact matches a context name exactly.
+type Exact string
+
+func (e Exact) Match(in string) bool { return in == string(e) }
+func (Exact) Additive() bool         { return true }
+
+// ExactFold is an exact match ignoring case.
+type ExactFold string
+
+func (e ExactFold) Match(in string) bool { return strings.EqualFold(in, string(e)) }
+func (ExactFold) Additive() bool         { return true }
+
+// Pattern matches context names with a regular expression.
+type Pattern struct{ *regexp.Regexp }
+
+func (p Pattern) Match(in string) bool { return p.MatchString(in) }
+func (Pattern) Additive() bool         { return true }
+
+// Glob matches names with a shell-style GLOB, or the exact name GLOB.
+type Glob struct {
+	expr string
+	*regexp.Regexp
+}
+
+func (g Glob) Match(in string) bool { return in == g.expr || g.MatchString(in) }
+func (Glob) Additive() bool         { return true }
+
+// CurrentPattern is the pattern of the current context of kubeconfig.
+const CurrentPattern = "@current"
+
+// Current matches the current context of kubeconfig. Name must be set before
+// matching, and is empty if there's no current context.
+type Current struct{ Name string }
+
+func (c *Current) Match(in string) bool { return c.Name != "" && in == c.Name }
+func (*Current) Additive() bool         { return true }
+
+// Exclude removes the contexts matched by its filter.
+type Exclude struct{ Filter }
+
+func (e Exclude) Match(s string) bool { return e.Filter.Match(s) }
+func (Exclude) Additive() bool        { return false }
+
+// Affix matches contexts starting with any of the prefixes and ending with any
+// of the suffixes. No prefixes (or suffixes) means any prefix (or suffix).
+type Affix struct{ Prefixes, Suffixes []string }
+
+func (a Affix) Match(in string) bool {
+	return hasAny(in, a.Prefixes, strings.HasPrefix) && hasAny(in, a.Suffixes, strings.HasSuffix)
+}
+func (Affix) Additive() bool { return true }
+
+func hasAny(in string, vs []string, has func(s, v string) bool) bool {
+	if len(vs) == 0 {
+		return true
+	}
+	for _, v := range vs {
+		if has(in, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// FieldLookup returns the value of a field (namespace, cluster, user, server)
+// of a context.
+type FieldLookup func(ctx, key string) (string, bool)
+
+// compoundKeys are the keys that can be used in compound filters, mapped to
+// the context field they match.
+var compoundKeys = map[string]string{
+	"name":      "name",
+	"ns":        "namespace",
+	"namespace": "namespace",
+	"cluster":   "cluster",
+	"user":      "user",
+	"server":    "server",
+}
+
+type term struct {
+	field string
+	Filter
+}
+
+// Compound matches contexts for which all of its terms match their fields.
+// Fields must be set before matching, unless all terms are on "name".
+type Compound struct {
+	terms  []term
+	Fields FieldLookup
+}
+
+func (c *Compound) Match(in string) bool {
+	for _, t := range c.terms {
+		var v string
+		switch t.field {
+		case "name":
+			v = in
+		case "namespace":
+			v, _ = c.Fields(in, t.field)
+			if v == "" {
+				v = "default" // what kubectl uses if the context has no namespace
+			}
+		default:
+			v, _ = c.Fields(in, t.field)
+		}
+		if !t.Match(v) {
+			return false
+		}
+	}
+	return true
+}
+
+func (*Compound) Additive() bool { return true }
+
+// NeedsFields reports whether any compound filter in f matches on fields
+// other than context name.
+func NeedsFields(f []Filter) bool {
+	for _, c := range Compounds(f) {
+		for _, t := range c.terms {
+			if t.field != "name" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Compounds returns the compound filters in f, including the excluded ones.
+func Compounds(f []Filter) []*Compound {
+	var out []*Compound
+	for _, v := range f {
+		if e, ok := v.(Exclude); ok {
+			v = e.Filter
+		}
+		if c, ok := v.(*Compound); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// Currents returns the @current filters in f, including the excluded ones.
+func Currents(f []Filter) []*Current {
+	var out []*Current
+	for _, v := range f {
+		if e, ok := v.(Exclude); ok {
+			v = e.Filter
+		}
+		if c, ok := v.(*Current); ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
+
+// ParseFilter parses a command-line syntax of a matcher: a NAME, a /PATTERN/,
+// a GLOB, KEY:VALUE terms or @current, excluded if prefixed with ^. With
+// ignoreCase, names, globs and patterns ignore the case of context names.
+func ParseFilter(in string, ignoreCase bool) (Filter, error) {
+	if in == "" {
+		return nil, errors.New("empty string cannot be used as a filter")
+	}
+
+	var exclusion bool
+	if in[0] == '^' {
+		in = in[1:]
+		exclusion = true
+	}
+	var f Filter
+	var err error
+	if in == CurrentPattern {
+		f = &Current{}
+	} else if isCompound(in) {
+		f, err = parseCompound(in, ignoreCase)
+	} else {
+		f, err = parseValueFilter(in, ignoreCase)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if exclusion {
+		return Exclude{f}, nil
+	}
+	return f, nil
+}
+
+// parseValueFilter parses an exact match, a /pattern/ or a glob (a name with *
+// or ?).
+func parseValueFilter(in string, ignoreCase bool) (Filter, error) {
+	// pattern /re/
+	if len(in) > 1 && in[0] == '/' && in[len(in)-1] == '/' {
+		expr := in[1 : len(in)-1]
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		r, err := regexp.Compile(expr)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern '%s': %w", in, err)
+		}
+		return Pattern{r}, nil
+	}
+	// glob
+	if strings.ContainsAny(in, "*?") {
+		expr, err := globRegexp(in)
+		if err != nil {
+			return nil, fmt.Errorf("invalid glob '%s': %w", in, err)
+		}
+		if ignoreCase {
+			expr = "(?i)" + expr
+		}
+		return Glob{expr: in, Regexp: regexp.MustCompile(expr)}, nil
+	}
+	// exact match
+	if ignoreCase {
+		return ExactFold(in), nil
+	}
+	return Exact(in), nil
+}
+
+// globRegexp returns the regular expression of a glob, where * matches any
+// characters (also /, unlike path.Match, for names like EKS ARNs), ? matches
+// one character, [...] (or [!...]) matches one of (or none of) the characters
+// in it, and \ escapes the next character.
+func globRegexp(g string) (string, error) {
+	var b strings.Builder
+	b.WriteByte('^')
+	for i := 0; i < len(g); i++ {
+		switch g[i] {
+		case '*':
+			b.WriteString(".*")
+		case '?':
+			b.WriteByte('.')
+		case '\\':
+			if i++; i == len(g) {
+				return "", errors.New("trailing \\")
+			}
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		case '[':
+			j := i + 1
+			if j < len(g) && g[j] == '!' {
+				j++
+			}
+			if j < len(g) && g[j] == ']' {
+				j++ // ] as the first character is a member of the class
+			}
+			for j < len(g) && g[j] != ']' {
+				j++
+			}
+			if j == len(g) {
+				return "", errors.New("missing ]")
+			}
+			class := g[i+1 : j]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			b.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i = j
+		default:
+			b.WriteString(regexp.QuoteMeta(g[i : i+1]))
+		}
+	}
+	b.WriteByte('$')
+	if _, err := regexp.Compile(b.String()); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// isCompound reports whether in starts with a "key:" of a compound filter.
+func isCompound(in string) bool {
+	i := strings.IndexByte(in, ':')
+	if i == -1 {
+		return false
+	}
+	_, ok := compoundKeys[in[:i]]
+	return ok
+}
+
+// parseCompound parses whitespace-separated "key:VALUE" terms, where VALUE is
+// an exact match or a /pattern/.
+func parseCompound(in string, ignoreCase bool) (*Compound, error) {
+	c := &Compound{}
+	for _, v := range strings.Fields(in) {
+		i := strings.IndexByte(v, ':')
+		if i == -1 {
+			return nil, fmt.Errorf("invalid term '%s' in '%s': need key:VALUE", v, in)
+		}
+		field, ok := compoundKeys[v[:i]]
+		if !ok {
+			return nil, fmt.Errorf("unknown key '%s' in '%s'", v[:i], in)
+		}
+		if v[i+1:] == "" {
+			return nil, fmt.Errorf("empty value for key '%s' in '%s'", v[:i], in)
+		}
+		f, err := parseValueFilter(v[i+1:], ignoreCase)
+		if err != nil {
+			return nil, err
+		}
+		c.terms = append(c.terms, term{field: field, Filter: f})
+	}
+	return c, nil
+}
@@ -0,0 +1,252 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"fmt"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseFilter(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		want    Filter
+		wantErr require.ErrorAssertionFunc
+	}{
+		{name: "empty spec",
+			in:      "",
+			wantErr: require.Error},
+		{name: "exact match",
+			in:      "foo",
+			want:    Exact("foo"),
+			wantErr: require.NoError},
+		{name: "exact match inverted",
+			in:      "^foo",
+			want:    Exclude{Exact("foo")},
+			wantErr: require.NoError},
+		{name: "pattern",
+			in:      "/re/",
+			want:    Pattern{regexp.MustCompile("re")},
+			wantErr: require.NoError},
+		{name: "glob",
+			in:      "prod-*",
+			want:    Glob{expr: "prod-*", Regexp: regexp.MustCompile(`^prod-.*$`)},
+			wantErr: require.NoError},
+		{name: "glob inverted",
+			in:      "^prod-?",
+			want:    Exclude{Glob{expr: "prod-?", Regexp: regexp.MustCompile(`^prod-.$`)}},
+			wantErr: require.NoError},
+		{name: "invalid glob",
+			in:      "prod-[*",
+			wantErr: require.Error},
+		{name: "glob in slashes is a pattern",
+			in:      "/prod-*/",
+			want:    Pattern{regexp.MustCompile("prod-*")},
+			wantErr: require.NoError},
+		{name: "pattern missing trailing slash",
+			in:      "/re",
+			want:    Exact("/re"),
+			wantErr: require.NoError},
+		{name: "pattern parse error",
+			in:      "/re(/",
+			wantErr: require.Error},
+		{name: "pattern inverted",
+			in:      "^/re/",
+			want:    Exclude{Pattern{regexp.MustCompile("re")}},
+			wantErr: require.NoError},
+		{name: "compound",
+			in: "name:/^prod-/ ns:default",
+			want: &Compound{terms: []term{
+				{field: "name", Filter: Pattern{regexp.MustCompile("^prod-")}},
+				{field: "namespace", Filter: Exact("default")},
+			}},
+			wantErr: require.NoError},
+		{name: "compound inverted",
+			in:      "^cluster:c1",
+			want:    Exclude{&Compound{terms: []term{{field: "cluster", Filter: Exact("c1")}}}},
+			wantErr: require.NoError},
+		{name: "compound unknown key",
+			in:      "name:a foo:b",
+			wantErr: require.Error},
+		{name: "compound term without key",
+			in:      "name:a b",
+			wantErr: require.Error},
+		{name: "compound empty value",
+			in:      "ns:",
+			wantErr: require.Error},
+		{name: "compound pattern parse error",
+			in:      "name:/re(/",
+			wantErr: require.Error},
+		{name: "unknown key is an exact match",
+			in:      "arn:aws:eks:us-east-1:123456789012:cluster/foo",
+			want:    Exact("arn:aws:eks:us-east-1:123456789012:cluster/foo"),
+			wantErr: require.NoError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseFilter(tt.in, false)
+			tt.wantErr(t, err, fmt.Sprintf("ParseFilter(%q)", tt.in))
+			assert.Equalf(t, tt.want, got, "ParseFilter(%q)", tt.in)
+		})
+	}
+}
+
+func TestExact(t *testing.T) {
+	v := Exact("foo")
+	assert.True(t, v.Additive())
+	assert.True(t, v.Match("foo"))
+	assert.False(t, v.Match("bar"))
+}
+
+func TestExactFold(t *testing.T) {
+	v := ExactFold("prod-eu")
+	assert.True(t, v.Additive())
+	assert.True(t, v.Match("Prod-EU"))
+	assert.False(t, v.Match("prod-us"))
+}
+
+func TestParseFilter_ignoreCase(t *testing.T) {
+	ctxs := []string{"Prod-EU", "prod-us", "dev"}
+	for in, want := range map[string][]string{
+		"/^prod-/":          {"Prod-EU", "prod-us"},
+		"PROD-US":           {"prod-us"},
+		"name:/eu$/":        {"Prod-EU"},
+		"^prod-eu":          {"prod-us", "dev"},
+		"^/^PROD/":          {"dev"},
+		"^name:/^prod-us$/": {"Prod-EU", "dev"},
+	} {
+		f, err := ParseFilter(in, true)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, MatchContexts(ctxs, []Filter{f}), in)
+	}
+}
+
+func TestGlob(t *testing.T) {
+	ctxs := []string{
+		"gke_acme_us-east1_prod-us-east1",
+		"arn:aws:eks:us-west-2:123456789012:cluster/prod-us-west-2",
+		"prod-us-1",
+		"prod-eu-1",
+		"staging-us-1",
+		"dev",
+	}
+	for in, want := range map[string][]string{
+		"*-us-*":          {"gke_acme_us-east1_prod-us-east1", "arn:aws:eks:us-west-2:123456789012:cluster/prod-us-west-2", "prod-us-1", "staging-us-1"},
+		"prod-??-1":       {"prod-us-1", "prod-eu-1"},
+		"prod-[!u]*":      {"prod-eu-1"},
+		"*-[ue][su]-1":    {"prod-us-1", "prod-eu-1", "staging-us-1"},
+		"*cluster/prod-*": {"arn:aws:eks:us-west-2:123456789012:cluster/prod-us-west-2"},
+		"name:*-us-1":     {"prod-us-1", "staging-us-1"},
+		"^*-us-*":         {"prod-eu-1", "dev"},
+		"*prod*":          {"gke_acme_us-east1_prod-us-east1", "arn:aws:eks:us-west-2:123456789012:cluster/prod-us-west-2", "prod-us-1", "prod-eu-1"},
+		"d?v":             {"dev"},
+		"\\*":             nil,
+	} {
+		f, err := ParseFilter(in, false)
+		require.NoError(t, err, in)
+		assert.Equal(t, want, MatchContexts(ctxs, []Filter{f}), in)
+	}
+
+	// a context named like the glob is matched too
+	f, err := ParseFilter("team[1]-*", false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"team[1]-*", "team1-a"}, MatchContexts([]string{"team[1]-*", "team1-a", "team2-a"}, []Filter{f}))
+}
+
+func TestCurrent(t *testing.T) {
+	f, err := ParseFilter("@current", false)
+	require.NoError(t, err)
+	assert.Equal(t, &Current{}, f)
+	ex, err := ParseFilter("^@current", false)
+	require.NoError(t, err)
+	assert.Equal(t, Exclude{&Current{}}, ex)
+
+	cs := Currents([]Filter{f, Exact("a"), ex})
+	require.Len(t, cs, 2)
+	ctxs := []string{"a", "b", "c"}
+	assert.Empty(t, MatchContexts(ctxs, []Filter{f}), "no current context")
+	for _, c := range cs {
+		c.Name = "b"
+	}
+	assert.Equal(t, []string{"b"}, MatchContexts(ctxs, []Filter{f}))
+	assert.Equal(t, []string{"a", "c"}, MatchContexts(ctxs, []Filter{ex}))
+}
+
+func TestPattern(t *testing.T) {
+	v := Pattern{regexp.MustCompile("^re")}
+	assert.True(t, v.Additive())
+	assert.False(t, v.Match("are"))
+	assert.True(t, v.Match("res"))
+}
+
+func TestExclude(t *testing.T) {
+	v := Exclude{Exact("foo")}
+	assert.False(t, v.Additive())
+	assert.False(t, v.Match("bar"))
+	assert.True(t, v.Match("foo"))
+}
+
+func TestAffix(t *testing.T) {
+	v := Affix{Prefixes: []string{"prod-", "staging-"}}
+	assert.True(t, v.Additive())
+	assert.True(t, v.Match("prod-us"))
+	assert.True(t, v.Match("staging-eu"))
+	assert.False(t, v.Match("dev-prod-us"))
+
+	v = Affix{Suffixes: []string{"-us"}}
+	assert.True(t, v.Match("prod-us"))
+	assert.False(t, v.Match("prod-us-2"))
+
+	v = Affix{Prefixes: []string{"prod-"}, Suffixes: []string{"-us", "-eu"}}
+	assert.True(t, v.Match("prod-us"))
+	assert.True(t, v.Match("prod-eu"))
+	assert.False(t, v.Match("prod-asia"))
+	assert.False(t, v.Match("dev-us"))
+}
+
+func TestCompound(t *testing.T) {
+	fields := func(ctx, key string) (string, bool) {
+		v := map[string]map[string]string{
+			"prod-a": {"namespace": "default", "cluster": "c1"},
+			"prod-b": {"namespace": "kube-system", "cluster": "c1"},
+			"prod-c": {"cluster": "c2"},
+		}[ctx][key]
+		return v, v != ""
+	}
+	f, err := ParseFilter("name:/^prod-/ ns:default", false)
+	require.NoError(t, err)
+	c := f.(*Compound)
+	c.Fields = fields
+	assert.True(t, c.Additive())
+	assert.True(t, c.Match("prod-a"))
+	assert.False(t, c.Match("prod-b"))
+	assert.True(t, c.Match("prod-c"), "contexts without namespace use default")
+	assert.False(t, c.Match("dev-a"))
+}
+
+func TestNeedsFields(t *testing.T) {
+	byName, err := ParseFilter("name:a", false)
+	require.NoError(t, err)
+	byNs, err := ParseFilter("^ns:a", false)
+	require.NoError(t, err)
+	assert.False(t, NeedsFields([]Filter{Exact("a"), byName}))
+	assert.True(t, NeedsFields([]Filter{Exact("a"), byNs}))
+	assert.Len(t, Compounds([]Filter{Exact("a"), byName, byNs}), 2)
+}
@@ -0,0 +1,80 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"hash"
+	"regexp"
+)
+
+// outputHasher computes the sha256 of the output written to it, with the
+// matches of the ignore patterns removed from each line first (e.g. timestamps
+// or ages that differ in every run).
+type outputHasher struct {
+	ignore []*regexp.Regexp
+	h      hash.Hash
+	line   []byte
+}
+
+func newOutputHasher(ignore []*regexp.Regexp) *outputHasher {
+	return &outputHasher{ignore: ignore, h: sha256.New()}
+}
+
+// reset discards the output written so far, e.g. before a retry.
+func (o *outputHasher) reset() {
+	o.h.Reset()
+	o.line = o.line[:0]
+}
+
+func (o *outputHasher) Write(p []byte) (int, error) {
+	if len(o.ignore) == 0 {
+		return o.h.Write(p)
+	}
+	for _, c := range p {
+		o.line = append(o.line, c)
+		if c == '\n' {
+			o.writeLine()
+		}
+	}
+	return len(p), nil
+}
+
+// writeLine hashes the buffered line, matching the patterns without its
+// newline, so that they can use $ for the end of the line.
+func (o *outputHasher) writeLine() {
+	line := o.line
+	nl := len(line) > 0 && line[len(line)-1] == '\n'
+	if nl {
+		line = line[:len(line)-1]
+	}
+	for _, re := range o.ignore {
+		line = re.ReplaceAll(line, nil)
+	}
+	o.h.Write(line)
+	if nl {
+		o.h.Write([]byte{'\n'})
+	}
+	o.line = o.line[:0]
+}
+
+// sum returns the hex-encoded hash of the output written so far.
+func (o *outputHasher) sum() string {
+	if len(o.line) > 0 {
+		o.writeLine()
+	}
+	return hex.EncodeToString(o.h.Sum(nil))
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io"
+	"regexp"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_outputHasher(t *testing.T) {
+	sum := func(s string) string {
+		b := sha256.Sum256([]byte(s))
+		return hex.EncodeToString(b[:])
+	}
+	h := newOutputHasher(nil)
+	io.WriteString(h, "a\nb")
+	assert.Equal(t, sum("a\nb"), h.sum())
+
+	age := regexp.MustCompile(`\s+\d+[smhd]$`)
+	h = newOutputHasher([]*regexp.Regexp{age})
+	io.WriteString(h, "pod-a 5m\npo")
+	io.WriteString(h, "d-b 3d")
+	assert.Equal(t, sum("pod-a\npod-b"), h.sum(), "partial lines are normalized as a whole")
+
+	h2 := newOutputHasher([]*regexp.Regexp{age})
+	io.WriteString(h2, "pod-a 7h\npod-b 4d")
+	assert.Equal(t, h.sum(), h2.sum())
+}
@@ -0,0 +1,43 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_startDelays(t *testing.T) {
+	assert.Nil(t, startDelays(3, 0, rand.New(rand.NewSource(1))))
+
+	got := startDelays(100, time.Second, rand.New(rand.NewSource(1)))
+	assert.Len(t, got, 100)
+	for _, d := range got {
+		assert.True(t, d >= 0 && d < time.Second, d)
+	}
+	assert.NotEqual(t, got[0], got[1])
+}
+
+func Test_sleepCtx(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start := time.Now()
+	sleepCtx(ctx, time.Hour)
+	assert.Less(t, time.Since(start), time.Second)
+}
@@ -12,13 +12,15 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package foreach
 
-func matchContexts(in []string, f []filter) []string {
-	var additive, subtractive []filter
+// MatchContexts returns the contexts in in matched by the filters f, in the
+// same order.
+func MatchContexts(in []string, f []Filter) []string {
+	var Additive, subtractive []Filter
 	for _, ff := range f {
-		if ff.additive() {
-			additive = append(additive, ff)
+		if ff.Additive() {
+			Additive = append(Additive, ff)
 		} else {
 			subtractive = append(subtractive, ff)
 		}
@@ -26,17 +28,17 @@ func matchContexts(in []string, f []filter) []string {
 
 	var out []string
 	for _, ctx := range in {
-		add, remove := len(additive) == 0, false
+		add, remove := len(Additive) == 0, false
 
-		for _, af := range additive {
-			if af.match(ctx) {
+		for _, af := range Additive {
+			if af.Match(ctx) {
 				add = true
 				break
 			}
 		}
 
 		for _, sf := range subtractive {
-			if sf.match(ctx) {
+			if sf.Match(ctx) {
 				remove = true
 				break
 			}
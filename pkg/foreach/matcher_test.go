@@ -12,7 +12,7 @@
 // See the License for the specific language governing permissions and
 // limitations under the License.
 
-package main
+package foreach
 
 import (
 	"regexp"
@@ -21,10 +21,10 @@ import (
 	"github.com/stretchr/testify/assert"
 )
 
-func Test_matchContexts(t *testing.T) {
+func TestMatchContexts(t *testing.T) {
 	type args struct {
 		in []string
-		f  []filter
+		f  []Filter
 	}
 	tests := []struct {
 		name string
@@ -34,48 +34,64 @@ func Test_matchContexts(t *testing.T) {
 		{name: "empty input",
 			args: args{
 				in: nil,
-				f:  []filter{exact("foo")}},
+				f:  []Filter{Exact("foo")}},
 			want: nil},
 		{name: "empty filters match all",
 			args: args{
 				in: []string{"a", "b", "c"},
-				f:  []filter{}},
+				f:  []Filter{}},
 			want: []string{"a", "b", "c"}},
 		{name: "only additive patterns",
 			args: args{
 				in: []string{"a", "b", "c"},
-				f:  []filter{exact("a"), pattern{regexp.MustCompile("^c")}}},
+				f:  []Filter{Exact("a"), Pattern{regexp.MustCompile("^c")}}},
 			want: []string{"a", "c"}},
 		{name: "only additive patterns no results",
 			args: args{
 				in: []string{"a", "b", "c"},
-				f:  []filter{exact("d"), pattern{regexp.MustCompile("^e")}}},
+				f:  []Filter{Exact("d"), Pattern{regexp.MustCompile("^e")}}},
 			want: nil},
+		{name: "prefix with patterns and exclusions",
+			args: args{
+				in: []string{"prod-a", "prod-b", "dev-a", "c"},
+				f:  []Filter{Exact("c"), Affix{Prefixes: []string{"prod-"}}, Exclude{Exact("prod-b")}}},
+			want: []string{"prod-a", "c"}},
 		{name: "only excluding patterns",
 			args: args{
 				in: []string{"a", "b", "c"},
-				f:  []filter{exclude{exact("b")}, exclude{exact("d")}}},
+				f:  []Filter{Exclude{Exact("b")}, Exclude{Exact("d")}}},
 			want: []string{"a", "c"}},
 		{name: "only excluding patterns no results",
 			args: args{
 				in: []string{"a", "b", "c"},
-				f:  []filter{exclude{pattern{regexp.MustCompile(`^`)}}}},
+				f:  []Filter{Exclude{Pattern{regexp.MustCompile(`^`)}}}},
 			want: nil},
 		{name: "mixed patterns",
 			args: args{
 				in: []string{"a", "b", "c", "d", "e"},
-				f: []filter{
-					exact("a"),
-					exact("b"),
-					exclude{exact("b")},
-					exclude{exact("e")},
-					pattern{regexp.MustCompile("^[cde]")},
+				f: []Filter{
+					Exact("a"),
+					Exact("b"),
+					Exclude{Exact("b")},
+					Exclude{Exact("e")},
+					Pattern{regexp.MustCompile("^[cde]")},
 				}},
 			want: []string{"a", "c", "d"}},
+		{name: "compound patterns",
+			args: args{
+				in: []string{"a1", "a2", "b1"},
+				f: []Filter{
+					&Compound{terms: []term{
+						{field: "name", Filter: Pattern{regexp.MustCompile("^a")}},
+						{field: "name", Filter: Pattern{regexp.MustCompile("2$")}},
+					}},
+					Exact("b1"),
+				}},
+			want: []string{"a2", "b1"}},
 	}
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
-			assert.Equalf(t, tt.want, matchContexts(tt.args.in, tt.args.f), "matchContexts(%v, %v)", tt.args.in, tt.args.f)
+			assert.Equalf(t, tt.want, MatchContexts(tt.args.in, tt.args.f), "MatchContexts(%v, %v)", tt.args.in, tt.args.f)
 		})
 	}
 }
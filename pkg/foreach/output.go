@@ -0,0 +1,299 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+	"unicode"
+)
+
+// lockedBuffer is a bytes.Buffer that's safe for concurrent use, e.g. for the
+// output of a context with Grouped.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Bytes()
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func (b *lockedBuffer) reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// captureBuffer stores the first limit bytes written to it, and is safe for
+// concurrent use, so that stdout and stderr of a command can share one.
+type captureBuffer struct {
+	limit int
+
+	mu        sync.Mutex
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (c *captureBuffer) Write(p []byte) (int, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(p)
+	if room := c.limit - c.buf.Len(); len(p) > room {
+		p = p[:room]
+		c.truncated = true
+	}
+	c.buf.Write(p)
+	return n, nil
+}
+
+// reset discards the captured output.
+func (c *captureBuffer) reset() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.buf.Reset()
+	c.truncated = false
+}
+
+// String returns the captured output, noting if it was truncated.
+func (c *captureBuffer) String() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.truncated {
+		return c.buf.String() + "\n[output truncated]\n"
+	}
+	return c.buf.String()
+}
+
+// timestampFormat is the layout of the time of each line with --timestamps.
+const timestampFormat = "15:04:05.000"
+
+type prefixingWriter struct {
+	prefix []byte
+	w      io.Writer           // has per-Write mutex
+	clock  func() time.Time    // if set, each line starts with the time its first byte was written
+	style  func(string) string // styles the time
+
+	buf bytes.Buffer
+}
+
+func (s *prefixingWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		if s.buf.Len() == 0 {
+			if s.clock != nil {
+				s.buf.WriteString(s.style(s.clock().Format(timestampFormat)) + " ")
+			}
+			s.buf.Write(s.prefix)
+		}
+
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			// keep the partial line until its end (or flush) is written
+			s.buf.Write(p)
+			break
+		}
+		s.buf.Write(p[:i+1])
+		if _, err := s.w.Write(s.buf.Bytes()); err != nil {
+			return 0, err
+		}
+		s.buf.Reset()
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush writes the buffered partial line, if any, with a newline, so that it
+// doesn't get mixed with the lines of other contexts, or with the messages
+// printed after the command exits.
+func (s *prefixingWriter) flush() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	s.buf.WriteByte('\n')
+	_, err := s.w.Write(s.buf.Bytes())
+	s.buf.Reset()
+	return err
+}
+
+// startAfterWriter discards the lines written to it until a line matches the
+// pattern, then writes that line and everything after it to w.
+type startAfterWriter struct {
+	pattern *regexp.Regexp
+	w       io.Writer
+
+	started bool
+	line    bytes.Buffer
+}
+
+func (s *startAfterWriter) Write(p []byte) (int, error) {
+	if s.started {
+		return s.w.Write(p)
+	}
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			s.line.Write(p)
+			break
+		}
+		s.line.Write(p[:i+1])
+		p = p[i+1:]
+		if !s.pattern.Match(bytes.TrimRight(s.line.Bytes(), "\r\n")) {
+			s.line.Reset()
+			continue
+		}
+		s.started = true
+		if _, err := s.w.Write(s.line.Bytes()); err != nil {
+			return 0, err
+		}
+		s.line.Reset()
+		if len(p) > 0 {
+			if _, err := s.w.Write(p); err != nil {
+				return 0, err
+			}
+		}
+		break
+	}
+	return n, nil
+}
+
+// controlFilter removes terminal control sequences, such as cursor movements
+// and screen clears, from what's written to it before writing it to w. Color
+// and style sequences (SGR, "ESC[...m"), newlines and tabs are kept.
+type controlFilter struct {
+	w io.Writer
+
+	state int
+	seq   []byte // CSI sequence being read
+}
+
+const (
+	ctrlText = iota
+	ctrlEscape
+	ctrlCSI
+	ctrlString    // OSC, DCS, etc. until BEL or ST
+	ctrlStringEsc // ESC in a control string, possibly the start of ST
+)
+
+func (c *controlFilter) Write(p []byte) (int, error) {
+	out := make([]byte, 0, len(p))
+	for _, b := range p {
+		switch c.state {
+		case ctrlText:
+			switch {
+			case b == 0x1b:
+				c.state = ctrlEscape
+			case b < 0x20 && b != '\n' && b != '\t', b == 0x7f:
+				// drop other C0 controls, e.g. \r and backspace
+			default:
+				out = append(out, b)
+			}
+		case ctrlEscape:
+			switch b {
+			case '[':
+				c.state = ctrlCSI
+				c.seq = append(c.seq[:0], 0x1b, '[')
+			case ']', 'P', 'X', '^', '_':
+				c.state = ctrlString
+			default:
+				c.state = ctrlText // two-byte sequence, e.g. ESC c (reset)
+			}
+		case ctrlCSI:
+			c.seq = append(c.seq, b)
+			if b >= 0x40 && b <= 0x7e {
+				if b == 'm' {
+					out = append(out, c.seq...)
+				}
+				c.state = ctrlText
+			}
+		case ctrlString:
+			if b == 0x07 {
+				c.state = ctrlText
+			} else if b == 0x1b {
+				c.state = ctrlStringEsc
+			}
+		case ctrlStringEsc:
+			if b == '\\' {
+				c.state = ctrlText
+			} else {
+				c.state = ctrlString
+			}
+		}
+	}
+	if _, err := c.w.Write(out); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// outputCounter counts the bytes and lines written to it.
+type outputCounter struct {
+	total    int64
+	nonSpace int64 // bytes other than whitespace
+	newlines int
+	last     byte
+}
+
+func (c *outputCounter) Write(p []byte) (int, error) {
+	c.total += int64(len(p))
+	for _, b := range p {
+		if !unicode.IsSpace(rune(b)) {
+			c.nonSpace++
+		}
+		if b == '\n' {
+			c.newlines++
+		}
+	}
+	if len(p) > 0 {
+		c.last = p[len(p)-1]
+	}
+	return len(p), nil
+}
+
+// lines returns the number of lines written, including an unterminated last
+// line.
+func (c *outputCounter) lines() int {
+	if c.total > 0 && c.last != '\n' {
+		return c.newlines + 1
+	}
+	return c.newlines
+}
+
+// empty reports whether nothing (or only whitespace, if whitespace is true)
+// was written.
+func (c *outputCounter) empty(whitespace bool) bool {
+	if whitespace {
+		return c.nonSpace == 0
+	}
+	return c.total == 0
+}
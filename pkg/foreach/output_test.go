@@ -0,0 +1,159 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"bytes"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_prefixingWriter(t *testing.T) {
+	var b bytes.Buffer
+	pw := &prefixingWriter{prefix: []byte{'p', ':', ' '}, w: &b}
+
+	// single line (no trailing newline)
+	n, err := pw.Write([]byte("hello"))
+	assert.Equal(t, 5, n)
+	assert.NoError(t, err)
+
+	// multi line (no trailing newline) - continuation to single line + new line
+	n, err = pw.Write([]byte("a\nb"))
+	assert.Equal(t, 3, n)
+	assert.NoError(t, err)
+	assert.Equal(t, `p: helloa
+`, b.String())
+
+	n, err = pw.Write([]byte("eof\n"))
+	assert.Equal(t, 4, n)
+	assert.NoError(t, err)
+
+	assert.Equal(t, `p: helloa
+p: beof
+`, // expected trailing newline
+		b.String())
+}
+
+func Test_startAfterWriter(t *testing.T) {
+	var b bytes.Buffer
+	sw := &startAfterWriter{pattern: regexp.MustCompile(`^NAME\s+READY`), w: &b}
+
+	for _, s := range []string{"Warning: x\n", "some NAME READY\nNA", "ME   READY  STATUS\r\npod-1  1/1", "  Running\n"} {
+		n, err := sw.Write([]byte(s))
+		assert.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	assert.Equal(t, "NAME   READY  STATUS\r\npod-1  1/1  Running\n", b.String())
+}
+
+func Test_startAfterWriter_noMatch(t *testing.T) {
+	var b bytes.Buffer
+	sw := &startAfterWriter{pattern: regexp.MustCompile(`^NAME`), w: &b}
+	_, err := sw.Write([]byte("a\nb\nNAME without newline"))
+	assert.NoError(t, err)
+	assert.Empty(t, b.String(), "contexts that never print the trigger line print nothing")
+}
+
+func Test_controlFilter(t *testing.T) {
+	var b bytes.Buffer
+	cf := &controlFilter{w: &b}
+	// split across writes in the middle of sequences
+	for _, s := range []string{
+		"\x1b[2J\x1b[H\x1b[31mred\x1b", "[0m\tok\r\n",
+		"50%\r\x1b[2K100%\n",
+		"\x1b]0;title\x07\x1b]8;;http://x\x1b\\link\x1b[1", ";32mbold\x1b[m\x1bc\n",
+	} {
+		n, err := cf.Write([]byte(s))
+		assert.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	assert.Equal(t, "\x1b[31mred\x1b[0m\tok\n50%100%\nlink\x1b[1;32mbold\x1b[m\n", b.String())
+}
+
+func Test_outputCounter(t *testing.T) {
+	var c outputCounter
+	assert.True(t, c.empty(false))
+	assert.True(t, c.empty(true))
+
+	c.Write([]byte(" \n\t"))
+	assert.False(t, c.empty(false))
+	assert.True(t, c.empty(true))
+
+	c.Write([]byte("No resources found\n"))
+	assert.False(t, c.empty(true))
+}
+
+func Test_outputCounter_lines(t *testing.T) {
+	var c outputCounter
+	assert.Equal(t, 0, c.lines())
+	c.Write([]byte("a\nb"))
+	assert.Equal(t, 2, c.lines())
+	c.Write([]byte("c\n"))
+	assert.Equal(t, 2, c.lines())
+	c.Write([]byte("\n"))
+	assert.Equal(t, 3, c.lines())
+}
+
+func Test_prefixingWriter_timestamps(t *testing.T) {
+	now := time.Date(2022, 5, 1, 10, 30, 0, 0, time.Local)
+	var b bytes.Buffer
+	pw := &prefixingWriter{prefix: []byte("a | "), w: &b, clock: func() time.Time { return now }, style: plain}
+
+	_, _ = pw.Write([]byte("one\ntw"))
+	now = now.Add(1500 * time.Millisecond)
+	_, _ = pw.Write([]byte("o\nthree\n")) // the time of a line is when it started
+	assert.Equal(t, "10:30:00.000 a | one\n10:30:00.000 a | two\n10:30:01.500 a | three\n", b.String())
+}
+
+func Test_prefixingWriter_chunked(t *testing.T) {
+	var b bytes.Buffer
+	pw := &prefixingWriter{prefix: []byte("p: "), w: &b}
+	for _, s := range []string{"foo", "bar\nbaz", "", "\n", "\nq", "ux"} {
+		n, err := pw.Write([]byte(s))
+		assert.NoError(t, err)
+		assert.Equal(t, len(s), n)
+	}
+	assert.Equal(t, "p: foobar\np: baz\np: \n", b.String(), "partial lines are buffered")
+
+	assert.NoError(t, pw.flush())
+	assert.Equal(t, "p: foobar\np: baz\np: \np: qux\n", b.String())
+	assert.NoError(t, pw.flush())
+	assert.Equal(t, "p: foobar\np: baz\np: \np: qux\n", b.String(), "nothing to flush")
+}
+
+func Test_captureBuffer(t *testing.T) {
+	c := &captureBuffer{limit: 5}
+	n, err := c.Write([]byte("abc"))
+	assert.NoError(t, err)
+	assert.Equal(t, 3, n)
+	assert.Equal(t, "abc", c.String())
+
+	n, err = c.Write([]byte("defg"))
+	assert.NoError(t, err)
+	assert.Equal(t, 4, n, "should report the full write")
+	assert.Equal(t, "abcde\n[output truncated]\n", c.String())
+
+	n, err = c.Write([]byte("h"))
+	assert.NoError(t, err)
+	assert.Equal(t, 1, n)
+	assert.Equal(t, "abcde\n[output truncated]\n", c.String())
+
+	c.reset()
+	_, _ = c.Write([]byte("ij"))
+	assert.Equal(t, "ij", c.String())
+}
@@ -0,0 +1,45 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+// succeeded reports whether err returned from running a command means it
+// succeeded: it's nil, or its exit code is in codes.
+func succeeded(err error, codes map[int]bool) bool {
+	if err == nil {
+		return true
+	}
+	var e interface{ ExitCode() int }
+	return errors.As(err, &e) && codes[e.ExitCode()]
+}
+
+// retryable reports whether a run of the command that returned err should be
+// tried again with Retries: only if kubectl exited with a failure, not if it
+// was killed by Timeout, failed to start or ctx was cancelled.
+func retryable(ctx context.Context, err error, timedOut bool, codes map[int]bool) bool {
+	var e interface{ ExitCode() int }
+	return !succeeded(err, codes) && !timedOut && ctx.Err() == nil && errors.As(err, &e)
+}
+
+// retryDelay returns the backoff before the retry after the given attempt,
+// which doubles after each attempt.
+func retryDelay(base time.Duration, attempt int) time.Duration {
+	return base << (attempt - 1)
+}
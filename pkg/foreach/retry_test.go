@@ -0,0 +1,60 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_succeeded(t *testing.T) {
+	exit0 := exec.Command("sh", "-c", "exit 0").Run()
+	exit1 := exec.Command("sh", "-c", "exit 1").Run()
+	startErr := exec.Command("kubectl-foreach-nonexistent-binary").Run()
+
+	assert.True(t, succeeded(exit0, nil))
+	assert.False(t, succeeded(exit1, nil))
+	assert.False(t, succeeded(startErr, nil))
+
+	codes := map[int]bool{1: true}
+	assert.True(t, succeeded(exit1, codes))
+	assert.True(t, succeeded(fmt.Errorf("wrapped: %w", exit1), codes))
+	assert.False(t, succeeded(startErr, codes))
+}
+
+func Test_retryable(t *testing.T) {
+	exit1 := exec.Command("sh", "-c", "exit 1").Run()
+	ctx, cancel := context.WithCancel(context.Background())
+	assert.True(t, retryable(ctx, exit1, false, nil))
+	assert.False(t, retryable(ctx, exit1, false, map[int]bool{1: true}), "in the success codes")
+	assert.False(t, retryable(ctx, nil, false, nil))
+	assert.False(t, retryable(ctx, exit1, true, nil), "timed out")
+	assert.False(t, retryable(ctx, errors.New("exec: not found"), false, nil), "didn't start")
+	cancel()
+	assert.False(t, retryable(ctx, exit1, false, nil), "cancelled")
+}
+
+func Test_retryDelay(t *testing.T) {
+	assert.Equal(t, time.Second, retryDelay(time.Second, 1))
+	assert.Equal(t, 2*time.Second, retryDelay(time.Second, 2))
+	assert.Equal(t, 4*time.Second, retryDelay(time.Second, 3))
+	assert.Equal(t, time.Duration(0), retryDelay(0, 3))
+}
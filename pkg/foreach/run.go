@@ -0,0 +1,629 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/rand"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// Options configure Run. The zero value of each option, other than Contexts
+// and Args, leaves out what it adds.
+type Options struct {
+	// Contexts are the contexts to run the command in, e.g. as returned from
+	// MatchContexts.
+	Contexts []string
+	// Args are the arguments of kubectl. The context is passed with --context,
+	// unless Replace is set or Args have ContextPlaceholder.
+	Args []string
+	// Replace are the tokens replaced with the context name in Args instead
+	// of passing --context, like -I of kubectl-foreach.
+	Replace []string
+	// ArgsFunc returns the arguments of kubectl in each context instead of
+	// Args and Replace, if not nil.
+	ArgsFunc func(kctx string) []string
+	// Verify returns the arguments of kubectl to run in a context after the
+	// command succeeded in it, if not nil. The context fails if it fails.
+	Verify func(kctx string) []string
+	// Stdin returns the input of kubectl in each context, if not nil. The
+	// command gets all of it again on each retry, and Verify gets none.
+	Stdin func(kctx string) []byte
+	// Kubectl is the kubectl binary to run (default: kubectl).
+	Kubectl string
+	// Runner runs the commands instead of a KubectlRunner with Kubectl and
+	// InterruptGrace, if not nil.
+	Runner Runner
+	// InterruptGrace is how long to wait for the commands to exit after
+	// interrupting them when the context passed to Run is done, before
+	// killing them (0: kill right away). See Wait.
+	InterruptGrace time.Duration
+
+	// Concurrency is the number of contexts to run the command in at a time
+	// (0: all at once).
+	Concurrency int
+	// Semaphore limits the contexts the command runs in at a time instead of
+	// Concurrency, if not nil, e.g. to change the limit while it runs.
+	Semaphore *Semaphore
+	// Acquire, if not nil, waits until the command can run in kctx (e.g. for
+	// a slot of its API server) before the context takes one of Concurrency,
+	// and returns a function that gives the slot back. It must return an
+	// error once ctx is done.
+	Acquire func(ctx context.Context, kctx string) (release func(), err error)
+	// Ramp is how long to wait between starting the command in successive
+	// contexts.
+	Ramp time.Duration
+	// StartJitter is the max random delay before the command starts in each
+	// context.
+	StartJitter time.Duration
+	// Drain, if not nil, stops starting the command in more contexts once it's
+	// done, which are skipped with ErrSkipped. The running commands are only
+	// cancelled once the context passed to Run is done.
+	Drain context.Context
+
+	// Timeout kills the command (and Verify) after running that long, and
+	// fails the context with ErrTimeout (0: no timeout).
+	Timeout time.Duration
+	// Retries is how many times to run the command again in a context after
+	// kubectl exited with a failure, waiting RetryBackoff before the first
+	// retry and twice as long before each next one.
+	Retries      int
+	RetryBackoff time.Duration
+	// SuccessCodes are exit codes of kubectl that mean it succeeded, in
+	// addition to 0.
+	SuccessCodes []int
+	// MaxFailures cancels the run once the command failed in that many
+	// contexts (0: no limit): the running commands are cancelled, and the
+	// contexts that haven't started are skipped with ErrMaxFailures.
+	MaxFailures int
+	// FailOnEmpty fails the contexts in which the command succeeded without
+	// printing anything to stdout (or only whitespace, with EmptyWhitespace)
+	// with ErrEmptyOutput.
+	FailOnEmpty     bool
+	EmptyWhitespace bool
+	// SkipUnreachable doesn't count the contexts whose API server could not
+	// be reached as failed: their error is ErrUnreachable, and they are not
+	// in the Failures returned from Run.
+	SkipUnreachable bool
+	// FailurePatterns classify the failures by the stderr of kubectl in
+	// Result.Reason, if not nil (e.g. DefaultFailurePatterns).
+	FailurePatterns []FailurePattern
+	// Breaker skips contexts with ErrSkipped, if not nil.
+	Breaker Breaker
+
+	// Stdout and Stderr print the output of the commands, with each line
+	// written whole, starting with the Prefix of its context. Lines of
+	// several contexts can be written at the same time. Nil doesn't print it.
+	Stdout, Stderr io.Writer
+	// Prefix returns the start of the printed lines of the ith context, kctx,
+	// if not nil.
+	Prefix func(i int, kctx string) string
+	// Timestamps starts each printed line with the time it was printed.
+	Timestamps bool
+	// Grouped prints the output of each context as one block once it
+	// finished, in the order of Contexts with KeepOrder.
+	Grouped   bool
+	KeepOrder bool
+	// StartAfter discards the lines of stdout of each context until one
+	// matches it, if not nil.
+	StartAfter *regexp.Regexp
+	// SanitizeControl removes terminal control sequences other than colors
+	// and styles from the output.
+	SanitizeControl bool
+	// NoteStyle styles the notes Run writes to the stderr of contexts (e.g.
+	// "skipped: shutting down") and the times of Timestamps, if not nil.
+	NoteStyle func(string) string
+	// Print, if not nil, returns the writers the output of the ith context,
+	// kctx, is printed to instead of Stdout and Stderr.
+	Print func(i int, kctx string) (stdout, stderr io.Writer)
+	// Output, if not nil, is called before the command runs in the ith
+	// context, kctx, with the writers that print its output. It returns the
+	// writers to write the output to instead (e.g. to also write it to a
+	// file), and a function called with the result once the context
+	// finished, if not nil. If it fails, the context fails with its error.
+	Output func(i int, kctx string, stdout, stderr io.Writer) (io.Writer, io.Writer, func(Result), error)
+	// OnResult is called with the result of each context once it finished,
+	// if not nil, e.g. to stream the results.
+	OnResult func(i int, r Result)
+
+	// Capture is the output of the commands kept in the results.
+	Capture Capture
+	// OutputLimit is the max bytes of Result.Output kept with CaptureOutput
+	// (0: all).
+	OutputLimit int
+	// Hash sets Result.Hash to the sha256 of stdout of each context, with the
+	// matches of HashIgnore removed from each line first.
+	Hash       bool
+	HashIgnore []*regexp.Regexp
+}
+
+// Capture is a set of the outputs of the commands kept in Result.
+type Capture int
+
+const (
+	CaptureStdout Capture = 1 << iota // stdout in Result.Stdout
+	CaptureStderr                     // stderr in Result.Stderr
+	CaptureOutput                     // stdout and stderr as printed in Result.Output
+)
+
+// Breaker skips contexts in which the command is likely to fail, e.g. after it
+// failed too many times on their API server.
+type Breaker interface {
+	// Open returns why the command must not run in kctx, or "" to run it.
+	Open(kctx string) string
+	// Record records whether the command succeeded in kctx (before Verify and
+	// FailOnEmpty), once it ran.
+	Record(kctx string, ok bool)
+}
+
+// Result is the result of the command in a context.
+type Result struct {
+	Context string
+	// ExitCode is the exit code of the last run of kubectl (or of Verify, if
+	// it failed), also if it's in SuccessCodes, or -1 if it didn't exit on
+	// its own (e.g. it couldn't start, or was skipped or killed, or the error
+	// of the Runner has no exit code).
+	ExitCode int
+	// Stdout, Stderr and Output are the output of the last run of kubectl
+	// and of Verify, if kept with Capture. Stderr and Output have the notes
+	// of Run too.
+	Stdout []byte
+	Stderr []byte
+	Output []byte
+	// Err is not nil if the command failed (or was skipped or cancelled) in the context.
+	Err error
+	// Reason is the reason of the failure, with FailurePatterns.
+	Reason string
+	// Hash is the sha256 of stdout, with Hash, if the command ran.
+	Hash string
+	// Duration is how long the command (and Verify) ran, with the retries.
+	Duration time.Duration
+	// Attempts is how many times the command ran, more than once with
+	// Retries.
+	Attempts int
+	// OutputLines is the number of lines of stdout.
+	OutputLines int
+}
+
+// Failures is the error returned from Run with the results of the contexts in
+// which the command failed. kubectl-foreach returns the same error.
+type Failures []Result
+
+func (f Failures) Error() string {
+	if len(f) == 1 {
+		return f[0].Err.Error()
+	}
+	out := make([]string, len(f))
+	for i, r := range f {
+		detail := fmt.Sprintf("exit code %d", r.ExitCode)
+		if r.ExitCode <= 0 {
+			detail = r.Err.Error()
+			if u := errors.Unwrap(r.Err); u != nil {
+				detail = u.Error()
+			}
+		}
+		out[i] = fmt.Sprintf("%s (%s)", r.Context, detail)
+	}
+	return fmt.Sprintf("command failed in %d contexts: %s", len(f), strings.Join(out, ", "))
+}
+
+// Is reports whether the error of any failed context is target.
+func (f Failures) Is(target error) bool {
+	for _, r := range f {
+		if errors.Is(r.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// As finds the first error of a failed context that matches target.
+func (f Failures) As(target interface{}) bool {
+	for _, r := range f {
+		if errors.As(r.Err, target) {
+			return true
+		}
+	}
+	return false
+}
+
+// Run runs kubectl with the arguments of each context in opts.Contexts, even
+// if it fails in some, and returns their results in the same order. If the
+// command fails in any context, the error is a Failures. Cancelling ctx
+// cancels the running commands, and Run returns after they have exited.
+func Run(ctx context.Context, opts Options) ([]Result, error) {
+	if len(opts.Args) == 0 && opts.ArgsFunc == nil {
+		return nil, errors.New("no kubectl arguments")
+	}
+	if opts.Concurrency < 0 {
+		return nil, errors.New("negative concurrency")
+	}
+	s := &run{opts: opts, runner: opts.Runner, argMaker: opts.ArgsFunc, style: opts.NoteStyle,
+		codes: make(map[int]bool, len(opts.SuccessCodes)), finished: make([]func(), len(opts.Contexts))}
+	if s.runner == nil {
+		s.runner = KubectlRunner{Path: opts.Kubectl, InterruptGrace: opts.InterruptGrace}
+	}
+	if s.argMaker == nil {
+		s.argMaker = ReplaceArgs(opts.Args, opts.Replace...)
+	}
+	if s.style == nil {
+		s.style = plain
+	}
+	for _, c := range opts.SuccessCodes {
+		s.codes[c] = true
+	}
+	if opts.Timestamps {
+		s.clock = time.Now
+	}
+
+	if opts.MaxFailures > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithCancel(ctx)
+		defer cancel()
+		s.limit = &failureLimit{max: opts.MaxFailures, cancel: cancel}
+	}
+	var wg errgroup.Group
+	// With Acquire, each context waits for it before taking a slot of
+	// Concurrency (or Semaphore), so that the contexts waiting in Acquire
+	// don't keep the others from running.
+	s.sem, s.slotFirst = opts.Semaphore, opts.Acquire == nil
+	if s.sem == nil && !s.slotFirst {
+		s.sem = NewSemaphore(opts.Concurrency)
+	}
+	if s.sem == nil && opts.Concurrency > 0 {
+		wg.SetLimit(opts.Concurrency)
+	}
+	s.delays = startDelays(len(opts.Contexts), opts.StartJitter, rand.New(rand.NewSource(time.Now().UnixNano())))
+	var stopStart context.CancelFunc
+	s.startCtx, stopStart = context.WithCancel(ctx) // done once no more contexts should start
+	defer stopStart()
+	if opts.Drain != nil {
+		go func() {
+			select {
+			case <-opts.Drain.Done():
+				stopStart()
+			case <-s.startCtx.Done():
+			}
+		}()
+	}
+
+	results := make([]Result, len(opts.Contexts))
+	for i, kctx := range opts.Contexts {
+		i := i
+		res := &results[i]
+		res.Context, res.ExitCode = kctx, -1
+		if i > 0 && opts.Ramp > 0 && s.startCtx.Err() == nil {
+			sleepCtx(s.startCtx, opts.Ramp)
+		}
+		if s.sem != nil && s.slotFirst {
+			s.sem.acquire()
+		}
+		wg.Go(func() error {
+			if s.sem != nil && s.slotFirst {
+				defer s.sem.release()
+			}
+			if opts.OnResult != nil {
+				defer func() { opts.OnResult(i, *res) }()
+			}
+			s.context(ctx, i, res)
+			return nil
+		})
+	}
+	_ = wg.Wait() // the errors are in the results
+	var failed Failures
+	for _, r := range results {
+		if r.Err != nil && !errors.Is(r.Err, ErrUnreachable) {
+			failed = append(failed, r)
+		}
+	}
+	if len(failed) > 0 {
+		return results, failed
+	}
+	return results, nil
+}
+
+// run is the state of a Run shared by the contexts.
+type run struct {
+	opts      Options
+	runner    Runner
+	argMaker  func(kctx string) []string
+	style     func(string) string
+	codes     map[int]bool // SuccessCodes
+	clock     func() time.Time
+	limit     *failureLimit
+	sem       *Semaphore
+	slotFirst bool // the slot of sem is taken before starting the context
+	delays    []time.Duration
+	startCtx  context.Context // done once no more contexts should start
+
+	flushMu   sync.Mutex // so that grouped output of contexts isn't interleaved
+	finished  []func()   // flushes output of contexts with KeepOrder
+	nextFlush int        // first context whose output isn't flushed with KeepOrder
+}
+
+// note writes a message of Run to the stderr of a context.
+func (s *run) note(w io.Writer, msg string) {
+	fmt.Fprintln(w, s.style(msg))
+}
+
+// context runs the command in the ith context, and sets its result in res.
+func (s *run) context(ctx context.Context, i int, res *Result) {
+	opts, kctx := s.opts, res.Context
+	stdout, stderr := opts.Stdout, opts.Stderr
+	if opts.Print != nil {
+		stdout, stderr = opts.Print(i, kctx)
+	}
+	if stdout == nil {
+		stdout = io.Discard
+	}
+	if stderr == nil {
+		stderr = io.Discard
+	}
+	if opts.Grouped {
+		out, errOut := stdout, stderr
+		outBuf, errBuf := new(lockedBuffer), new(lockedBuffer)
+		stdout, stderr = outBuf, errBuf
+		defer s.flushGrouped(i, func() {
+			_, _ = errOut.Write(errBuf.Bytes())
+			_, _ = out.Write(outBuf.Bytes())
+		})
+	}
+	var prefix []byte
+	if opts.Prefix != nil {
+		prefix = []byte(opts.Prefix(i, kctx))
+	}
+	pwo := &prefixingWriter{prefix: prefix, w: stdout, clock: s.clock, style: s.style}
+	pwe := &prefixingWriter{prefix: prefix, w: stderr, clock: s.clock, style: s.style}
+	flushLines := func() { _, _ = pwo.flush(), pwe.flush() }
+	defer flushLines()
+	var wo, we io.Writer = pwo, pwe
+	if opts.Output != nil {
+		o, e, done, err := opts.Output(i, kctx, wo, we)
+		if err != nil {
+			res.Err = fmt.Errorf("context %q: %w", kctx, err)
+			return
+		}
+		wo, we = o, e
+		if done != nil {
+			defer func() { done(*res) }()
+		}
+	}
+	var output *captureBuffer
+	if opts.Capture&CaptureOutput != 0 {
+		output = &captureBuffer{limit: opts.OutputLimit}
+		if output.limit <= 0 {
+			output.limit = math.MaxInt
+		}
+		wo, we = io.MultiWriter(wo, output), io.MultiWriter(we, output)
+	}
+	var outBuf *bytes.Buffer
+	if opts.Capture&CaptureStdout != 0 {
+		outBuf = new(bytes.Buffer)
+		wo = io.MultiWriter(wo, outBuf)
+	}
+	var hasher *outputHasher
+	if opts.Hash {
+		hasher = newOutputHasher(opts.HashIgnore)
+		wo = io.MultiWriter(wo, hasher)
+	}
+	var printed outputCounter
+	wo = io.MultiWriter(wo, &printed)
+	if opts.StartAfter != nil {
+		wo = &startAfterWriter{pattern: opts.StartAfter, w: wo}
+	}
+	var errBuf *lockedBuffer // also to classify failures and find unreachable servers
+	if opts.Capture&CaptureStderr != 0 || opts.SkipUnreachable || opts.FailurePatterns != nil {
+		errBuf = new(lockedBuffer)
+		we = io.MultiWriter(we, errBuf)
+	}
+	if opts.SanitizeControl {
+		wo, we = &controlFilter{w: wo}, &controlFilter{w: we}
+	}
+	defer func() {
+		if outBuf != nil {
+			res.Stdout = outBuf.Bytes()
+		}
+		if errBuf != nil && opts.Capture&CaptureStderr != 0 {
+			res.Stderr = errBuf.Bytes()
+		}
+		if output != nil {
+			res.Output = []byte(output.String())
+		}
+	}()
+
+	if opts.Breaker != nil {
+		if why := opts.Breaker.Open(kctx); why != "" {
+			s.note(we, "skipped: "+why)
+			res.Err = fmt.Errorf("context %q: %w", kctx, ErrSkipped)
+			return
+		}
+	}
+	var waitErr error // of waiting for Acquire and the slot of Concurrency
+	if !s.slotFirst {
+		release, err := opts.Acquire(s.startCtx, kctx)
+		if err == nil {
+			defer release()
+			if err = s.sem.acquireContext(s.startCtx); err == nil {
+				defer s.sem.release()
+			}
+		}
+		waitErr = err
+	}
+	if s.delays != nil {
+		sleepCtx(ctx, s.delays[i])
+	}
+	if opts.Drain != nil && opts.Drain.Err() != nil {
+		s.note(we, "skipped: shutting down")
+		res.Err = fmt.Errorf("context %q: %w", kctx, ErrSkipped)
+		return
+	}
+	if s.limit.reached() {
+		s.note(we, fmt.Sprintf("skipped: %d context(s) failed", opts.MaxFailures))
+		res.Err = fmt.Errorf("context %q: %w", kctx, ErrMaxFailures)
+		return
+	}
+	if waitErr != nil {
+		res.Err = fmt.Errorf("context %q: %w", kctx, waitErr)
+		return
+	}
+	if ctx.Err() != nil {
+		res.Err = fmt.Errorf("context %q: %w", kctx, ctx.Err())
+		return
+	}
+	defer func() {
+		if failed(res.Err) {
+			s.limit.record()
+		}
+	}()
+	args := s.argMaker(kctx)
+	var stdin []byte // not used for Verify, which doesn't get the stdin
+	if opts.Stdin != nil {
+		stdin = opts.Stdin(kctx)
+	}
+	start := time.Now()
+	timedOut, err := s.command(ctx, args, stdinReader(stdin), wo, we)
+	flushLines()
+	for res.Attempts = 1; res.Attempts <= opts.Retries && retryable(ctx, err, timedOut, s.codes); res.Attempts++ {
+		d := retryDelay(opts.RetryBackoff, res.Attempts)
+		s.note(we, fmt.Sprintf("failed (%v), retrying in %v (attempt %d of %d)", err, d, res.Attempts+1, opts.Retries+1))
+		if sleepCtx(ctx, d); ctx.Err() != nil {
+			break
+		}
+		// only the output of the last attempt is counted, hashed and kept
+		printed = outputCounter{}
+		if hasher != nil {
+			hasher.reset()
+		}
+		if outBuf != nil {
+			outBuf.Reset()
+		}
+		if errBuf != nil {
+			errBuf.reset()
+		}
+		if output != nil {
+			output.reset()
+		}
+		timedOut, err = s.command(ctx, args, stdinReader(stdin), wo, we)
+		flushLines()
+	}
+	res.Duration = time.Since(start)
+	res.OutputLines = printed.lines()
+	res.ExitCode = exitCode(err)
+	if hasher != nil {
+		defer func() { res.Hash = hasher.sum() }() // after Verify, which writes to the same stdout
+	}
+	ok := succeeded(err, s.codes)
+	if !ok && s.limit.reached() && ctx.Err() != nil {
+		s.note(we, fmt.Sprintf("cancelled: %d context(s) failed", opts.MaxFailures))
+	}
+	if opts.Breaker != nil {
+		opts.Breaker.Record(kctx, ok)
+	}
+	if timedOut {
+		res.Err = fmt.Errorf("command in context %q: %w after %v", kctx, ErrTimeout, opts.Timeout)
+		if opts.FailurePatterns != nil {
+			res.Reason = ReasonTimeout
+		}
+		s.note(we, fmt.Sprintf("killed: timed out after %v", opts.Timeout))
+		return
+	}
+	if !ok && opts.SkipUnreachable && isUnreachable(errBuf.String()) {
+		res.Err = fmt.Errorf("context %q: %w (%v)", kctx, ErrUnreachable, err)
+		s.note(we, "unreachable: not counted as a failure")
+		return
+	}
+	if !ok {
+		res.Err = fmt.Errorf("command failed in context %q: %w", kctx, err)
+		if opts.FailurePatterns != nil {
+			res.Reason = classifyFailure(errBuf.String(), opts.FailurePatterns)
+		}
+		return
+	}
+	if opts.FailOnEmpty && printed.empty(opts.EmptyWhitespace) {
+		res.Err = fmt.Errorf("command printed nothing in context %q: %w", kctx, ErrEmptyOutput)
+		s.note(we, "failed: empty output")
+		return
+	}
+	if opts.Verify != nil {
+		timedOut, err := s.command(ctx, opts.Verify(kctx), nil, wo, we)
+		flushLines()
+		res.Duration = time.Since(start)
+		if err != nil {
+			res.ExitCode = exitCode(err)
+		}
+		if timedOut {
+			res.Err = fmt.Errorf("verification in context %q: %w after %v", kctx, ErrTimeout, opts.Timeout)
+			if opts.FailurePatterns != nil {
+				res.Reason = ReasonTimeout
+			}
+			return
+		}
+		if err != nil {
+			res.Err = fmt.Errorf("verification failed in context %q: %w", kctx, err)
+			if opts.FailurePatterns != nil {
+				res.Reason = classifyFailure(errBuf.String(), opts.FailurePatterns)
+			}
+		}
+	}
+}
+
+// command runs kubectl with the Runner, and kills it if it runs longer than
+// Timeout, in which case it reports that the command timed out.
+func (s *run) command(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) (bool, error) {
+	if s.opts.Timeout <= 0 {
+		return false, s.runner.Run(ctx, args, stdin, stdout, stderr)
+	}
+	tctx, cancel := context.WithTimeout(ctx, s.opts.Timeout)
+	defer cancel()
+	err := s.runner.Run(tctx, args, stdin, stdout, stderr)
+	return err != nil && ctx.Err() == nil && errors.Is(tctx.Err(), context.DeadlineExceeded), err
+}
+
+// flushGrouped prints the output of the ith context with Grouped, once the
+// ones before it are printed with KeepOrder.
+func (s *run) flushGrouped(i int, flush func()) {
+	s.flushMu.Lock()
+	defer s.flushMu.Unlock()
+	if !s.opts.KeepOrder {
+		flush()
+		return
+	}
+	s.finished[i] = flush
+	for ; s.nextFlush < len(s.finished) && s.finished[s.nextFlush] != nil; s.nextFlush++ {
+		s.finished[s.nextFlush]()
+		s.finished[s.nextFlush] = nil
+	}
+}
+
+// stdinReader returns a new reader of the stdin b of kubectl, or nil if b is
+// nil, so that each retry of the command gets the whole input again.
+func stdinReader(b []byte) io.Reader {
+	if b == nil {
+		return nil
+	}
+	return bytes.NewReader(b)
+}
+
+func plain(s string) string { return s }
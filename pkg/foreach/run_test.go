@@ -0,0 +1,200 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeKubectl writes a shell script that runs body as kubectl, and returns its
+// path.
+func fakeKubectl(t *testing.T, body string) string {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	p := filepath.Join(t.TempDir(), "kubectl")
+	require.NoError(t, os.WriteFile(p, []byte("#!/bin/sh\n"+body+"\n"), 0o755))
+	return p
+}
+
+func TestRun(t *testing.T) {
+	kubectl := fakeKubectl(t, `case "$1" in
+	--context=b) echo "error: b is down" >&2; exit 3;;
+	esac
+	echo "$1 $2"`)
+
+	results, err := Run(context.Background(), Options{
+		Contexts: []string{"a", "b", "c"},
+		Args:     []string{"get"},
+		Kubectl:  kubectl,
+		Capture:  CaptureStdout | CaptureStderr,
+	})
+	require.Error(t, err)
+	assert.EqualError(t, err, `command failed in context "b": exit status 3`)
+	var failed Failures
+	require.ErrorAs(t, err, &failed)
+	assert.Len(t, failed, 1)
+
+	require.Len(t, results, 3)
+	assert.Equal(t, "a", results[0].Context)
+	assert.Equal(t, 0, results[0].ExitCode)
+	assert.Equal(t, "--context=a get\n", string(results[0].Stdout))
+	assert.Empty(t, results[0].Stderr)
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 1, results[0].Attempts)
+	assert.Equal(t, 1, results[0].OutputLines)
+	assert.Equal(t, "b", results[1].Context)
+	assert.Equal(t, 3, results[1].ExitCode)
+	assert.Equal(t, "error: b is down\n", string(results[1].Stderr))
+	assert.Error(t, results[1].Err)
+	assert.Equal(t, "--context=c get\n", string(results[2].Stdout))
+	assert.NoError(t, results[2].Err)
+}
+
+func TestFailures(t *testing.T) {
+	errBoom := errors.New("boom")
+	f := Failures{
+		{Context: "a", ExitCode: 3, Err: fmt.Errorf("command failed in context %q: %w", "a", errors.New("exit status 3"))},
+		{Context: "b", ExitCode: -1, Err: fmt.Errorf("context %q: %w", "b", context.Canceled)},
+		{Context: "c", ExitCode: 0, Err: errBoom},
+	}
+	assert.EqualError(t, f, "command failed in 3 contexts: a (exit code 3), b (context canceled), c (boom)")
+	assert.ErrorIs(t, f, context.Canceled)
+	assert.ErrorIs(t, f, errBoom)
+	assert.NotErrorIs(t, f, context.DeadlineExceeded)
+	assert.EqualError(t, f[:1], `command failed in context "a": exit status 3`)
+}
+
+func TestRun_replace(t *testing.T) {
+	kubectl := fakeKubectl(t, `echo "$@"`)
+	results, err := Run(context.Background(), Options{
+		Contexts: []string{"a", "b"},
+		Args:     []string{"--context={}", "get", "cm", "{}-config"},
+		Replace:  []string{"{}"},
+		Kubectl:  kubectl,
+		Capture:  CaptureStdout,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "--context=a get cm a-config\n", string(results[0].Stdout))
+	assert.Equal(t, "--context=b get cm b-config\n", string(results[1].Stdout))
+}
+
+func TestRun_concurrency(t *testing.T) {
+	dir := t.TempDir()
+	// each command fails if another one is running
+	kubectl := fakeKubectl(t, `mkdir "`+dir+`/lock" || exit 1; sleep 0.1; rmdir "`+dir+`/lock"`)
+	_, err := Run(context.Background(), Options{
+		Contexts:    []string{"a", "b", "c"},
+		Args:        []string{"get"},
+		Kubectl:     kubectl,
+		Concurrency: 1,
+	})
+	assert.NoError(t, err)
+}
+
+func TestRun_cancel(t *testing.T) {
+	kubectl := fakeKubectl(t, `trap 'echo stopped; exit 0' INT
+	sleep 10 >/dev/null 2>&1 &
+	wait $!`)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(300*time.Millisecond, cancel)
+	start := time.Now()
+	results, err := Run(ctx, Options{
+		Contexts:       []string{"a", "b"},
+		Args:           []string{"port-forward", "svc/web", "8080"},
+		Kubectl:        kubectl,
+		InterruptGrace: 5 * time.Second,
+		Capture:        CaptureStdout,
+	})
+	assert.Error(t, err)
+	assert.Less(t, time.Since(start), 4*time.Second)
+	for _, r := range results {
+		assert.ErrorIs(t, r.Err, context.Canceled)
+		assert.Equal(t, "stopped\n", string(r.Stdout))
+	}
+
+	results, err = Run(ctx, Options{Contexts: []string{"a"}, Args: []string{"get"}, Kubectl: kubectl})
+	assert.Error(t, err, "not started once cancelled")
+	assert.Equal(t, -1, results[0].ExitCode)
+}
+
+func TestRun_output(t *testing.T) {
+	kubectl := fakeKubectl(t, `echo one; sleep 0.1; echo two`)
+	var out bytes.Buffer
+	_, err := Run(context.Background(), Options{
+		Contexts:  []string{"a", "b"},
+		Args:      []string{"get"},
+		Kubectl:   kubectl,
+		Stdout:    &out,
+		Prefix:    func(_ int, kctx string) string { return kctx + " | " },
+		Grouped:   true,
+		KeepOrder: true,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, "a | one\na | two\nb | one\nb | two\n", out.String())
+}
+
+func TestRun_retries(t *testing.T) {
+	dir := t.TempDir()
+	// fails the first time in each context
+	kubectl := fakeKubectl(t, `f="`+dir+`/${1#--context=}"
+	[ -e "$f" ] || { touch "$f"; echo "flaky" >&2; exit 1; }
+	echo ok`)
+	var errOut bytes.Buffer
+	results, err := Run(context.Background(), Options{
+		Contexts: []string{"a"},
+		Args:     []string{"get"},
+		Kubectl:  kubectl,
+		Stderr:   &errOut,
+		Retries:  1,
+		Capture:  CaptureStdout | CaptureStderr,
+	})
+	require.NoError(t, err)
+	assert.Equal(t, 2, results[0].Attempts)
+	assert.Equal(t, "ok\n", string(results[0].Stdout))
+	assert.Empty(t, results[0].Stderr, "stderr of the failed attempt is discarded")
+	assert.Contains(t, errOut.String(), "retrying in")
+}
+
+func TestRun_invalidOptions(t *testing.T) {
+	_, err := Run(context.Background(), Options{Contexts: []string{"a"}})
+	assert.Error(t, err)
+	_, err = Run(context.Background(), Options{Contexts: []string{"a"}, Args: []string{"get"}, Concurrency: -1})
+	assert.Error(t, err)
+}
+
+func Test_stdinReader(t *testing.T) {
+	assert.Nil(t, stdinReader(nil))
+
+	in := []byte("kind: Pod\n")
+	for i := 0; i < 2; i++ {
+		b, err := io.ReadAll(stdinReader(in))
+		require.NoError(t, err)
+		assert.Equal(t, "kind: Pod\n", string(b))
+	}
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// Runner runs kubectl with args, reading its input from stdin (none if nil)
+// and writing its output to stdout and stderr. It must return when ctx is
+// done. Errors with an ExitCode() int method, like
+// *exec.ExitError, are reported with that exit code in a Result.
+type Runner interface {
+	Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error
+}
+
+// RunnerFunc is a function that implements Runner, e.g. to fake kubectl in
+// tests.
+type RunnerFunc func(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error
+
+func (f RunnerFunc) Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	return f(ctx, args, stdin, stdout, stderr)
+}
+
+// KubectlRunner is the Runner that runs the kubectl binary.
+type KubectlRunner struct {
+	// Path is the kubectl binary to run (default: kubectl).
+	Path string
+	// InterruptGrace is how long to wait for kubectl to exit after
+	// interrupting it when ctx is done, before killing it (0: kill right
+	// away). See Wait.
+	InterruptGrace time.Duration
+}
+
+func (k KubectlRunner) Run(ctx context.Context, args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	path := k.Path
+	if path == "" {
+		path = "kubectl"
+	}
+	cmd := exec.Command(path, args...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = stdin, stdout, stderr
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	return Wait(ctx, cmd, k.InterruptGrace)
+}
+
+// exitCode returns the exit code of a command that returned err, or -1 if err
+// doesn't have one.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var e interface{ ExitCode() int }
+	if errors.As(err, &e) {
+		return e.ExitCode()
+	}
+	return -1
+}
+
+// Contexts returns the names of the contexts in kubeconfig, by running
+// "kubectl config get-contexts -o=name" with r.
+func Contexts(ctx context.Context, r Runner, stderr io.Writer) ([]string, error) {
+	var b bytes.Buffer
+	if err := r.Run(ctx, []string{"config", "get-contexts", "-o=name"}, nil, &b, stderr); err != nil {
+		return nil, fmt.Errorf("failed to get contexts: %w", err)
+	}
+	return strings.Split(strings.TrimSpace(b.String()), "\n"), nil
+}
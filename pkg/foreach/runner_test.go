@@ -0,0 +1,91 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// exitError is an error of a fake command with an exit code.
+type exitError int
+
+func (e exitError) Error() string { return fmt.Sprintf("exit status %d", int(e)) }
+func (e exitError) ExitCode() int { return int(e) }
+
+func TestRun_runner(t *testing.T) {
+	var mu sync.Mutex
+	argv := make(map[string][]string)
+	r := RunnerFunc(func(_ context.Context, args []string, _ io.Reader, stdout, stderr io.Writer) error {
+		ctx := strings.TrimPrefix(args[0], "--context=")
+		mu.Lock()
+		argv[ctx] = args
+		mu.Unlock()
+		switch ctx {
+		case "b":
+			fmt.Fprintln(stderr, "error: forbidden")
+			return exitError(1)
+		case "c":
+			return errors.New("no such host")
+		}
+		fmt.Fprintln(stdout, "pod/web")
+		return nil
+	})
+
+	results, err := Run(context.Background(), Options{
+		Contexts: []string{"a", "b", "c"},
+		Args:     []string{"get", "pods", "-o", "name"},
+		Runner:   r,
+		Capture:  CaptureStdout | CaptureStderr,
+	})
+	assert.EqualError(t, err, "command failed in 2 contexts: b (exit code 1), c (no such host)")
+	assert.Equal(t, map[string][]string{
+		"a": {"--context=a", "get", "pods", "-o", "name"},
+		"b": {"--context=b", "get", "pods", "-o", "name"},
+		"c": {"--context=c", "get", "pods", "-o", "name"},
+	}, argv)
+	require.Len(t, results, 3)
+	assert.Equal(t, "pod/web\n", string(results[0].Stdout))
+	assert.NoError(t, results[0].Err)
+	assert.Equal(t, 1, results[1].ExitCode)
+	assert.Equal(t, "error: forbidden\n", string(results[1].Stderr))
+	assert.Equal(t, -1, results[2].ExitCode)
+}
+
+func TestContexts(t *testing.T) {
+	var got []string
+	r := RunnerFunc(func(_ context.Context, args []string, _ io.Reader, stdout, _ io.Writer) error {
+		got = args
+		_, err := io.WriteString(stdout, "a\nb\n")
+		return err
+	})
+	ctxs, err := Contexts(context.Background(), r, io.Discard)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, ctxs)
+	assert.Equal(t, []string{"config", "get-contexts", "-o=name"}, got)
+
+	_, err = Contexts(context.Background(), RunnerFunc(func(context.Context, []string, io.Reader, io.Writer, io.Writer) error {
+		return exitError(1)
+	}), io.Discard)
+	assert.EqualError(t, err, "failed to get contexts: exit status 1")
+}
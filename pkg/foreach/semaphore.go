@@ -0,0 +1,92 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"sync"
+)
+
+// Semaphore limits the contexts the command runs in at a time in Run, to a
+// limit that can be changed while it runs, e.g. from a file. A limit of 0 is
+// unlimited.
+type Semaphore struct {
+	mu     sync.Mutex
+	cond   *sync.Cond
+	limit  int
+	active int
+}
+
+func NewSemaphore(limit int) *Semaphore {
+	s := &Semaphore{limit: limit}
+	s.cond = sync.NewCond(&s.mu)
+	return s
+}
+
+// acquire waits until there are fewer than limit runs active.
+func (s *Semaphore) acquire() {
+	_ = s.acquireContext(context.Background())
+}
+
+// acquireContext is like acquire, but returns the error of ctx if it's done
+// before there are fewer than limit runs active.
+func (s *Semaphore) acquireContext(ctx context.Context) error {
+	if ctx.Done() != nil {
+		stop := make(chan struct{})
+		defer close(stop)
+		go func() {
+			select {
+			case <-ctx.Done():
+				s.mu.Lock()
+				s.cond.Broadcast()
+				s.mu.Unlock()
+			case <-stop:
+			}
+		}()
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for s.limit > 0 && s.active >= s.limit {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		s.cond.Wait()
+	}
+	s.active++
+	return nil
+}
+
+func (s *Semaphore) release() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.active--
+	s.cond.Broadcast()
+}
+
+// Limit returns the current limit.
+func (s *Semaphore) Limit() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.limit
+}
+
+// SetLimit changes the limit. When it shrinks, the active runs continue and
+// no new ones start until they are under the new limit.
+func (s *Semaphore) SetLimit(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.limit = n
+	s.cond.Broadcast()
+}
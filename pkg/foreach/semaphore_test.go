@@ -0,0 +1,84 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSemaphore(t *testing.T) {
+	s := NewSemaphore(1)
+	s.acquire()
+
+	acquired := make(chan struct{})
+	go func() {
+		s.acquire()
+		close(acquired)
+	}()
+	select {
+	case <-acquired:
+		t.Fatal("acquired over the limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	s.SetLimit(2)
+	select {
+	case <-acquired:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after growing the limit")
+	}
+
+	s.SetLimit(1)
+	s.release()
+	again := make(chan struct{})
+	go func() {
+		s.acquire()
+		close(again)
+	}()
+	select {
+	case <-again:
+		t.Fatal("acquired over the shrunk limit")
+	case <-time.After(50 * time.Millisecond):
+	}
+	s.release()
+	select {
+	case <-again:
+	case <-time.After(time.Second):
+		t.Fatal("not acquired after a release")
+	}
+}
+
+func TestSemaphore_acquireContext(t *testing.T) {
+	s := NewSemaphore(1)
+	require.NoError(t, s.acquireContext(context.Background()))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	assert.ErrorIs(t, s.acquireContext(ctx), context.Canceled)
+	assert.Equal(t, 1, s.active, "not acquired when cancelled")
+}
+
+func TestSemaphore_unlimited(t *testing.T) {
+	s := NewSemaphore(0)
+	for i := 0; i < 100; i++ {
+		s.acquire()
+	}
+	assert.Equal(t, 100, s.active)
+}
@@ -0,0 +1,35 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package foreach
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// signal sends sig to cmd, or to its process group if it was started in its
+// own group, so that the commands started by cmd get it too.
+func signal(cmd *exec.Cmd, sig syscall.Signal) error {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return syscall.Kill(-cmd.Process.Pid, sig)
+	}
+	return cmd.Process.Signal(sig)
+}
+
+func interrupt(cmd *exec.Cmd) error { return signal(cmd, syscall.SIGINT) }
+
+func kill(cmd *exec.Cmd) error { return signal(cmd, syscall.SIGKILL) }
@@ -0,0 +1,55 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"os/exec"
+	"time"
+)
+
+// Wait waits for the started cmd to exit. If ctx is done before that, cmd is
+// interrupted (like with Ctrl-C, so that kubectl can stop port-forwards and
+// proxies) and killed if it's still running after grace. It always returns
+// after cmd has exited. A command that exits successfully after it was
+// interrupted still returns an error. If cmd was started in its own process
+// group (with Setpgid on unix), the signals are sent to the whole group.
+func Wait(ctx context.Context, cmd *exec.Cmd, grace time.Duration) error {
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+	}
+	// interrupts aren't supported on Windows, where it's killed right away
+	if grace > 0 && interrupt(cmd) == nil {
+		t := time.NewTimer(grace)
+		defer t.Stop()
+		select {
+		case err := <-done:
+			if err == nil {
+				err = ctx.Err()
+			}
+			return err
+		case <-t.C:
+		}
+	}
+	_ = kill(cmd)
+	if err := <-done; err != nil {
+		return err
+	}
+	return ctx.Err()
+}
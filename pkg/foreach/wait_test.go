@@ -0,0 +1,87 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package foreach
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// startInterruptible starts a long-running command that runs onInterrupt when
+// interrupted (or ignores interrupts if it's empty), and waits until it has set
+// up the trap.
+func startInterruptible(t *testing.T, onInterrupt string) (*exec.Cmd, *lockedBuffer) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	out := new(lockedBuffer)
+	cmd := exec.Command("sh", "-c", `trap '`+onInterrupt+`' INT; echo ready; sleep 10 >/dev/null 2>&1 & wait $!`)
+	cmd.Stdout = out
+	require.NoError(t, cmd.Start())
+	require.Eventually(t, func() bool { return strings.Contains(out.String(), "ready") },
+		5*time.Second, 10*time.Millisecond)
+	return cmd, out
+}
+
+func TestWait(t *testing.T) {
+	t.Run("not cancelled", func(t *testing.T) {
+		if runtime.GOOS == "windows" {
+			t.Skip("needs sh")
+		}
+		cmd := exec.Command("sh", "-c", "exit 3")
+		require.NoError(t, cmd.Start())
+		var exitErr *exec.ExitError
+		require.True(t, errors.As(Wait(context.Background(), cmd, time.Second), &exitErr))
+		assert.Equal(t, 3, exitErr.ExitCode())
+	})
+	t.Run("exits when interrupted", func(t *testing.T) {
+		cmd, out := startInterruptible(t, "echo interrupted; exit 0")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		err := Wait(ctx, cmd, 5*time.Second)
+		assert.ErrorIs(t, err, context.Canceled, "not a success even if it exits with 0")
+		assert.Less(t, time.Since(start), 4*time.Second)
+		assert.Equal(t, "ready\ninterrupted\n", out.String())
+	})
+	t.Run("killed after grace", func(t *testing.T) {
+		cmd, out := startInterruptible(t, "")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		start := time.Now()
+		err := Wait(ctx, cmd, 200*time.Millisecond)
+		assert.Error(t, err)
+		assert.GreaterOrEqual(t, time.Since(start), 200*time.Millisecond)
+		assert.Less(t, time.Since(start), 4*time.Second)
+		assert.NotNil(t, cmd.ProcessState, "reaped")
+		assert.Equal(t, "ready\n", out.String())
+	})
+	t.Run("killed right away without grace", func(t *testing.T) {
+		cmd, out := startInterruptible(t, "echo interrupted; exit 0")
+		ctx, cancel := context.WithCancel(context.Background())
+		cancel()
+		assert.Error(t, Wait(ctx, cmd, 0))
+		assert.Equal(t, "ready\n", out.String())
+	})
+}
@@ -0,0 +1,94 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/errgroup"
+)
+
+// envProbeContext is set to the context name for the --filter-command.
+const envProbeContext = `KUBECTL_CONTEXT`
+
+// runProbe runs the shell command probe for a context, and reports whether it
+// exited with 0. Its stdout is discarded.
+func runProbe(ctx context.Context, probe, kctx string, stderr io.Writer) (bool, error) {
+	cmd := shellCommand(ctx, probe)
+	cmd.Env = append(os.Environ(), envProbeContext+"="+kctx)
+	cmd.Stderr = stderr
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return false, nil
+	} else if err != nil {
+		return false, fmt.Errorf("failed to run filter command for context %q: %w", kctx, err)
+	}
+	return true, nil
+}
+
+// cachedProbe returns check with its results saved in dir for ttl, keyed by
+// the probe command and context.
+func cachedProbe(dir, probe string, ttl time.Duration, now time.Time, check func(string) (bool, error)) func(string) (bool, error) {
+	return func(kctx string) (bool, error) {
+		b, err := cachedFetch(dir, probe+"\x00"+kctx, ttl, now, func() ([]byte, error) {
+			ok, err := check(kctx)
+			if err != nil {
+				return nil, err
+			}
+			return []byte(fmt.Sprint(ok)), nil
+		})
+		return string(b) == "true", err
+	}
+}
+
+// filterByProbe returns the contexts in kubeCtxs for which check reports true,
+// keeping their order. check is called for up to parallel contexts at a time
+// (0: all).
+func filterByProbe(kubeCtxs []string, parallel int, check func(string) (bool, error)) ([]string, error) {
+	var wg errgroup.Group
+	if parallel > 0 {
+		wg.SetLimit(parallel)
+	}
+	var mu sync.Mutex
+	keep := make(map[string]bool, len(kubeCtxs))
+	for _, kctx := range kubeCtxs {
+		kctx := kctx
+		wg.Go(func() error {
+			ok, err := check(kctx)
+			mu.Lock()
+			keep[kctx] = ok
+			mu.Unlock()
+			return err
+		})
+	}
+	if err := wg.Wait(); err != nil {
+		return nil, err
+	}
+	var out []string
+	for _, c := range kubeCtxs {
+		if keep[c] {
+			out = append(out, c)
+		}
+	}
+	return out, nil
+}
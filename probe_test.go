@@ -0,0 +1,73 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"io"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_runProbe(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	probe := `test "$KUBECTL_CONTEXT" = a`
+	ok, err := runProbe(context.Background(), probe, "a", io.Discard)
+	require.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = runProbe(context.Background(), probe, "b", io.Discard)
+	require.NoError(t, err)
+	assert.False(t, ok)
+}
+
+func Test_filterByProbe(t *testing.T) {
+	got, err := filterByProbe([]string{"a", "b", "c", "d"}, 2, func(s string) (bool, error) { return s != "b", nil })
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "c", "d"}, got)
+
+	_, err = filterByProbe([]string{"a"}, 0, func(string) (bool, error) { return false, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+}
+
+func Test_cachedProbe(t *testing.T) {
+	dir := t.TempDir()
+	now := time.Now()
+	var calls int
+	check := func(s string) (bool, error) {
+		calls++
+		return s == "a", nil
+	}
+	for i := 0; i < 2; i++ {
+		ok, err := cachedProbe(dir, "probe", time.Minute, now, check)("a")
+		require.NoError(t, err)
+		assert.True(t, ok)
+		ok, err = cachedProbe(dir, "probe", time.Minute, now, check)("b")
+		require.NoError(t, err)
+		assert.False(t, ok)
+	}
+	assert.Equal(t, 2, calls)
+
+	_, _ = cachedProbe(dir, "other probe", time.Minute, now, check)("a")
+	assert.Equal(t, 3, calls, "command is part of the key")
+	_, _ = cachedProbe(dir, "probe", time.Minute, now.Add(time.Hour), check)("a")
+	assert.Equal(t, 4, calls, "expired")
+}
@@ -0,0 +1,143 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Providers of Kubernetes API servers for --provider-aware-concurrency.
+const (
+	providerGKE   = "gke"
+	providerEKS   = "eks"
+	providerAKS   = "aks"
+	providerOther = "other" // on-prem or unknown
+)
+
+// detectProvider guesses the provider of the API server of the context from
+// its server URL and cluster name.
+func detectProvider(c contextInfo) string {
+	host := c.server
+	if u, err := url.Parse(c.server); err == nil && u.Host != "" {
+		host = u.Hostname()
+	}
+	switch {
+	case strings.HasSuffix(host, ".eks.amazonaws.com") || strings.HasPrefix(c.cluster, "arn:aws:eks:"):
+		return providerEKS
+	case strings.HasSuffix(host, ".azmk8s.io"):
+		return providerAKS
+	case strings.HasSuffix(host, ".gke.goog") || strings.HasPrefix(c.cluster, "gke_"):
+		return providerGKE
+	}
+	return providerOther
+}
+
+// providerLimits is the max number of parallel runs on API servers of each
+// provider, where 0 is unlimited. It implements flag.Value.
+type providerLimits map[string]int
+
+// defaultProviderLimits are used for providers not set in --provider-limits.
+// Managed control planes throttle clients more aggressively.
+var defaultProviderLimits = providerLimits{
+	providerGKE:   10,
+	providerEKS:   10,
+	providerAKS:   10,
+	providerOther: 0,
+}
+
+func (p providerLimits) String() string {
+	keys := make([]string, 0, len(p))
+	for k := range p {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = k + "=" + strconv.Itoa(p[k])
+	}
+	return strings.Join(out, ",")
+}
+
+func (p *providerLimits) Set(s string) error {
+	out := make(providerLimits, len(defaultProviderLimits))
+	for k, v := range defaultProviderLimits {
+		out[k] = v
+	}
+	for _, v := range splitList(s) {
+		k, n, ok := strings.Cut(v, "=")
+		if _, known := defaultProviderLimits[k]; !known || !ok {
+			return fmt.Errorf("invalid provider limit %q: need PROVIDER=NUM with PROVIDER one of gke, eks, aks, other", v)
+		}
+		i, err := strconv.Atoi(n)
+		if err != nil || i < 0 {
+			return fmt.Errorf("invalid provider limit %q: need a number >= 0", v)
+		}
+		out[k] = i
+	}
+	*p = out
+	return nil
+}
+
+// providerLimiter limits the parallel runs on the API servers of each
+// provider.
+type providerLimiter struct {
+	providers map[string]string        // provider of each context
+	sems      map[string]chan struct{} // nil for unlimited providers
+}
+
+func newProviderLimiter(kc map[string]contextInfo, limits providerLimits) *providerLimiter {
+	l := &providerLimiter{providers: make(map[string]string, len(kc)), sems: make(map[string]chan struct{})}
+	for name, c := range kc {
+		l.providers[name] = detectProvider(c)
+	}
+	for p, n := range limits {
+		if n > 0 {
+			l.sems[p] = make(chan struct{}, n)
+		}
+	}
+	return l
+}
+
+// provider returns the provider of the context.
+func (l *providerLimiter) provider(ctx string) string {
+	if p, ok := l.providers[ctx]; ok {
+		return p
+	}
+	return providerOther
+}
+
+// acquire waits until the context kctx can run, and returns a function that
+// must be called once it's done, or an error if ctx is done before that.
+func (l *providerLimiter) acquire(ctx context.Context, kctx string) (release func(), err error) {
+	if l == nil {
+		return func() {}, nil
+	}
+	p := l.provider(kctx)
+	sem := l.sems[p]
+	if sem == nil {
+		return func() {}, nil
+	}
+	select {
+	case sem <- struct{}{}:
+		return func() { <-sem }, nil
+	case <-ctx.Done():
+		return nil, fmt.Errorf("waiting to run on %s: %w", p, ctx.Err())
+	}
+}
@@ -0,0 +1,108 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_detectProvider(t *testing.T) {
+	for _, tc := range []struct {
+		in   contextInfo
+		want string
+	}{
+		{contextInfo{server: "https://ABCD.gr7.us-east-1.eks.amazonaws.com"}, providerEKS},
+		{contextInfo{cluster: "arn:aws:eks:us-east-1:123:cluster/x", server: "https://10.0.0.1"}, providerEKS},
+		{contextInfo{server: "https://x-dns-12345678.hcp.westeurope.azmk8s.io:443"}, providerAKS},
+		{contextInfo{cluster: "gke_proj_us-central1_x", server: "https://34.1.2.3"}, providerGKE},
+		{contextInfo{server: "https://gke-123.us-central1.gke.goog"}, providerGKE},
+		{contextInfo{cluster: "kind-kind", server: "https://127.0.0.1:6443"}, providerOther},
+		{contextInfo{}, providerOther},
+	} {
+		assert.Equal(t, tc.want, detectProvider(tc.in), "%+v", tc.in)
+	}
+}
+
+func Test_providerLimits(t *testing.T) {
+	var p providerLimits
+	require.NoError(t, p.Set("eks=3, other=5"))
+	assert.Equal(t, providerLimits{providerGKE: 10, providerEKS: 3, providerAKS: 10, providerOther: 5}, p)
+	assert.Equal(t, "aks=10,eks=3,gke=10,other=5", p.String())
+
+	for _, v := range []string{"eks", "foo=1", "eks=-1", "eks=x"} {
+		assert.Error(t, p.Set(v), v)
+	}
+}
+
+func TestProviderLimiter(t *testing.T) {
+	kc := map[string]contextInfo{
+		"a": {cluster: "gke_p_z_a"},
+		"b": {cluster: "gke_p_z_b"},
+		"c": {cluster: "gke_p_z_c"},
+		"d": {cluster: "kind"},
+	}
+	l := newProviderLimiter(kc, providerLimits{providerGKE: 2, providerOther: 0})
+	assert.Equal(t, providerGKE, l.provider("a"))
+	assert.Equal(t, providerOther, l.provider("unknown"))
+
+	var running, peak int32
+	var wg sync.WaitGroup
+	for _, c := range []string{"a", "b", "c", "a", "b", "c"} {
+		wg.Add(1)
+		go func(c string) {
+			defer wg.Done()
+			release, err := l.acquire(context.Background(), c)
+			assert.NoError(t, err)
+			defer release()
+			n := atomic.AddInt32(&running, 1)
+			for {
+				p := atomic.LoadInt32(&peak)
+				if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+					break
+				}
+			}
+			time.Sleep(10 * time.Millisecond)
+			atomic.AddInt32(&running, -1)
+		}(c)
+	}
+	wg.Wait()
+	assert.Equal(t, int32(2), peak)
+
+	for i := 0; i < 3; i++ {
+		_, err := l.acquire(context.Background(), "d") // unlimited
+		require.NoError(t, err)
+	}
+	var nilLimiter *providerLimiter
+	_, err := nilLimiter.acquire(context.Background(), "a")
+	require.NoError(t, err)
+
+	// waiting for a slot is cancelled with ctx
+	release, err := l.acquire(context.Background(), "a")
+	require.NoError(t, err)
+	defer release()
+	_, err = l.acquire(context.Background(), "b")
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	time.AfterFunc(20*time.Millisecond, cancel)
+	_, err = l.acquire(ctx, "c")
+	assert.ErrorIs(t, err, context.Canceled)
+}
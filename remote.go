@@ -0,0 +1,131 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// fetchContextNames gets the list of context names from url. headers are
+// "Name: value" request headers, e.g. for authentication.
+func fetchContextNames(ctx context.Context, url string, headers []string, timeout time.Duration) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, fmt.Errorf("invalid --contexts-from-url: %w", err)
+	}
+	for _, h := range headers {
+		k, v, ok := strings.Cut(h, ":")
+		if !ok || strings.TrimSpace(k) == "" {
+			return nil, fmt.Errorf("invalid --header %q: need \"Name: value\"", h)
+		}
+		req.Header.Add(strings.TrimSpace(k), strings.TrimSpace(v))
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get contexts from url: %w", err)
+	}
+	defer resp.Body.Close()
+	b, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read contexts from url: %w", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return nil, fmt.Errorf("failed to get contexts from url: %s", resp.Status)
+	}
+	return b, nil
+}
+
+// parseContextNames parses a JSON array of context names, or a list of names
+// on separate lines, ignoring empty lines and lines starting with '#'.
+func parseContextNames(b []byte) ([]string, error) {
+	if b = bytes.TrimSpace(b); bytes.HasPrefix(b, []byte("[")) {
+		var out []string
+		if err := json.Unmarshal(b, &out); err != nil {
+			return nil, fmt.Errorf("failed to parse contexts: %w", err)
+		}
+		return out, nil
+	}
+	var out []string
+	for _, l := range strings.Split(string(b), "\n") {
+		if l = strings.TrimSpace(l); l != "" && !strings.HasPrefix(l, "#") {
+			out = append(out, l)
+		}
+	}
+	return out, nil
+}
+
+// urlCacheKey returns the cachedFetch key of the response of url requested with
+// headers, so that requests with other headers (e.g. for another user) don't
+// share the response. The order of headers doesn't matter.
+func urlCacheKey(url string, headers []string) string {
+	sorted := make([]string, len(headers))
+	copy(sorted, headers)
+	sort.Strings(sorted)
+	sum := sha256.Sum256([]byte(strings.Join(sorted, "\n")))
+	return url + "\x00" + hex.EncodeToString(sum[:])
+}
+
+// cachedFetch returns the response for key (e.g. a url) saved in dir if it's
+// newer than ttl, otherwise it calls fetch and saves its response.
+func cachedFetch(dir, key string, ttl time.Duration, now time.Time, fetch func() ([]byte, error)) ([]byte, error) {
+	sum := sha256.Sum256([]byte(key))
+	path := filepath.Join(dir, hex.EncodeToString(sum[:]))
+	if fi, err := os.Stat(path); err == nil && now.Sub(fi.ModTime()) < ttl {
+		if b, err := os.ReadFile(path); err == nil {
+			return b, nil
+		}
+	}
+	b, err := fetch()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, fmt.Errorf("failed to create cache directory: %w", err)
+	}
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		return nil, fmt.Errorf("failed to write cache: %w", err)
+	}
+	return b, nil
+}
+
+// intersectContexts returns the contexts in kubeCtxs that are in names,
+// keeping their order in kubeCtxs.
+func intersectContexts(kubeCtxs, names []string) []string {
+	m := make(map[string]bool, len(names))
+	for _, v := range names {
+		m[v] = true
+	}
+	var out []string
+	for _, v := range kubeCtxs {
+		if m[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
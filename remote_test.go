@@ -0,0 +1,118 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_fetchContextNames(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/ok":
+			if r.Header.Get("Authorization") != "Bearer x" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte("a\nb\n"))
+		case "/slow":
+			time.Sleep(200 * time.Millisecond)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	b, err := fetchContextNames(ctx, srv.URL+"/ok", []string{"Authorization: Bearer x"}, time.Second)
+	require.NoError(t, err)
+	assert.Equal(t, "a\nb\n", string(b))
+
+	_, err = fetchContextNames(ctx, srv.URL+"/ok", nil, time.Second)
+	assert.EqualError(t, err, "failed to get contexts from url: 401 Unauthorized")
+
+	_, err = fetchContextNames(ctx, srv.URL+"/missing", nil, time.Second)
+	assert.EqualError(t, err, "failed to get contexts from url: 404 Not Found")
+
+	_, err = fetchContextNames(ctx, srv.URL+"/slow", nil, 10*time.Millisecond)
+	assert.Error(t, err)
+
+	_, err = fetchContextNames(ctx, srv.URL+"/ok", []string{"no-colon"}, time.Second)
+	assert.Error(t, err)
+}
+
+func Test_parseContextNames(t *testing.T) {
+	got, err := parseContextNames([]byte(" [\"a\", \"b\"]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	got, err = parseContextNames([]byte("# fleet\na\n\n  b \r\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, got)
+
+	_, err = parseContextNames([]byte("[1]"))
+	assert.Error(t, err)
+}
+
+func Test_cachedFetch(t *testing.T) {
+	dir := t.TempDir()
+	calls := 0
+	fetch := func() ([]byte, error) {
+		calls++
+		return []byte("a"), nil
+	}
+	now := time.Now()
+
+	for i := 0; i < 2; i++ {
+		b, err := cachedFetch(dir, "http://x", time.Minute, now, fetch)
+		require.NoError(t, err)
+		assert.Equal(t, "a", string(b))
+	}
+	assert.Equal(t, 1, calls)
+
+	_, err := cachedFetch(dir, "http://x", time.Minute, now.Add(time.Hour), fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 2, calls, "expired")
+
+	_, err = cachedFetch(dir, "http://y", time.Minute, now, fetch)
+	require.NoError(t, err)
+	assert.Equal(t, 3, calls, "other url")
+
+	_, err = cachedFetch(dir, "http://z", time.Minute, now, func() ([]byte, error) { return nil, errors.New("boom") })
+	assert.EqualError(t, err, "boom")
+}
+
+func Test_urlCacheKey(t *testing.T) {
+	url := "https://inventory/contexts"
+	key := urlCacheKey(url, []string{"Authorization: Bearer a", "X-Team: infra"})
+	assert.Equal(t, key, urlCacheKey(url, []string{"X-Team: infra", "Authorization: Bearer a"}), "order doesn't matter")
+	assert.NotEqual(t, key, urlCacheKey(url, []string{"Authorization: Bearer b", "X-Team: infra"}))
+	assert.NotEqual(t, key, urlCacheKey(url, nil))
+	assert.NotEqual(t, key, urlCacheKey("https://inventory/other", []string{"Authorization: Bearer a", "X-Team: infra"}))
+	assert.NotContains(t, key, "Bearer", "header values are hashed")
+}
+
+func Test_intersectContexts(t *testing.T) {
+	assert.Equal(t, []string{"a", "c"}, intersectContexts([]string{"a", "b", "c"}, []string{"c", "x", "a"}))
+	assert.Empty(t, intersectContexts([]string{"a"}, nil))
+}
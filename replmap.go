@@ -0,0 +1,64 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// loadReplMap reads a JSON file mapping context names to the values to
+// replace the --repl-map-token with, e.g. {"prod-us": "project-123"}.
+func loadReplMap(path string) (map[string]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read replacement map: %w", err)
+	}
+	var m map[string]string
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, fmt.Errorf("failed to parse replacement map file %s: %w", path, err)
+	}
+	return m, nil
+}
+
+// mapArgs returns the arguments from argMaker with token replaced by the value
+// of the context in values, or by the context name if it's not in values.
+func mapArgs(argMaker func(string) []string, token string, values map[string]string) func(string) []string {
+	return func(ctx string) []string {
+		v, ok := values[ctx]
+		if !ok {
+			v = ctx
+		}
+		args := argMaker(ctx)
+		out := make([]string, len(args))
+		for i := range args {
+			out[i] = strings.Replace(args[i], token, v, -1)
+		}
+		return out
+	}
+}
+
+// unmapped returns the contexts that have no value in values.
+func unmapped(kubeCtxs []string, values map[string]string) []string {
+	var out []string
+	for _, c := range kubeCtxs {
+		if _, ok := values[c]; !ok {
+			out = append(out, c)
+		}
+	}
+	return out
+}
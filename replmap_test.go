@@ -0,0 +1,55 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_loadReplMap(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "map.json")
+	require.NoError(t, os.WriteFile(f, []byte(`{"a": "project-1", "b": "project-2"}`), 0o644))
+	m, err := loadReplMap(f)
+	require.NoError(t, err)
+	assert.Equal(t, map[string]string{"a": "project-1", "b": "project-2"}, m)
+
+	require.NoError(t, os.WriteFile(f, []byte(`{"a": 1}`), 0o644))
+	_, err = loadReplMap(f)
+	assert.Error(t, err)
+
+	_, err = loadReplMap(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func Test_mapArgs(t *testing.T) {
+	values := map[string]string{"a": "project-1"}
+	f := mapArgs(foreach.ReplaceArgs([]string{"get", "ns", "@P", "--label=p=@P"}, ""), "@P", values)
+	assert.Equal(t, []string{"--context=a", "get", "ns", "project-1", "--label=p=project-1"}, f("a"))
+	assert.Equal(t, []string{"--context=b", "get", "ns", "b", "--label=p=b"}, f("b"), "falls back to context name")
+
+	f = mapArgs(foreach.ReplaceArgs([]string{"--context=_", "-n", "@P"}, "_"), "@P", values)
+	assert.Equal(t, []string{"--context=a", "-n", "project-1"}, f("a"), "composes with -I")
+}
+
+func Test_unmapped(t *testing.T) {
+	assert.Equal(t, []string{"b", "c"}, unmapped([]string{"a", "b", "c"}, map[string]string{"a": "x"}))
+	assert.Empty(t, unmapped([]string{"a"}, map[string]string{"a": "x"}))
+}
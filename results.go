@@ -0,0 +1,172 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// result is the outcome of running the command in a context.
+type result struct {
+	context  string
+	err      error // nil if the command succeeded
+	duration time.Duration
+	output   *bytes.Buffer // up to --capture-limit of output for reports, nil if not captured
+	stderr   *bytes.Buffer // nil if stderr is not captured
+	reason   string        // failure reason from --classify-failures, if any
+	stdout   *bytes.Buffer // all of stdout with --baseline-context, nil otherwise
+	hash     string        // sha256 of stdout (and --verify) with --output-hash, if the command ran
+
+	outputLines int // lines printed to stdout
+	attempts    int // runs of the command in the context, more than one with --retries
+	code        int // exit code of the last run of the command (or of --verify, if it failed), if attempts > 0
+}
+
+// newResult returns the result of a context run with foreach.Run.
+func newResult(r foreach.Result) result {
+	res := result{
+		context:     r.Context,
+		err:         r.Err,
+		duration:    r.Duration,
+		reason:      r.Reason,
+		hash:        r.Hash,
+		outputLines: r.OutputLines,
+		attempts:    r.Attempts,
+		code:        r.ExitCode,
+	}
+	if r.Output != nil {
+		res.output = bytes.NewBuffer(r.Output)
+	}
+	if r.Stderr != nil {
+		res.stderr = bytes.NewBuffer(r.Stderr)
+	}
+	if r.Stdout != nil {
+		res.stdout = bytes.NewBuffer(r.Stdout)
+	}
+	return res
+}
+
+const (
+	statusSucceeded = "succeeded"
+	statusFailed    = "failed"
+	statusSkipped   = "skipped"
+	// statusUnreachable is used for failures to connect to the API server,
+	// which are not counted as failures with --skip-unreachable.
+	statusUnreachable = "unreachable"
+)
+
+// failurePattern is a pattern of the --failure-patterns file.
+type failurePattern struct {
+	Reason  string `json:"reason"`
+	Pattern string `json:"pattern"`
+}
+
+// loadFailurePatterns reads a JSON list of failure patterns, such as
+//
+//	[{"reason": "quota", "pattern": "exceeded quota"}]
+func loadFailurePatterns(path string) ([]foreach.FailurePattern, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read failure patterns: %w", err)
+	}
+	var ps []failurePattern
+	if err := json.Unmarshal(b, &ps); err != nil {
+		return nil, fmt.Errorf("failed to parse failure patterns file %s: %w", path, err)
+	}
+	out := make([]foreach.FailurePattern, len(ps))
+	for i, p := range ps {
+		if p.Reason == "" {
+			return nil, fmt.Errorf("failure pattern %q has no reason", p.Pattern)
+		}
+		re, err := regexp.Compile(p.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid failure pattern for %q: %w", p.Reason, err)
+		}
+		out[i] = foreach.FailurePattern{Reason: p.Reason, Pattern: re}
+	}
+	return out, nil
+}
+
+// reasonCounts formats the number of failed results with each reason, e.g.
+// "forbidden: 2, not-found: 1", most common first.
+func reasonCounts(results []result) string {
+	m := make(map[string]int)
+	for _, r := range results {
+		if r.reason != "" {
+			m[r.reason]++
+		}
+	}
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if m[keys[i]] != m[keys[j]] {
+			return m[keys[i]] > m[keys[j]]
+		}
+		return keys[i] < keys[j]
+	})
+	out := make([]string, len(keys))
+	for i, k := range keys {
+		out[i] = fmt.Sprintf("%s: %d", k, m[k])
+	}
+	return strings.Join(out, ", ")
+}
+
+func (r result) status() string {
+	switch {
+	case r.err == nil:
+		return statusSucceeded
+	case errors.Is(r.err, foreach.ErrSkipped):
+		return statusSkipped
+	case errors.Is(r.err, foreach.ErrUnreachable):
+		return statusUnreachable
+	default:
+		return statusFailed
+	}
+}
+
+// exitCode returns the exit code of the command, also if it's in
+// --success-codes, or -1 if it has not exited on its own (e.g. it could not
+// be started, or was skipped or killed).
+func (r result) exitCode() int {
+	if r.attempts > 0 {
+		return r.code
+	}
+	return commandExitCode(r.err)
+}
+
+// commandExitCode returns the exit code of a command that returned err, or -1
+// if it has not exited on its own.
+func commandExitCode(err error) int {
+	var exitErr *exec.ExitError
+	if errors.As(err, &exitErr) {
+		return exitErr.ExitCode()
+	} else if err == nil {
+		return 0
+	}
+	return -1
+}
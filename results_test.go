@@ -0,0 +1,88 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestResult(t *testing.T) {
+	exit3 := exec.Command("sh", "-c", "exit 3").Run()
+
+	ok := result{context: "a"}
+	assert.Equal(t, statusSucceeded, ok.status())
+	assert.Equal(t, 0, ok.exitCode())
+
+	failed := result{context: "a", err: fmt.Errorf("wrapped: %w", exit3)}
+	assert.Equal(t, statusFailed, failed.status())
+	assert.Equal(t, 3, failed.exitCode())
+
+	skipped := result{context: "a", err: fmt.Errorf("wrapped: %w", foreach.ErrSkipped)}
+	assert.Equal(t, statusSkipped, skipped.status())
+	assert.Equal(t, -1, skipped.exitCode())
+
+	startErr := result{context: "a", err: errors.New("phony error")}
+	assert.Equal(t, statusFailed, startErr.status())
+	assert.Equal(t, -1, startErr.exitCode())
+
+	diff := result{context: "a", attempts: 1, code: 1} // exit code 1 is in --success-codes
+	assert.Equal(t, statusSucceeded, diff.status())
+	assert.Equal(t, 1, diff.exitCode())
+}
+
+func Test_unreachableStatus(t *testing.T) {
+	r := result{err: fmt.Errorf("context %q: %w (%v)", "a", foreach.ErrUnreachable, errors.New("exit status 1"))}
+	assert.Equal(t, statusUnreachable, r.status())
+}
+
+func Test_loadFailurePatterns(t *testing.T) {
+	dir := t.TempDir()
+	write := func(s string) string {
+		f := filepath.Join(dir, "p.json")
+		require.NoError(t, os.WriteFile(f, []byte(s), 0o644))
+		return f
+	}
+
+	ps, err := loadFailurePatterns(write(`[{"reason": "quota", "pattern": "exceeded quota"}, {"reason": "any", "pattern": "."}]`))
+	require.NoError(t, err)
+	require.Len(t, ps, 2)
+	assert.Equal(t, "quota", ps[0].Reason)
+	assert.True(t, ps[0].Pattern.MatchString("forbidden: exceeded quota"))
+	assert.Equal(t, "any", ps[1].Reason)
+	assert.True(t, ps[1].Pattern.MatchString("not found"))
+
+	_, err = loadFailurePatterns(write(`[{"reason": "x", "pattern": "("}]`))
+	assert.Error(t, err)
+	_, err = loadFailurePatterns(write(`[{"pattern": "x"}]`))
+	assert.Error(t, err)
+	_, err = loadFailurePatterns(filepath.Join(dir, "missing.json"))
+	assert.Error(t, err)
+}
+
+func Test_reasonCounts(t *testing.T) {
+	assert.Equal(t, "", reasonCounts([]result{{context: "a"}}))
+	assert.Equal(t, "forbidden: 2, other: 1", reasonCounts([]result{
+		{reason: foreach.ReasonOther}, {reason: foreach.ReasonForbidden}, {}, {reason: foreach.ReasonForbidden},
+	}))
+}
@@ -0,0 +1,56 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+)
+
+// failedContexts returns the contexts in results that failed (or were
+// skipped), to run again in another pass of --retry-failed-passes.
+func failedContexts(results []result) []string {
+	var out []string
+	for _, r := range results {
+		if r.err != nil && r.status() != statusUnreachable {
+			out = append(out, r.context)
+		}
+	}
+	return out
+}
+
+// mergeRetried replaces the results of contexts run again with their results
+// in retried.
+func mergeRetried(results, retried []result) []result {
+	byContext := make(map[string]result, len(retried))
+	for _, r := range retried {
+		byContext[r.context] = r
+	}
+	out := make([]result, len(results))
+	for i, r := range results {
+		if v, ok := byContext[r.context]; ok {
+			r = v
+		}
+		out[i] = r
+	}
+	return out
+}
+
+// writePassResult writes how many of the contexts run in a pass succeeded.
+func writePassResult(w io.Writer, pass int, results []result) {
+	failed := len(failedContexts(results))
+	fmt.Fprintln(w, gray(fmt.Sprintf("pass %d: %d of %d context(s) succeeded, %d failed",
+		pass, len(results)-failed, len(results), failed)))
+}
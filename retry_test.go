@@ -0,0 +1,53 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFailedContexts(t *testing.T) {
+	results := []result{
+		{context: "a"},
+		{context: "b", err: errors.New("exit status 1")},
+		{context: "c", err: foreach.ErrUnreachable},
+		{context: "d", err: foreach.ErrSkipped},
+	}
+	assert.Equal(t, []string{"b", "d"}, failedContexts(results))
+	assert.Empty(t, failedContexts(results[:1]))
+}
+
+func TestMergeRetried(t *testing.T) {
+	fail := errors.New("exit status 1")
+	results := []result{{context: "a"}, {context: "b", err: fail}, {context: "c", err: fail}}
+	got := mergeRetried(results, []result{{context: "c"}, {context: "b", err: foreach.ErrTimeout}})
+	assert.Equal(t, []result{{context: "a"}, {context: "b", err: foreach.ErrTimeout}, {context: "c"}}, got)
+	assert.Equal(t, fail, results[1].err, "results must not be modified")
+}
+
+func TestWritePassResult(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var buf bytes.Buffer
+	writePassResult(&buf, 2, []result{{context: "a"}, {context: "b", err: errors.New("exit status 1")}})
+	assert.Equal(t, "pass 2: 1 of 2 context(s) succeeded, 1 failed\n", buf.String())
+}
@@ -0,0 +1,127 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// writeScript writes a shell script that runs the command in each of the
+// contexts sequentially. The script runs "set <setOpts>" at the start, unless
+// setOpts is empty.
+func writeScript(w io.Writer, kubeCtxs []string, argMaker func(string) []string, setOpts string) error {
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\n")
+	b.WriteString("# Generated by kubectl foreach for context(s):\n")
+	for _, c := range kubeCtxs {
+		fmt.Fprintf(&b, "#   - %s\n", c)
+	}
+	if setOpts != "" {
+		fmt.Fprintf(&b, "set %s\n", setOpts)
+	}
+	for _, c := range kubeCtxs {
+		b.WriteString(shellJoin(commandArgv(wrapper, argMaker(c))))
+		b.WriteString("\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// emitScript writes the script from writeScript to an executable file.
+func emitScript(path string, kubeCtxs []string, argMaker func(string) []string, setOpts string) error {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0o755)
+	if err != nil {
+		return fmt.Errorf("failed to create script: %w", err)
+	}
+	if err := writeScript(f, kubeCtxs, argMaker, setOpts); err != nil {
+		f.Close()
+		return fmt.Errorf("failed to write script: %w", err)
+	}
+	return f.Close()
+}
+
+// writeList writes the contexts to w, one per line. If argMaker is not nil, the
+// command to run in the first context is written to sample, to check the
+// arguments after replacements.
+func writeList(w, sample io.Writer, kubeCtxs []string, argMaker func(string) []string) error {
+	for _, c := range kubeCtxs {
+		if _, err := fmt.Fprintln(w, c); err != nil {
+			return err
+		}
+	}
+	if argMaker == nil || len(kubeCtxs) == 0 {
+		return nil
+	}
+	_, err := fmt.Fprintf(sample, "Command in %s: %s\n", kubeCtxs[0], shellJoin(commandArgv(wrapper, argMaker(kubeCtxs[0]))))
+	return err
+}
+
+// writeShellArray writes a statement that sets the variable name to an array
+// of the contexts in the syntax of shell (bash, zsh or fish).
+func writeShellArray(w io.Writer, shell, name string, kubeCtxs []string) error {
+	var s string
+	switch shell {
+	case "bash", "zsh":
+		s = name + "=(" + shellJoin(kubeCtxs) + ")"
+	case "fish":
+		quoted := make([]string, len(kubeCtxs))
+		for i, v := range kubeCtxs {
+			quoted[i] = fishQuote(v)
+		}
+		s = strings.Join(append([]string{"set", name}, quoted...), " ")
+	default:
+		return fmt.Errorf("unsupported shell %q (bash, zsh, fish)", shell)
+	}
+	_, err := io.WriteString(w, s+"\n")
+	return err
+}
+
+// shellJoin quotes and joins args to be used as a POSIX shell command.
+func shellJoin(args []string) string {
+	out := make([]string, len(args))
+	for i, v := range args {
+		out[i] = shellQuote(v)
+	}
+	return strings.Join(out, " ")
+}
+
+// shellQuote quotes s for POSIX shells, if it contains special characters.
+func shellQuote(s string) string {
+	if s == "" {
+		return "''"
+	}
+	safe := true
+	for _, r := range s {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("-_./=:,+@%", r)) {
+			safe = false
+			break
+		}
+	}
+	if safe {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+// fishQuote quotes s for the fish shell, if it contains special characters.
+func fishQuote(s string) string {
+	if q := shellQuote(s); q == s {
+		return s
+	}
+	return "'" + strings.NewReplacer(`\`, `\\`, "'", `\'`).Replace(s) + "'"
+}
@@ -0,0 +1,107 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_shellQuote(t *testing.T) {
+	assert.Equal(t, "''", shellQuote(""))
+	assert.Equal(t, "--context=a-b_c.d", shellQuote("--context=a-b_c.d"))
+	assert.Equal(t, "'a b'", shellQuote("a b"))
+	assert.Equal(t, `'it'\''s'`, shellQuote("it's"))
+	assert.Equal(t, "'$HOME'", shellQuote("$HOME"))
+}
+
+func Test_writeShellArray(t *testing.T) {
+	ctxs := []string{"a", "b c", `it's\`}
+	for shell, want := range map[string]string{
+		"bash": `contexts=(a 'b c' 'it'\''s\')` + "\n",
+		"zsh":  `contexts=(a 'b c' 'it'\''s\')` + "\n",
+		"fish": `set contexts a 'b c' 'it\'s\\'` + "\n",
+	} {
+		var b strings.Builder
+		require.NoError(t, writeShellArray(&b, shell, "contexts", ctxs))
+		assert.Equal(t, want, b.String(), shell)
+	}
+	assert.Error(t, writeShellArray(io.Discard, "csh", "contexts", ctxs))
+}
+
+func Test_writeShellArray_bash(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash not found")
+	}
+	var b strings.Builder
+	require.NoError(t, writeShellArray(&b, "bash", "contexts", []string{"a", "b c", "it's", "$x"}))
+	out, err := exec.Command("bash", "-c", b.String()+`printf '%s\n' "${contexts[@]}"`).CombinedOutput()
+	require.NoError(t, err, string(out))
+	assert.Equal(t, "a\nb c\nit's\n$x\n", string(out))
+}
+
+func Test_writeScript(t *testing.T) {
+	var b strings.Builder
+	err := writeScript(&b, []string{"a", "b c"}, foreach.ReplaceArgs([]string{"get", "pods", "-l", "app in (x)"}, ""), "-e")
+	require.NoError(t, err)
+	assert.Equal(t, `#!/bin/sh
+# Generated by kubectl foreach for context(s):
+#   - a
+#   - b c
+set -e
+kubectl --context=a get pods -l 'app in (x)'
+kubectl '--context=b c' get pods -l 'app in (x)'
+`, b.String())
+
+	b.Reset()
+	require.NoError(t, writeScript(&b, []string{"a"}, foreach.ReplaceArgs(nil, ""), ""))
+	assert.NotContains(t, b.String(), "set ")
+}
+
+func Test_emitScript(t *testing.T) {
+	if _, err := exec.LookPath("sh"); err != nil {
+		t.Skip("sh not found")
+	}
+	f := filepath.Join(t.TempDir(), "run.sh")
+	require.NoError(t, emitScript(f, []string{"a"}, foreach.ReplaceArgs([]string{"it's"}, ""), "-eu"))
+	fi, err := os.Stat(f)
+	require.NoError(t, err)
+	assert.NotZero(t, fi.Mode()&0o100, "script should be executable")
+
+	// the script has valid shell syntax
+	out, err := exec.Command("sh", "-n", f).CombinedOutput()
+	assert.NoError(t, err, string(out))
+}
+
+func Test_writeList(t *testing.T) {
+	var out, sample strings.Builder
+	require.NoError(t, writeList(&out, &sample, []string{"a", "b"}, foreach.ReplaceArgs([]string{"get", "pods", "-l", "app=x y"}, "")))
+	assert.Equal(t, "a\nb\n", out.String())
+	assert.Equal(t, "Command in a: kubectl --context=a get pods -l 'app=x y'\n", sample.String())
+
+	out.Reset()
+	sample.Reset()
+	require.NoError(t, writeList(&out, &sample, []string{"a"}, nil))
+	assert.Equal(t, "a\n", out.String())
+	assert.Empty(t, sample.String(), "no command")
+}
@@ -0,0 +1,95 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"gopkg.in/yaml.v3"
+)
+
+// selectSpec is the file from --select-file, listing patterns of contexts to
+// include and exclude, e.g.
+//
+//	include:
+//	  - /^prod-/
+//	  - cluster:/gke_/
+//	exclude:
+//	  - prod-legacy
+type selectSpec struct {
+	Include []specPattern `yaml:"include"`
+	Exclude []specPattern `yaml:"exclude"`
+}
+
+// specPattern is a pattern in a selectSpec, with its line in the file.
+type specPattern struct {
+	value string
+	line  int
+}
+
+func (p *specPattern) UnmarshalYAML(n *yaml.Node) error {
+	if n.Kind != yaml.ScalarNode {
+		return fmt.Errorf("line %d: pattern must be a string", n.Line)
+	}
+	p.value, p.line = n.Value, n.Line
+	return nil
+}
+
+// loadSelectFile reads the filters from a select file, see selectSpec.
+func loadSelectFile(path string) ([]foreach.Filter, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read select file: %w", err)
+	}
+	fs, err := parseSelectSpec(b)
+	if err != nil {
+		return nil, fmt.Errorf("invalid select file %s: %w", path, err)
+	}
+	return fs, nil
+}
+
+func parseSelectSpec(b []byte) ([]foreach.Filter, error) {
+	var spec selectSpec
+	dec := yaml.NewDecoder(bytes.NewReader(b))
+	dec.KnownFields(true)
+	if err := dec.Decode(&spec); err != nil && !errors.Is(err, io.EOF) {
+		return nil, err
+	}
+	var out []foreach.Filter
+	for _, p := range spec.Include {
+		f, err := foreach.ParseFilter(p.value, *ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+		out = append(out, f)
+	}
+	for _, p := range spec.Exclude {
+		if strings.HasPrefix(p.value, "^") {
+			return nil, fmt.Errorf("line %d: exclude pattern %q must not start with '^'", p.line, p.value)
+		}
+		f, err := foreach.ParseFilter("^"+p.value, *ignoreCase)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", p.line, err)
+		}
+		out = append(out, f)
+	}
+	return out, nil
+}
@@ -0,0 +1,68 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_parseSelectSpec(t *testing.T) {
+	fs, err := parseSelectSpec([]byte("include:\n  - /^prod-/\n  - dev\nexclude:\n  - prod-legacy\n  - /-canary$/\n"))
+	require.NoError(t, err)
+	got := foreach.MatchContexts([]string{"prod-us", "prod-legacy", "prod-eu-canary", "dev", "test"}, fs)
+	assert.Equal(t, []string{"prod-us", "dev"}, got)
+
+	fs, err = parseSelectSpec([]byte("exclude: [a]\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{"b"}, foreach.MatchContexts([]string{"a", "b"}, fs))
+
+	fs, err = parseSelectSpec(nil)
+	require.NoError(t, err)
+	assert.Empty(t, fs)
+}
+
+func Test_parseSelectSpec_errors(t *testing.T) {
+	for spec, want := range map[string]string{
+		"include:\n  - a\n  - /[/\n": "line 3: invalid pattern",
+		"include:\n  - {name: a}\n":  "line 2: pattern must be a string",
+		"exclude:\n  - ^a\n":         "line 2: exclude pattern \"^a\" must not start with '^'",
+		"includes:\n  - a\n":         "field includes not found",
+		"include: a\n":               "cannot unmarshal",
+		"include: [a\n":              "line 1",
+	} {
+		_, err := parseSelectSpec([]byte(spec))
+		if assert.Error(t, err, spec) {
+			assert.Contains(t, err.Error(), want, spec)
+		}
+	}
+}
+
+func Test_loadSelectFile(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "sel.yaml")
+	require.NoError(t, os.WriteFile(f, []byte("include: [\"/x/\"]\n"), 0o644))
+	fs, err := loadSelectFile(f)
+	require.NoError(t, err)
+	assert.Len(t, fs, 1)
+
+	require.NoError(t, os.WriteFile(f, []byte("exclude: [\"^x\"]\n"), 0o644))
+	_, err = loadSelectFile(f)
+	assert.ErrorContains(t, err, "sel.yaml: line 1")
+}
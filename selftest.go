@@ -0,0 +1,71 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// selftest runs a no-op command that prints a few lines in many fake contexts,
+// to measure the overhead of running and printing the output of contexts in
+// the tool itself, without kubectl or clusters.
+func selftest(args []string, w io.Writer) error {
+	fs := flag.NewFlagSet("selftest", flag.ContinueOnError)
+	n := fs.Int("n", 1000, "number of fake contexts")
+	parallel := fs.Int("c", 0, "parallel executions (0: unlimited)")
+	lines := fs.Int("lines", 10, "lines of output of each context")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if *n <= 0 || *parallel < 0 || *lines < 0 {
+		return fmt.Errorf("selftest: -n must be > 0, -c and -lines >= 0")
+	}
+
+	defer func(r foreach.Runner, c int) {
+		runCmd, *workers = r, c
+	}(runCmd, *workers)
+	runCmd = foreach.RunnerFunc(func(_ context.Context, args []string, _ io.Reader, stdout, _ io.Writer) error {
+		for i := 0; i < *lines; i++ {
+			if _, err := fmt.Fprintf(stdout, "%s line %d\n", args[0], i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	*workers = *parallel
+
+	ctxs := make([]string, *n)
+	for i := range ctxs {
+		ctxs[i] = fmt.Sprintf("selftest-%d", i)
+	}
+	start := time.Now()
+	_, err := runAll(context.Background(), ctxs, foreach.ReplaceArgs(nil, ""), nil, func(s string) string { return s },
+		nil, nil, nil, nil, nil, &synchronizedWriter{Writer: io.Discard}, io.Discard)
+	elapsed := time.Since(start)
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(w, "contexts:     %d (%d lines each, parallel: %d)\n", *n, *lines, *parallel)
+	fmt.Fprintf(w, "total:        %v\n", elapsed.Round(time.Microsecond))
+	fmt.Fprintf(w, "throughput:   %.0f contexts/s\n", float64(*n)/elapsed.Seconds())
+	fmt.Fprintf(w, "per context:  %v\n", (elapsed / time.Duration(*n)).Round(time.Nanosecond))
+	return nil
+}
@@ -0,0 +1,34 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_selftest(t *testing.T) {
+	var b strings.Builder
+	require.NoError(t, selftest([]string{"-n=50", "-c=4", "-lines=3"}, &b))
+	assert.Contains(t, b.String(), "contexts:     50 (3 lines each, parallel: 4)\n")
+	assert.Contains(t, b.String(), "throughput:")
+	assert.Equal(t, 0, *workers, "-c is restored")
+
+	assert.Error(t, selftest([]string{"-n=0"}, &b))
+	assert.Error(t, selftest([]string{"-x"}, &b))
+}
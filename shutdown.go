@@ -0,0 +1,84 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// shutdown coordinates stopping on interrupt signals. Without a drain timeout,
+// the first signal cancels the running commands right away. With a drain
+// timeout, the first signal only stops starting commands in more contexts, and
+// the running ones are cancelled on a second signal or when the drain timeout
+// expires.
+type shutdown struct {
+	drain context.Context // done once no more contexts should be started
+	kill  context.Context // done once the running commands should be cancelled
+
+	mu      sync.Mutex
+	expired bool // kill was caused by the drain timeout
+}
+
+// newShutdown starts handling the signals received on sig. Progress of the
+// shutdown is reported to w.
+func newShutdown(sig <-chan os.Signal, drainTimeout time.Duration, w io.Writer) *shutdown {
+	drain, stopDrain := context.WithCancel(context.Background())
+	kill, stopKill := context.WithCancel(context.Background())
+	s := &shutdown{drain: drain, kill: kill}
+	go func() {
+		<-sig
+		stopDrain()
+		if drainTimeout <= 0 {
+			fmt.Fprintln(w, gray("received exit signal"))
+			stopKill()
+			return
+		}
+		fmt.Fprintln(w, gray(fmt.Sprintf("received exit signal, waiting up to %v for running commands (interrupt again to cancel them)", drainTimeout)))
+		t := time.NewTimer(drainTimeout)
+		defer t.Stop()
+		select {
+		case <-sig:
+			fmt.Fprintln(w, gray("received exit signal again, cancelling running commands"))
+		case <-t.C:
+			s.mu.Lock()
+			s.expired = true
+			s.mu.Unlock()
+			fmt.Fprintln(w, gray("drain timeout expired, cancelling running commands"))
+		}
+		stopKill()
+	}()
+	return s
+}
+
+// draining reports whether contexts that haven't started must be skipped.
+func (s *shutdown) draining() bool {
+	return s != nil && s.drain.Err() != nil
+}
+
+// forced reports whether the running commands were cancelled because the
+// drain timeout expired.
+func (s *shutdown) forced() bool {
+	if s == nil {
+		return false
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.expired
+}
@@ -0,0 +1,65 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestShutdown_noDrainTimeout(t *testing.T) {
+	sig := make(chan os.Signal, 1)
+	s := newShutdown(sig, 0, io.Discard)
+	assert.False(t, s.draining())
+
+	sig <- os.Interrupt
+	<-s.kill.Done()
+	assert.True(t, s.draining())
+	assert.False(t, s.forced())
+}
+
+func TestShutdown_drainTimeout(t *testing.T) {
+	sig := make(chan os.Signal, 1)
+	s := newShutdown(sig, 50*time.Millisecond, io.Discard)
+
+	sig <- os.Interrupt
+	<-s.drain.Done()
+	assert.True(t, s.draining())
+	assert.NoError(t, s.kill.Err(), "running commands cancelled before the drain timeout")
+
+	<-s.kill.Done()
+	assert.True(t, s.forced())
+}
+
+func TestShutdown_secondSignal(t *testing.T) {
+	sig := make(chan os.Signal, 1)
+	s := newShutdown(sig, time.Hour, io.Discard)
+
+	sig <- os.Interrupt
+	<-s.drain.Done()
+	sig <- os.Interrupt
+	<-s.kill.Done()
+	assert.False(t, s.forced())
+}
+
+func TestShutdown_nil(t *testing.T) {
+	var s *shutdown
+	assert.False(t, s.draining())
+	assert.False(t, s.forced())
+}
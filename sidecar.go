@@ -0,0 +1,110 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// sidecarEntry is the line of JSON written to the --prefix-sidecar file for
+// each line of stdout.
+type sidecarEntry struct {
+	Line    int    `json:"line"`    // line number in stdout, from 1
+	Offset  int64  `json:"offset"`  // byte offset of the line in stdout
+	Length  int    `json:"length"`  // bytes of the line, including the newline
+	Context string `json:"context"` // context that printed the line
+}
+
+// sidecar records which context printed each line written to stdout without
+// a prefix.
+type sidecar struct {
+	mu     sync.Mutex
+	meta   *json.Encoder
+	line   int
+	offset int64
+}
+
+// prefixSidecar is the sidecar of --prefix-sidecar, if not nil.
+var prefixSidecar *sidecar
+
+func newSidecar(w io.Writer) *sidecar {
+	return &sidecar{meta: json.NewEncoder(w)}
+}
+
+// openSidecar creates the --prefix-sidecar file.
+func openSidecar(path string) (*sidecar, *os.File, error) {
+	f, err := os.Create(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create prefix sidecar: %w", err)
+	}
+	return newSidecar(f), f, nil
+}
+
+// write writes a line of a context to w and records it.
+func (s *sidecar) write(w io.Writer, context string, line []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	n, err := w.Write(line)
+	if n > 0 {
+		s.line++
+		if err := s.meta.Encode(sidecarEntry{Line: s.line, Offset: s.offset, Length: n, Context: context}); err != nil {
+			return fmt.Errorf("failed to write prefix sidecar: %w", err)
+		}
+		s.offset += int64(n)
+	}
+	return err
+}
+
+// sidecarWriter writes whole lines of a context to w without a prefix, and
+// records them in the sidecar.
+type sidecarWriter struct {
+	s       *sidecar
+	context string
+	w       io.Writer
+
+	buf bytes.Buffer
+}
+
+func (s *sidecarWriter) Write(p []byte) (int, error) {
+	n := len(p)
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '\n')
+		if i == -1 {
+			s.buf.Write(p)
+			break
+		}
+		s.buf.Write(p[:i+1])
+		if err := s.flush(); err != nil {
+			return 0, err
+		}
+		p = p[i+1:]
+	}
+	return n, nil
+}
+
+// flush writes the buffered line, e.g. the last line without a newline once
+// the command exits.
+func (s *sidecarWriter) flush() error {
+	if s.buf.Len() == 0 {
+		return nil
+	}
+	defer s.buf.Reset()
+	return s.s.write(s.w, s.context, s.buf.Bytes())
+}
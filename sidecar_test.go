@@ -0,0 +1,58 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func readSidecar(t *testing.T, b []byte) []sidecarEntry {
+	var out []sidecarEntry
+	s := bufio.NewScanner(bytes.NewReader(b))
+	for s.Scan() {
+		var e sidecarEntry
+		require.NoError(t, json.Unmarshal(s.Bytes(), &e))
+		out = append(out, e)
+	}
+	return out
+}
+
+func Test_sidecarWriter(t *testing.T) {
+	var out, meta bytes.Buffer
+	s := newSidecar(&meta)
+	a := &sidecarWriter{s: s, context: "a", w: &out}
+	b := &sidecarWriter{s: s, context: "b", w: &out}
+
+	io.WriteString(a, "hello ")
+	io.WriteString(b, "x\ny\n")
+	io.WriteString(a, "world\nlast")
+	require.NoError(t, a.flush())
+	require.NoError(t, b.flush(), "nothing buffered")
+
+	assert.Equal(t, "x\ny\nhello world\nlast", out.String(), "lines of contexts aren't interleaved")
+	assert.Equal(t, []sidecarEntry{
+		{Line: 1, Offset: 0, Length: 2, Context: "b"},
+		{Line: 2, Offset: 2, Length: 2, Context: "b"},
+		{Line: 3, Offset: 4, Length: 12, Context: "a"},
+		{Line: 4, Offset: 16, Length: 4, Context: "a"},
+	}, readSidecar(t, meta.Bytes()))
+}
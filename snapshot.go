@@ -0,0 +1,169 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+)
+
+// snapshot is the saved state of contexts in kubeconfig, keyed by name.
+type snapshot map[string]snapshotEntry
+
+type snapshotEntry struct {
+	Server string `json:"server"`
+	User   string `json:"user"`
+}
+
+func newSnapshot(kc map[string]contextInfo) snapshot {
+	out := make(snapshot, len(kc))
+	for name, c := range kc {
+		out[name] = snapshotEntry{Server: c.server, User: c.user}
+	}
+	return out
+}
+
+func loadSnapshot(path string) (snapshot, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read snapshot: %w", err)
+	}
+	var s snapshot
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse snapshot file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s snapshot) save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to save snapshot: %w", err)
+	}
+	return nil
+}
+
+// update returns the snapshot to save after a run: the contexts in results
+// that succeeded get their state in cur, and the other contexts in cur keep
+// their state in s (or are left out if s doesn't have them), so that they are
+// still added or changed since the new snapshot if they failed or didn't run.
+// Contexts not in cur are removed.
+func (s snapshot) update(cur snapshot, results []result) snapshot {
+	succeeded := make(map[string]bool, len(results))
+	for _, r := range results {
+		if r.status() == statusSucceeded {
+			succeeded[r.context] = true
+		}
+	}
+	out := make(snapshot, len(cur))
+	for name, c := range cur {
+		if succeeded[name] {
+			out[name] = c
+		} else if o, ok := s[name]; ok {
+			out[name] = o
+		}
+	}
+	return out
+}
+
+// snapshotDiff lists the names of contexts that differ between two snapshots.
+type snapshotDiff struct {
+	added, removed, changed []string
+}
+
+func diffSnapshots(old, cur snapshot) snapshotDiff {
+	var d snapshotDiff
+	for name, c := range cur {
+		o, ok := old[name]
+		if !ok {
+			d.added = append(d.added, name)
+		} else if o != c {
+			d.changed = append(d.changed, name)
+		}
+	}
+	for name := range old {
+		if _, ok := cur[name]; !ok {
+			d.removed = append(d.removed, name)
+		}
+	}
+	sort.Strings(d.added)
+	sort.Strings(d.removed)
+	sort.Strings(d.changed)
+	return d
+}
+
+// targets returns the contexts in kubeCtxs that are added or changed.
+func (d snapshotDiff) targets(kubeCtxs []string) []string {
+	m := make(map[string]bool)
+	for _, v := range d.added {
+		m[v] = true
+	}
+	for _, v := range d.changed {
+		m[v] = true
+	}
+	var out []string
+	for _, v := range kubeCtxs {
+		if m[v] {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// readExpectedContexts reads the --expect-contexts-file, in the format of
+// --order-file (e.g. the output of --list).
+func readExpectedContexts(path string) ([]string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read expected contexts: %w", err)
+	}
+	names, err := parseContextNames(b)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse expected contexts file %s: %w", path, err)
+	}
+	return names, nil
+}
+
+// diffContexts lists the contexts matched but not expected as added, and the
+// ones expected but not matched as removed.
+func diffContexts(expected, matched []string) snapshotDiff {
+	d := snapshotDiff{added: missingContexts(matched, expected), removed: missingContexts(expected, matched)}
+	sort.Strings(d.added)
+	sort.Strings(d.removed)
+	return d
+}
+
+func (d snapshotDiff) empty() bool {
+	return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+// write lists the added, changed and removed contexts.
+func (d snapshotDiff) write(w io.Writer) {
+	for _, v := range d.added {
+		fmt.Fprintf(w, "%s", gray(fmt.Sprintf("  + %s (added)\n", v)))
+	}
+	for _, v := range d.changed {
+		fmt.Fprintf(w, "%s", gray(fmt.Sprintf("  ~ %s (changed)\n", v)))
+	}
+	for _, v := range d.removed {
+		fmt.Fprintf(w, "%s", gray(fmt.Sprintf("  - %s (removed)\n", v)))
+	}
+}
@@ -0,0 +1,113 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSnapshotSaveLoad(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "snapshot.json")
+	s := newSnapshot(map[string]contextInfo{
+		"a": {name: "a", user: "u1", server: "https://a"},
+	})
+	require.NoError(t, s.save(f))
+	got, err := loadSnapshot(f)
+	require.NoError(t, err)
+	assert.Equal(t, snapshot{"a": {Server: "https://a", User: "u1"}}, got)
+
+	_, err = loadSnapshot(filepath.Join(t.TempDir(), "missing.json"))
+	assert.Error(t, err)
+}
+
+func Test_diffSnapshots(t *testing.T) {
+	old := snapshot{
+		"same":    {Server: "https://same"},
+		"changed": {Server: "https://old"},
+		"removed": {Server: "https://removed"},
+	}
+	cur := snapshot{
+		"same":    {Server: "https://same"},
+		"changed": {Server: "https://new"},
+		"added":   {Server: "https://added"},
+	}
+	d := diffSnapshots(old, cur)
+	assert.Equal(t, snapshotDiff{
+		added:   []string{"added"},
+		removed: []string{"removed"},
+		changed: []string{"changed"},
+	}, d)
+	assert.Equal(t, []string{"changed", "added"}, d.targets([]string{"same", "changed", "added"}))
+	assert.Empty(t, diffSnapshots(cur, cur).targets([]string{"same", "changed", "added"}))
+}
+
+func Test_diffContexts(t *testing.T) {
+	d := diffContexts([]string{"a", "b", "c"}, []string{"c", "d", "a"})
+	assert.Equal(t, []string{"d"}, d.added)
+	assert.Equal(t, []string{"b"}, d.removed)
+	assert.False(t, d.empty())
+	assert.True(t, diffContexts([]string{"a", "b"}, []string{"b", "a"}).empty(), "order doesn't matter")
+
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+	var b strings.Builder
+	d.write(&b)
+	assert.Equal(t, "  + d (added)\n  - b (removed)\n", b.String())
+}
+
+func Test_readExpectedContexts(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "expected")
+	require.NoError(t, os.WriteFile(f, []byte("# approved in CHG-123\nprod-us\nprod-eu\n"), 0o644))
+	names, err := readExpectedContexts(f)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"prod-us", "prod-eu"}, names)
+
+	_, err = readExpectedContexts(filepath.Join(t.TempDir(), "missing"))
+	assert.Error(t, err)
+}
+
+func TestSnapshotUpdate(t *testing.T) {
+	old := snapshot{
+		"ok":     {Server: "https://old"},
+		"failed": {Server: "https://old"},
+		"gone":   {Server: "https://gone"},
+	}
+	cur := snapshot{
+		"ok":       {Server: "https://new"},
+		"failed":   {Server: "https://new"},
+		"new-fail": {Server: "https://new"},
+		"not-run":  {Server: "https://new"},
+		"new-ok":   {Server: "https://new"},
+	}
+	results := []result{
+		{context: "ok"},
+		{context: "failed", err: errors.New("exit status 1")},
+		{context: "new-fail", err: errors.New("exit status 1")},
+		{context: "new-ok"},
+	}
+	assert.Equal(t, snapshot{
+		"ok":     {Server: "https://new"},
+		"failed": {Server: "https://old"},
+		"new-ok": {Server: "https://new"},
+	}, old.update(cur, results))
+}
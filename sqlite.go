@@ -0,0 +1,89 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// sqliteBin is the sqlite3 command-line shell that --sqlite writes with, so
+// that the tool doesn't need a (cgo) SQLite driver.
+const sqliteBin = "sqlite3"
+
+const sqliteSchema = `CREATE TABLE IF NOT EXISTS results (
+  run_id TEXT NOT NULL,
+  time TEXT NOT NULL,
+  context TEXT NOT NULL,
+  command TEXT NOT NULL,
+  status TEXT NOT NULL,
+  exit_code INTEGER NOT NULL,
+  duration_ms INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS results_context ON results (context, time);
+`
+
+// newRunID returns an id for the results of a run, starting with its time so
+// that ids sort in the order of the runs.
+func newRunID(now time.Time) string {
+	b := make([]byte, 4)
+	_, _ = rand.Read(b)
+	return now.UTC().Format("20060102T150405Z") + "-" + hex.EncodeToString(b)
+}
+
+// writeSQL writes the SQL statements that create the results table (if it
+// doesn't exist) and insert the results of the run into it, in a transaction.
+func writeSQL(w io.Writer, runID string, now time.Time, results []result, argMaker func(string) []string) error {
+	var b strings.Builder
+	b.WriteString(sqliteSchema)
+	b.WriteString("BEGIN;\n")
+	for _, r := range results {
+		cmd := shellJoin(commandArgv(wrapper, argMaker(r.context)))
+		fmt.Fprintf(&b, "INSERT INTO results VALUES (%s, %s, %s, %s, %s, %d, %d);\n",
+			sqlQuote(runID), sqlQuote(now.UTC().Format(time.RFC3339Nano)), sqlQuote(r.context), sqlQuote(cmd),
+			sqlQuote(r.status()), r.exitCode(), r.duration.Milliseconds())
+	}
+	b.WriteString("COMMIT;\n")
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// sqlQuote quotes s as an SQL string literal.
+func sqlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// saveSQLite appends the results of the run to the database at path with the
+// sqlite3 shell, creating the database and the table if missing.
+func saveSQLite(ctx context.Context, path, runID string, now time.Time, results []result, argMaker func(string) []string) error {
+	var sql bytes.Buffer
+	if err := writeSQL(&sql, runID, now, results, argMaker); err != nil {
+		return err
+	}
+	cmd := exec.CommandContext(ctx, sqliteBin, "-bail", path)
+	cmd.Stdin = &sql
+	if out, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to write results to sqlite database %s: %w: %s", path, err,
+			strings.TrimSpace(string(out)))
+	}
+	return nil
+}
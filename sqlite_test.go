@@ -0,0 +1,88 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestNewRunID(t *testing.T) {
+	now := time.Date(2022, 5, 1, 10, 30, 0, 0, time.UTC)
+	id := newRunID(now)
+	assert.Regexp(t, `^20220501T103000Z-[0-9a-f]{8}$`, id)
+	assert.NotEqual(t, id, newRunID(now))
+}
+
+func TestWriteSQL(t *testing.T) {
+	now := time.Date(2022, 5, 1, 10, 30, 0, 0, time.UTC)
+	results := []result{
+		{context: "a", duration: 1500 * time.Millisecond},
+		{context: "it's", err: errors.New("exit status 1"), duration: time.Second},
+	}
+	var buf bytes.Buffer
+	require.NoError(t, writeSQL(&buf, "r1", now, results, foreach.ReplaceArgs([]string{"get", "pods"}, "")))
+	assert.Equal(t, sqliteSchema+`BEGIN;
+INSERT INTO results VALUES ('r1', '2022-05-01T10:30:00Z', 'a', 'kubectl --context=a get pods', 'succeeded', 0, 1500);
+INSERT INTO results VALUES ('r1', '2022-05-01T10:30:00Z', 'it''s', 'kubectl ''--context=it''\''''s'' get pods', 'failed', -1, 1000);
+COMMIT;
+`, buf.String())
+
+	// the command as it's run, like in --list and --emit-script
+	defer func(v []string) { wrapper = v }(wrapper)
+	defer func(v string) { kubeconfigPath = v }(kubeconfigPath)
+	wrapper, kubeconfigPath = []string{"time"}, "/tmp/kc.yaml"
+	buf.Reset()
+	require.NoError(t, writeSQL(&buf, "r1", now, results[:1], foreach.ReplaceArgs([]string{"get", "pods"}, "")))
+	assert.Contains(t, buf.String(), "'a', 'time kubectl --kubeconfig=/tmp/kc.yaml --context=a get pods', 'succeeded'")
+}
+
+func TestSaveSQLite(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("needs sh")
+	}
+	dir := t.TempDir()
+	// a fake sqlite3 that saves its arguments and input
+	require.NoError(t, os.WriteFile(filepath.Join(dir, "sqlite3"), []byte(`#!/bin/sh
+echo "$@" > `+dir+`/args
+cat > `+dir+`/sql
+case "$2" in *bad*) echo "Error: unable to open database" >&2; exit 1;; esac`), 0o755))
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	now := time.Now()
+	results := []result{{context: "a"}}
+	args := foreach.ReplaceArgs([]string{"get"}, "")
+	require.NoError(t, saveSQLite(context.Background(), "runs.db", "r1", now, results, args))
+	b, err := os.ReadFile(filepath.Join(dir, "args"))
+	require.NoError(t, err)
+	assert.Equal(t, "-bail runs.db\n", string(b))
+	b, err = os.ReadFile(filepath.Join(dir, "sql"))
+	require.NoError(t, err)
+	assert.Contains(t, string(b), "INSERT INTO results VALUES ('r1'")
+
+	err = saveSQLite(context.Background(), "bad.db", "r1", now, results, args)
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "unable to open database")
+}
@@ -0,0 +1,97 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"sort"
+	"time"
+)
+
+// runState is the outcome of the last run in each context, persisted across
+// runs in the --state-file.
+type runState map[string]contextState
+
+type contextState struct {
+	Status     string    `json:"status"`
+	Reason     string    `json:"reason,omitempty"`
+	ExitCode   int       `json:"exitCode"`
+	DurationMs int64     `json:"durationMs"`
+	Time       time.Time `json:"time"`
+	OutputHash string    `json:"outputHash,omitempty"`
+}
+
+// loadState reads the state file. A missing file is an empty state.
+func loadState(path string) (runState, error) {
+	b, err := os.ReadFile(path)
+	if errors.Is(err, fs.ErrNotExist) {
+		return runState{}, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("failed to read state file: %w", err)
+	}
+	s := runState{}
+	if err := json.Unmarshal(b, &s); err != nil {
+		return nil, fmt.Errorf("failed to parse state file %s: %w", path, err)
+	}
+	return s, nil
+}
+
+func (s runState) save(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, append(b, '\n'), 0o644); err != nil {
+		return fmt.Errorf("failed to save state file: %w", err)
+	}
+	return nil
+}
+
+// update records the results of commands that have run.
+func (s runState) update(results []result, now time.Time) {
+	for _, r := range results {
+		if r.context == "" || r.status() == statusSkipped {
+			continue
+		}
+		s[r.context] = contextState{
+			Status:     r.status(),
+			Reason:     r.reason,
+			ExitCode:   r.exitCode(),
+			DurationMs: r.duration.Milliseconds(),
+			Time:       now,
+			OutputHash: r.hash,
+		}
+	}
+}
+
+// orderByDuration returns the contexts sorted by the duration of their last
+// run, fastest first. Contexts with no recorded run keep their relative order
+// and are placed last, or first if missingFirst is set.
+func orderByDuration(kubeCtxs []string, s runState, missingFirst bool) []string {
+	out := append([]string(nil), kubeCtxs...)
+	sort.SliceStable(out, func(i, j int) bool {
+		a, aok := s[out[i]]
+		b, bok := s[out[j]]
+		if aok != bok {
+			return aok != missingFirst
+		}
+		return aok && a.DurationMs < b.DurationMs
+	})
+	return out
+}
@@ -0,0 +1,61 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRunState(t *testing.T) {
+	f := filepath.Join(t.TempDir(), "state.json")
+	s, err := loadState(f)
+	require.NoError(t, err, "missing file should be empty state")
+	assert.Empty(t, s)
+
+	now := time.Date(2022, 1, 2, 3, 4, 5, 0, time.UTC)
+	s.update([]result{
+		{context: "a", duration: 2 * time.Second},
+		{context: "b", duration: time.Second, err: errors.New("phony error"), reason: foreach.ReasonNotFound},
+		{context: "c", err: fmt.Errorf("wrapped: %w", foreach.ErrSkipped)},
+	}, now)
+	require.NoError(t, s.save(f))
+
+	got, err := loadState(f)
+	require.NoError(t, err)
+	assert.Equal(t, runState{
+		"a": {Status: statusSucceeded, ExitCode: 0, DurationMs: 2000, Time: now},
+		"b": {Status: statusFailed, Reason: foreach.ReasonNotFound, ExitCode: -1, DurationMs: 1000, Time: now},
+	}, got)
+}
+
+func Test_orderByDuration(t *testing.T) {
+	s := runState{
+		"slow": {DurationMs: 3000},
+		"fast": {DurationMs: 100},
+		"mid":  {DurationMs: 1000},
+	}
+	in := []string{"new1", "slow", "fast", "new2", "mid"}
+	assert.Equal(t, []string{"fast", "mid", "slow", "new1", "new2"}, orderByDuration(in, s, false))
+	assert.Equal(t, []string{"new1", "new2", "fast", "mid", "slow"}, orderByDuration(in, s, true))
+	assert.Equal(t, []string{"new1", "slow", "fast", "new2", "mid"}, in, "input should not be modified")
+}
@@ -0,0 +1,62 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"net"
+	"strings"
+)
+
+const statsdPrefix = "kubectl_foreach."
+
+// statsdClient sends metrics in the StatsD line protocol (with DogStatsD
+// tags) without waiting for, or reporting, delivery. A nil *statsdClient
+// sends nothing.
+type statsdClient struct {
+	w io.Writer
+}
+
+func newStatsdClient(addr string) (*statsdClient, error) {
+	conn, err := net.Dial("udp", addr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to set up statsd client: %w", err)
+	}
+	return &statsdClient{w: conn}, nil
+}
+
+// observe sends the metrics for the result of a context.
+func (s *statsdClient) observe(r result, verb string) {
+	if s == nil {
+		return
+	}
+	tags := "|#context:" + statsdTag(r.context)
+	if verb != "" {
+		tags += ",verb:" + statsdTag(verb)
+	}
+	counter := "contexts.success"
+	if r.err != nil {
+		counter = "contexts.failure"
+	}
+	// each metric is a separate packet, as servers may not support multi-metric packets
+	_, _ = fmt.Fprintf(s.w, "%s%s:1|c%s", statsdPrefix, counter, tags)
+	_, _ = fmt.Fprintf(s.w, "%scontext.duration:%d|ms%s", statsdPrefix, r.duration.Milliseconds(), tags)
+}
+
+// statsdTag replaces characters that are not allowed in tag values.
+func statsdTag(s string) string {
+	return strings.NewReplacer("|", "_", ",", "_", "#", "_", "\n", "_").Replace(s)
+}
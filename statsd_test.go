@@ -0,0 +1,66 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type packetRecorder struct{ packets []string }
+
+func (p *packetRecorder) Write(b []byte) (int, error) {
+	p.packets = append(p.packets, string(b))
+	return len(b), nil
+}
+
+func TestStatsdClient(t *testing.T) {
+	t.Run("nil", func(t *testing.T) {
+		var s *statsdClient
+		s.observe(result{context: "a"}, "get")
+	})
+	t.Run("metrics", func(t *testing.T) {
+		rec := &packetRecorder{}
+		s := &statsdClient{w: rec}
+		s.observe(result{context: "a", duration: 1500 * time.Millisecond}, "get")
+		s.observe(result{context: "b|c", err: errors.New("phony error")}, "")
+		assert.Equal(t, []string{
+			"kubectl_foreach.contexts.success:1|c|#context:a,verb:get",
+			"kubectl_foreach.context.duration:1500|ms|#context:a,verb:get",
+			"kubectl_foreach.contexts.failure:1|c|#context:b_c",
+			"kubectl_foreach.context.duration:0|ms|#context:b_c",
+		}, rec.packets)
+	})
+	t.Run("udp", func(t *testing.T) {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		require.NoError(t, err)
+		defer pc.Close()
+
+		s, err := newStatsdClient(pc.LocalAddr().String())
+		require.NoError(t, err)
+		s.observe(result{context: "a"}, "get")
+
+		require.NoError(t, pc.SetReadDeadline(time.Now().Add(time.Second)))
+		buf := make([]byte, 1024)
+		n, _, err := pc.ReadFrom(buf)
+		require.NoError(t, err)
+		assert.Equal(t, "kubectl_foreach.contexts.success:1|c|#context:a,verb:get", string(buf[:n]))
+	})
+}
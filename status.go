@@ -0,0 +1,155 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// commandFailed reports whether err is from the command failing in contexts,
+// rather than the tool failing to run it.
+func commandFailed(err error) bool {
+	return err != nil && exitCode(err) >= exitCommandFailed && exitCode(err) <= exitManyFailed
+}
+
+// contextFailures is the error returned from runAll with the results of all
+// contexts that failed. It's a foreach.Failures, with the results of runAll.
+type contextFailures []result
+
+// library returns the failures as the foreach.Failures returned from
+// foreach.Run, which formats and unwraps them.
+func (f contextFailures) library() foreach.Failures {
+	out := make(foreach.Failures, len(f))
+	for i, r := range f {
+		out[i] = foreach.Result{Context: r.context, ExitCode: r.exitCode(), Err: r.err}
+	}
+	return out
+}
+
+func (f contextFailures) Error() string { return f.library().Error() }
+
+// Is reports whether the error of any failed context is target.
+func (f contextFailures) Is(target error) bool { return f.library().Is(target) }
+
+// As finds the first error of a failed context that matches target.
+func (f contextFailures) As(target interface{}) bool { return f.library().As(target) }
+
+// failures returns the contexts that failed (or were skipped) as an error, or
+// nil if there are none. Unreachable contexts are not counted.
+func failures(results []result) error {
+	var out contextFailures
+	for _, r := range results {
+		if r.err != nil && r.status() != statusUnreachable {
+			out = append(out, r)
+		}
+	}
+	if len(out) == 0 {
+		return nil
+	}
+	return out
+}
+
+// exitCodes is a set of process exit codes that implements flag.Value. Exit
+// code 0 is always in the set.
+type exitCodes map[int]bool
+
+func (e exitCodes) String() string {
+	v := e.codes()
+	out := make([]string, len(v))
+	for i := range v {
+		out[i] = strconv.Itoa(v[i])
+	}
+	return strings.Join(out, ",")
+}
+
+func (e *exitCodes) Set(s string) error {
+	out := exitCodes{0: true}
+	for _, v := range splitList(s) {
+		c, err := strconv.Atoi(v)
+		if err != nil || c < 0 {
+			return fmt.Errorf("invalid exit code %q", v)
+		}
+		out[c] = true
+	}
+	*e = out
+	return nil
+}
+
+// codes returns the exit codes in the set.
+func (e exitCodes) codes() []int {
+	out := make([]int, 0, len(e))
+	for c := range e {
+		out = append(out, c)
+	}
+	sort.Ints(out)
+	return out
+}
+
+// successThreshold is the number (or percentage) of contexts that must
+// succeed for the run to succeed. It implements flag.Value. The zero value
+// requires all contexts to succeed.
+type successThreshold struct {
+	value   float64
+	percent bool
+}
+
+func (s successThreshold) String() string {
+	if s.value == 0 {
+		return ""
+	}
+	v := strconv.FormatFloat(s.value, 'f', -1, 64)
+	if s.percent {
+		return v + "%"
+	}
+	return v
+}
+
+func (s *successThreshold) Set(v string) error {
+	out := successThreshold{}
+	if strings.HasSuffix(v, "%") {
+		out.percent = true
+		v = strings.TrimSuffix(v, "%")
+	}
+	f, err := strconv.ParseFloat(v, 64)
+	if err != nil || f <= 0 || (out.percent && f > 100) || (!out.percent && f != float64(int(f))) {
+		return fmt.Errorf("invalid threshold %q: need a positive number of contexts or a percentage up to 100%%", v)
+	}
+	out.value = f
+	*s = out
+	return nil
+}
+
+// met reports whether enough of the contexts succeeded. It's false if the
+// threshold is not set.
+func (s successThreshold) met(results []result) bool {
+	if s.value == 0 || len(results) == 0 {
+		return false
+	}
+	ok := 0
+	for _, r := range results {
+		if r.status() == statusSucceeded {
+			ok++
+		}
+	}
+	if s.percent {
+		return float64(ok)*100 >= s.value*float64(len(results))
+	}
+	return float64(ok) >= s.value
+}
@@ -0,0 +1,106 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_failures(t *testing.T) {
+	exitErr := exec.Command("sh", "-c", "exit 3").Run()
+	failed := func(ctx string, err error) result {
+		return result{context: ctx, err: fmt.Errorf("command failed in context %q: %w", ctx, err)}
+	}
+	assert.NoError(t, failures([]result{{context: "a"}}))
+	assert.NoError(t, failures([]result{{context: "a", err: fmt.Errorf("context %q: %w", "a", foreach.ErrUnreachable)}}))
+
+	err := failures([]result{{context: "a"}, failed("b", exitErr)})
+	assert.EqualError(t, err, `command failed in context "b": exit status 3`)
+	assert.Equal(t, exitCommandFailed, exitCode(err))
+	assert.True(t, commandFailed(err))
+
+	err = failures([]result{
+		failed("a", exitErr),
+		{context: "b", err: fmt.Errorf("context %q: %w", "b", foreach.ErrSkipped)},
+		{context: "c", err: fmt.Errorf("command printed nothing in context %q: %w", "c", foreach.ErrEmptyOutput)},
+	})
+	assert.EqualError(t, err, "command failed in 3 contexts: a (exit code 3), b (skipped), c (empty output)")
+	assert.Equal(t, 4, exitCode(err))
+	assert.ErrorIs(t, err, foreach.ErrSkipped)
+	var ee *exec.ExitError
+	assert.ErrorAs(t, err, &ee)
+
+	var many []result
+	for i := 0; i < 200; i++ {
+		many = append(many, failed(fmt.Sprint(i), exitErr))
+	}
+	assert.Equal(t, exitManyFailed, exitCode(failures(many)))
+
+	execErr := exec.Command("kubectl-foreach-nonexistent-binary").Run()
+	err = failures([]result{failed("a", exitErr), failed("b", execErr)})
+	assert.Equal(t, exitSetupError, exitCode(err), "kubectl could not be started")
+	assert.False(t, commandFailed(err))
+}
+
+func Test_exitCodes(t *testing.T) {
+	t.Run("parse", func(t *testing.T) {
+		var e exitCodes
+		assert.NoError(t, e.Set("1, 0"))
+		assert.Equal(t, exitCodes{0: true, 1: true}, e)
+		assert.Equal(t, "0,1", e.String())
+
+		assert.NoError(t, e.Set("2"))
+		assert.Equal(t, exitCodes{0: true, 2: true}, e)
+
+		assert.Error(t, e.Set("a"))
+		assert.Error(t, e.Set("-1"))
+	})
+
+	t.Run("codes", func(t *testing.T) {
+		assert.Equal(t, []int{0, 1, 3}, exitCodes{3: true, 0: true, 1: true}.codes())
+	})
+}
+
+func Test_successThreshold(t *testing.T) {
+	var s successThreshold
+	require.NoError(t, s.Set("90%"))
+	assert.Equal(t, successThreshold{value: 90, percent: true}, s)
+	assert.Equal(t, "90%", s.String())
+	require.NoError(t, s.Set("3"))
+	assert.Equal(t, successThreshold{value: 3}, s)
+	assert.Equal(t, "3", s.String())
+
+	for _, v := range []string{"", "x", "0", "-1", "101%", "1.5", "%"} {
+		assert.Error(t, s.Set(v), v)
+	}
+
+	failed := exec.Command("sh", "-c", "exit 1").Run()
+	results := []result{{context: "a"}, {context: "b"}, {context: "c"}, {context: "d", err: failed}}
+	for _, tc := range []struct {
+		in   string
+		want bool
+	}{{"75%", true}, {"76%", false}, {"3", true}, {"4", false}} {
+		var s successThreshold
+		require.NoError(t, s.Set(tc.in))
+		assert.Equal(t, tc.want, s.met(results), tc.in)
+	}
+	assert.False(t, successThreshold{}.met(results), "unset")
+}
@@ -0,0 +1,41 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"strings"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+)
+
+// replaceInput returns the input of each context for --replace-stdin, with
+// foreach.ContextPlaceholder and the non-empty repl tokens in it replaced with
+// the context name.
+func replaceInput(in []byte, repl []string) func(kctx string) []byte {
+	tokens := []string{foreach.ContextPlaceholder}
+	for _, r := range repl {
+		if r != "" {
+			tokens = append(tokens, r)
+		}
+	}
+	s := string(in)
+	return func(kctx string) []byte {
+		pairs := make([]string, 0, 2*len(tokens))
+		for _, t := range tokens {
+			pairs = append(pairs, t, kctx)
+		}
+		return []byte(strings.NewReplacer(pairs...).Replace(s))
+	}
+}
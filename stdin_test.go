@@ -0,0 +1,29 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_replaceInput(t *testing.T) {
+	in := []byte("name: {{context}}\nnamespace: team-_\n")
+	assert.Equal(t, "name: a\nnamespace: team-_\n", string(replaceInput(in, nil)("a")))
+	assert.Equal(t, "name: a\nnamespace: team-a\n", string(replaceInput(in, []string{"", "_"})("a")))
+	assert.Equal(t, "name: b\nnamespace: team-b\n", string(replaceInput(in, []string{"_"})("b")))
+	assert.Equal(t, "", string(replaceInput(nil, []string{"_"})("a")))
+}
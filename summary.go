@@ -0,0 +1,118 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// writeSummary writes a table of the status, exit code and duration of the
+// command in each context, the number of attempts if any context was retried
+// with --retries, the start of the output hash with --output-hash, and the
+// failure reason if any context failed with --classify-failures.
+func writeSummary(w io.Writer, results []result) error {
+	names := make([]string, len(results))
+	durations := make([]string, len(results))
+	var hashes, retried, reasons bool
+	for i, r := range results {
+		names[i] = r.context
+		durations[i] = r.duration.Round(time.Millisecond).String()
+		hashes = hashes || r.hash != ""
+		retried = retried || r.attempts > 1
+		reasons = reasons || r.reason != ""
+	}
+	ctxWidth := maxLen(append(names, "CONTEXT"))
+	statusWidth := len(statusUnreachable)
+	durWidth := maxLen(append(durations, "DURATION"))
+	header := []string{fmt.Sprintf("%-*s", ctxWidth, "CONTEXT"), fmt.Sprintf("%-*s", statusWidth, "STATUS"), "EXIT"}
+	if retried {
+		header = append(header, "ATTEMPTS")
+	}
+	header = append(header, "DURATION")
+	if hashes {
+		header = append(header, "HASH")
+	}
+	if reasons {
+		header = append(header, "REASON")
+	}
+	// the duration and hash columns are padded, unless they are the last one
+	durCol := len(header) - 1
+	if hashes {
+		durCol--
+	}
+	if reasons {
+		durCol--
+	}
+	pad := func(cols []string) []string {
+		if durCol < len(cols)-1 {
+			cols[durCol] = fmt.Sprintf("%-*s", durWidth, cols[durCol])
+		}
+		if hashes && reasons {
+			cols[durCol+1] = fmt.Sprintf("%-*s", summaryHashLen, cols[durCol+1])
+		}
+		return cols
+	}
+	var b strings.Builder
+	b.WriteString(strings.Join(pad(header), "  ") + "\n")
+	for i, r := range results {
+		// padded before coloring, so that escape codes don't misalign the columns
+		status := fmt.Sprintf("%-*s", statusWidth, r.status())
+		switch r.status() {
+		case statusSucceeded:
+			status = chalk.Green(status)
+		case statusFailed:
+			status = red(status)
+		default:
+			status = gray(status)
+		}
+		code := "-"
+		if c := r.exitCode(); c >= 0 {
+			code = strconv.Itoa(c)
+		}
+		row := []string{fmt.Sprintf("%-*s", ctxWidth, r.context), status, fmt.Sprintf("%-4s", code)}
+		if retried {
+			attempts := "-"
+			if r.attempts > 0 {
+				attempts = strconv.Itoa(r.attempts)
+			}
+			row = append(row, fmt.Sprintf("%-8s", attempts))
+		}
+		row = append(row, durations[i])
+		if hashes {
+			hash := "-"
+			if len(r.hash) >= summaryHashLen {
+				hash = r.hash[:summaryHashLen]
+			}
+			row = append(row, hash)
+		}
+		if reasons {
+			reason := "-"
+			if r.reason != "" {
+				reason = r.reason
+			}
+			row = append(row, reason)
+		}
+		b.WriteString(strings.Join(pad(row), "  ") + "\n")
+	}
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// summaryHashLen is the number of hex digits of output hashes in the summary.
+const summaryHashLen = 12
@@ -0,0 +1,113 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_writeSummary(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var b strings.Builder
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "prod-us", duration: 1234 * time.Millisecond},
+		{context: "b", err: fmt.Errorf("context %q: %w", "b", foreach.ErrSkipped)},
+	}))
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  DURATION\n"+
+		"prod-us  succeeded    0     1.234s\n"+
+		"b        skipped      -     0s\n", b.String())
+}
+
+func Test_writeSummary_hash(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var b strings.Builder
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "a", duration: 1234 * time.Millisecond, hash: "0123456789abcdef0123"},
+		{context: "b", err: fmt.Errorf("context %q: %w", "b", foreach.ErrSkipped)},
+	}))
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  DURATION  HASH\n"+
+		"a        succeeded    0     1.234s    0123456789ab\n"+
+		"b        skipped      -     0s        -\n", b.String())
+}
+
+func Test_writeSummary_colors(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelBasic)
+
+	var b strings.Builder
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "a"},
+		{context: "b", err: fmt.Errorf("command failed in context %q: %w", "b", fmt.Errorf("boom"))},
+	}))
+	assert.Contains(t, b.String(), red("failed     "))
+	plain := regexp.MustCompile("\x1b\\[[0-9;]*m").ReplaceAllString(b.String(), "")
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  DURATION\n"+
+		"a        succeeded    0     0s\n"+
+		"b        failed       -     0s\n", plain)
+}
+
+func Test_writeSummary_attempts(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var b strings.Builder
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "a", duration: 1234 * time.Millisecond, attempts: 3},
+		{context: "b", attempts: 1},
+		{context: "c", err: fmt.Errorf("context %q: %w", "c", foreach.ErrSkipped)},
+	}))
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  ATTEMPTS  DURATION\n"+
+		"a        succeeded    0     3         1.234s\n"+
+		"b        succeeded    0     1         0s\n"+
+		"c        skipped      -     -         0s\n", b.String())
+}
+
+func Test_writeSummary_reason(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var b strings.Builder
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "a", duration: 1234 * time.Millisecond},
+		{context: "b", err: fmt.Errorf("context %q: %w", "b", foreach.ErrTimeout), reason: "timeout"},
+	}))
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  DURATION  REASON\n"+
+		"a        succeeded    0     1.234s    -\n"+
+		"b        failed       -     0s        timeout\n", b.String())
+
+	b.Reset()
+	require.NoError(t, writeSummary(&b, []result{
+		{context: "a", duration: 1234 * time.Millisecond, hash: "0123456789abcdef0123"},
+		{context: "b", err: fmt.Errorf("boom"), reason: "forbidden", hash: "fedcba9876543210fedc"},
+		{context: "c", err: fmt.Errorf("context %q: %w", "c", foreach.ErrSkipped)},
+	}))
+	assert.Equal(t, "CONTEXT  STATUS       EXIT  DURATION  HASH          REASON\n"+
+		"a        succeeded    0     1.234s    0123456789ab  -\n"+
+		"b        failed       -     0s        fedcba987654  forbidden\n"+
+		"c        skipped      -     0s        -             -\n", b.String())
+}
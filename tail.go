@@ -0,0 +1,164 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// tailBuffer keeps the last lines written to it.
+type tailBuffer struct {
+	mu      sync.Mutex
+	lines   []string // ring buffer
+	next    int      // index of the oldest line, once the buffer is full
+	partial []byte   // line without a newline yet
+	total   int      // lines written
+}
+
+func newTailBuffer(n int) *tailBuffer {
+	return &tailBuffer{lines: make([]string, 0, n)}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for _, c := range p {
+		t.partial = append(t.partial, c)
+		if c == '\n' {
+			t.add(string(t.partial))
+			t.partial = t.partial[:0]
+		}
+	}
+	return len(p), nil
+}
+
+func (t *tailBuffer) add(line string) {
+	t.total++
+	if len(t.lines) < cap(t.lines) {
+		t.lines = append(t.lines, line)
+		return
+	}
+	t.lines[t.next] = line
+	t.next = (t.next + 1) % len(t.lines)
+}
+
+// tail returns the kept lines, oldest first, and the number of lines written.
+// A last line without a newline is included.
+func (t *tailBuffer) tail() ([]string, int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	out := make([]string, 0, len(t.lines)+1)
+	out = append(out, t.lines[t.next:]...)
+	out = append(out, t.lines[:t.next]...)
+	total := t.total
+	if len(t.partial) > 0 {
+		out = append(out, string(t.partial)+"\n")
+		total++
+	}
+	return out, total
+}
+
+// tailViewer shows the --tail-buffer of contexts on request.
+type tailViewer struct {
+	contexts []string
+	tails    []*tailBuffer
+	out      io.Writer
+}
+
+// dump writes the kept lines of the ith context to out.
+func (v tailViewer) dump(i int) {
+	lines, total := v.tails[i].tail()
+	var b strings.Builder
+	b.WriteString(gray(fmt.Sprintf("--- %s: last %d of %d line(s) ---", v.contexts[i], len(lines), total)) + "\n")
+	for _, l := range lines {
+		b.WriteString(l)
+	}
+	_, _ = io.WriteString(v.out, b.String())
+}
+
+// dumpAll writes the kept lines of contexts with output to out.
+func (v tailViewer) dumpAll() {
+	for i := range v.contexts {
+		if _, total := v.tails[i].tail(); total > 0 {
+			v.dump(i)
+		}
+	}
+}
+
+// find returns the index of the context named name, or the only context
+// starting with name.
+func (v tailViewer) find(name string) (int, error) {
+	var matches []int
+	for i, c := range v.contexts {
+		if c == name {
+			return i, nil
+		}
+		if strings.HasPrefix(c, name) {
+			matches = append(matches, i)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return 0, fmt.Errorf("no context %q", name)
+	case 1:
+		return matches[0], nil
+	default:
+		names := make([]string, len(matches))
+		for i, m := range matches {
+			names[i] = v.contexts[m]
+		}
+		sort.Strings(names)
+		return 0, fmt.Errorf("%q matches %d contexts: %s", name, len(matches), strings.Join(names, ", "))
+	}
+}
+
+// watch reads context names from r, one per line, and shows their kept lines
+// until done is closed. An empty line lists the contexts.
+func (v tailViewer) watch(r io.Reader, done <-chan struct{}) {
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		name := strings.TrimSpace(s.Text())
+		if name == "" {
+			v.list()
+			continue
+		}
+		i, err := v.find(name)
+		if err != nil {
+			fmt.Fprintln(v.out, red("error: ")+err.Error())
+			continue
+		}
+		v.dump(i)
+	}
+}
+
+func (v tailViewer) list() {
+	var b strings.Builder
+	b.WriteString(gray("contexts (type a name or its start to show the last lines):") + "\n")
+	for i, c := range v.contexts {
+		_, total := v.tails[i].tail()
+		fmt.Fprintf(&b, "  %s (%d line(s))\n", c, total)
+	}
+	_, _ = io.WriteString(v.out, b.String())
+}
@@ -0,0 +1,81 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/jwalton/gchalk"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_tailBuffer(t *testing.T) {
+	b := newTailBuffer(2)
+	lines, total := b.tail()
+	assert.Empty(t, lines)
+	assert.Equal(t, 0, total)
+
+	io.WriteString(b, "a\nb")
+	lines, total = b.tail()
+	assert.Equal(t, []string{"a\n", "b\n"}, lines, "partial last line")
+	assert.Equal(t, 2, total)
+
+	io.WriteString(b, "\nc\nd\ne\n")
+	lines, total = b.tail()
+	assert.Equal(t, []string{"d\n", "e\n"}, lines)
+	assert.Equal(t, 5, total)
+}
+
+func Test_tailViewer(t *testing.T) {
+	defer func(l gchalk.ColorLevel) { chalk.SetLevel(l) }(chalk.GetLevel())
+	chalk.SetLevel(gchalk.LevelNone)
+
+	var out strings.Builder
+	v := tailViewer{contexts: []string{"prod-eu", "prod-us", "dev"}, out: &out}
+	for range v.contexts {
+		v.tails = append(v.tails, newTailBuffer(1))
+	}
+	io.WriteString(v.tails[0], "x\ny\n")
+
+	i, err := v.find("dev")
+	require.NoError(t, err)
+	assert.Equal(t, 2, i)
+	i, err = v.find("prod-e")
+	require.NoError(t, err)
+	assert.Equal(t, 0, i)
+	_, err = v.find("prod")
+	assert.EqualError(t, err, `"prod" matches 2 contexts: prod-eu, prod-us`)
+	_, err = v.find("staging")
+	assert.EqualError(t, err, `no context "staging"`)
+
+	done := make(chan struct{})
+	v.watch(strings.NewReader("prod-eu\n\nnope\n"), done)
+	assert.Equal(t, "--- prod-eu: last 1 of 2 line(s) ---\ny\n"+
+		"contexts (type a name or its start to show the last lines):\n"+
+		"  prod-eu (2 line(s))\n  prod-us (0 line(s))\n  dev (0 line(s))\n"+
+		`error: no context "nope"`+"\n", out.String())
+
+	out.Reset()
+	v.dumpAll()
+	assert.Equal(t, "--- prod-eu: last 1 of 2 line(s) ---\ny\n", out.String(), "only contexts with output")
+
+	out.Reset()
+	close(done)
+	v.watch(strings.NewReader("prod-eu\n"), done)
+	assert.Empty(t, out.String(), "stopped")
+}
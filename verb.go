@@ -0,0 +1,113 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// valueFlags are the global flags of kubectl that take a value, which may be
+// given as the next argument (e.g. "-n foo").
+var valueFlags = map[string]bool{
+	"--as": true, "--as-group": true, "--as-uid": true, "--cache-dir": true,
+	"--certificate-authority": true, "--client-certificate": true, "--client-key": true,
+	"--cluster": true, "--context": true, "--kubeconfig": true, "-n": true, "--namespace": true,
+	"--profile": true, "--profile-output": true, "--request-timeout": true, "-s": true,
+	"--server": true, "--tls-server-name": true, "--token": true, "--user": true, "-v": true,
+}
+
+// kubectlVerb returns the subcommand in the arguments to kubectl (such as
+// "get" or "apply"), or an empty string if there is none.
+func kubectlVerb(args []string) string {
+	for i := 0; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			return ""
+		}
+		if !strings.HasPrefix(a, "-") {
+			return a
+		}
+		if valueFlags[a] {
+			i++ // skip the value
+		}
+	}
+	return ""
+}
+
+// mutatingVerbs are the kubectl subcommands that can modify cluster state.
+var mutatingVerbs = map[string]bool{
+	"annotate": true, "apply": true, "autoscale": true, "cordon": true, "create": true,
+	"delete": true, "drain": true, "edit": true, "expose": true, "label": true,
+	"patch": true, "replace": true, "rollout": true, "run": true, "scale": true,
+	"set": true, "taint": true, "uncordon": true,
+}
+
+// isMutating reports whether the arguments to kubectl run a command that can
+// modify cluster state.
+func isMutating(args []string) bool {
+	return mutatingVerbs[kubectlVerb(args)]
+}
+
+// checkVerb returns an error if the verb of the arguments to kubectl is not in
+// allowed.
+func checkVerb(args, allowed []string) error {
+	verb := kubectlVerb(args)
+	for _, v := range allowed {
+		if v == verb {
+			return nil
+		}
+	}
+	if verb == "" {
+		return fmt.Errorf("kubectl command without a verb is not allowed (allowed verbs: %s)", strings.Join(allowed, ", "))
+	}
+	return fmt.Errorf("kubectl verb %q is not allowed (allowed verbs: %s)", verb, strings.Join(allowed, ", "))
+}
+
+// withFlag returns argMaker with flag added to the arguments to kubectl, before
+// a "--" if there is one (e.g. "exec pod -- cmd").
+func withFlag(argMaker func(string) []string, flag string) func(string) []string {
+	return func(ctx string) []string {
+		args := argMaker(ctx)
+		out := make([]string, 0, len(args)+1)
+		for i, a := range args {
+			if a == "--" {
+				out = append(out, flag)
+				return append(out, args[i:]...)
+			}
+			out = append(out, a)
+		}
+		return append(out, flag)
+	}
+}
+
+// hasDryRun reports whether the arguments to kubectl have a --dry-run flag.
+func hasDryRun(args []string) bool {
+	return hasFlag(args, "--dry-run")
+}
+
+// hasFlag reports whether the arguments to kubectl have the flag (e.g.
+// "--dry-run"), with or without a value after "=", before any "--".
+func hasFlag(args []string, flag string) bool {
+	for _, a := range args {
+		if a == "--" {
+			return false
+		}
+		if a == flag || strings.HasPrefix(a, flag+"=") {
+			return true
+		}
+	}
+	return false
+}
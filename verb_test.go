@@ -0,0 +1,69 @@
+// Copyright 2022 Twitter, Inc.
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/ahmetb/kubectl-foreach/pkg/foreach"
+	"github.com/stretchr/testify/assert"
+)
+
+func Test_kubectlVerb(t *testing.T) {
+	assert.Equal(t, "", kubectlVerb(nil))
+	assert.Equal(t, "get", kubectlVerb([]string{"get", "pods"}))
+	assert.Equal(t, "get", kubectlVerb([]string{"--context=a", "get", "pods"}))
+	assert.Equal(t, "apply", kubectlVerb([]string{"-n", "foo", "--context", "a", "apply", "-f", "x.yaml"}))
+	assert.Equal(t, "delete", kubectlVerb([]string{"--namespace=foo", "-v", "6", "delete", "pod", "x"}))
+	assert.Equal(t, "", kubectlVerb([]string{"--context=a", "--", "get"}))
+	assert.Equal(t, "", kubectlVerb([]string{"-n"}))
+}
+
+func Test_isMutating(t *testing.T) {
+	assert.False(t, isMutating(nil))
+	assert.False(t, isMutating([]string{"get", "pods"}))
+	assert.False(t, isMutating([]string{"-n", "delete", "get", "pods"}))
+	assert.True(t, isMutating([]string{"--context=a", "delete", "pod", "foo"}))
+	assert.True(t, isMutating([]string{"apply", "-f", "x.yaml"}))
+}
+
+func Test_checkVerb(t *testing.T) {
+	allowed := []string{"get", "describe"}
+	assert.NoError(t, checkVerb([]string{"-n", "foo", "get", "pods"}, allowed))
+	assert.EqualError(t, checkVerb([]string{"delete", "pod", "x"}, allowed), `kubectl verb "delete" is not allowed (allowed verbs: get, describe)`)
+	assert.Error(t, checkVerb([]string{"--version"}, allowed))
+}
+
+func Test_withFlag(t *testing.T) {
+	argMaker := withFlag(foreach.ReplaceArgs([]string{"apply", "-f", "x.yaml"}, ""), "--dry-run=server")
+	assert.Equal(t, []string{"--context=a", "apply", "-f", "x.yaml", "--dry-run=server"}, argMaker("a"))
+
+	argMaker = withFlag(foreach.ReplaceArgs([]string{"exec", "p", "--", "rm", "-rf", "/tmp/x"}, ""), "--dry-run=server")
+	assert.Equal(t, []string{"--context=a", "exec", "p", "--dry-run=server", "--", "rm", "-rf", "/tmp/x"}, argMaker("a"))
+}
+
+func Test_hasFlag(t *testing.T) {
+	assert.True(t, hasFlag([]string{"get", "--kubeconfig=x"}, "--kubeconfig"))
+	assert.True(t, hasFlag([]string{"get", "--kubeconfig", "x"}, "--kubeconfig"))
+	assert.False(t, hasFlag([]string{"get", "--kubeconfigs=x"}, "--kubeconfig"))
+	assert.False(t, hasFlag([]string{"exec", "p", "--", "--kubeconfig=x"}, "--kubeconfig"))
+}
+
+func Test_hasDryRun(t *testing.T) {
+	assert.False(t, hasDryRun([]string{"apply", "-f", "x"}))
+	assert.True(t, hasDryRun([]string{"apply", "--dry-run=client", "-f", "x"}))
+	assert.True(t, hasDryRun([]string{"delete", "--dry-run", "pod", "x"}))
+	assert.False(t, hasDryRun([]string{"exec", "p", "--", "tool", "--dry-run"}))
+}
@@ -0,0 +1,90 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// workerLimit is the value of -c, a number of parallel runs or a percentage of
+// the matched contexts. It implements flag.Value, and sets the number to n
+// right away, or once resolve is called with the number of matched contexts
+// for a percentage or for max.
+type workerLimit struct {
+	n       *int
+	percent float64
+	set     bool // -c is given
+	max     int  // from --max-procs, used if -c is not given (0: unlimited)
+}
+
+func (w workerLimit) String() string {
+	if w.percent > 0 {
+		return strconv.FormatFloat(w.percent, 'f', -1, 64) + "%"
+	}
+	if w.n == nil {
+		return "0"
+	}
+	return strconv.Itoa(*w.n)
+}
+
+func (w *workerLimit) Set(v string) error {
+	w.set = true
+	if p := strings.TrimSuffix(v, "%"); p != v {
+		f, err := strconv.ParseFloat(p, 64)
+		if err != nil || f <= 0 || f > 100 {
+			return fmt.Errorf("invalid percentage %q: need more than 0%% and up to 100%%", v)
+		}
+		w.percent = f
+		return nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n < 0 {
+		return fmt.Errorf("invalid number %q: need 0 (unlimited) or more, or a percentage", v)
+	}
+	*w.n, w.percent = n, 0
+	return nil
+}
+
+// resolve sets the number of parallel runs from the percentage, if set, of
+// count contexts, rounded up and at least 1. Without -c, it's set to max.
+func (w workerLimit) resolve(count int) {
+	if !w.set && w.max > 0 {
+		*w.n = w.max
+	}
+	if w.percent == 0 {
+		return
+	}
+	n := int(math.Ceil(w.percent * float64(count) / 100))
+	if n < 1 {
+		n = 1
+	}
+	*w.n = n
+}
+
+// parseMaxProcs parses the value of --max-procs, "auto" for 4 runs per CPU of
+// cpus, or a positive number.
+func parseMaxProcs(v string, cpus int) (int, error) {
+	if v == "auto" {
+		return 4 * cpus, nil
+	}
+	n, err := strconv.Atoi(v)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("invalid --max-procs %q: need auto or a positive number", v)
+	}
+	return n, nil
+}
@@ -0,0 +1,88 @@
+// Copyright 2022 Twitter, Inc
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//      http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func Test_workerLimit(t *testing.T) {
+	var n int
+	w := workerLimit{n: &n}
+	assert.Equal(t, "0", w.String())
+
+	require.NoError(t, w.Set("5"))
+	assert.Equal(t, 5, n)
+	assert.Equal(t, "5", w.String())
+	w.resolve(100)
+	assert.Equal(t, 5, n, "a number doesn't change with the matched contexts")
+
+	require.NoError(t, w.Set("50%"))
+	assert.Equal(t, "50%", w.String())
+	for count, want := range map[int]int{0: 1, 1: 1, 3: 2, 4: 2, 300: 150} {
+		w.resolve(count)
+		assert.Equal(t, want, n, "50%% of %d", count)
+	}
+	require.NoError(t, w.Set("10%"))
+	w.resolve(30)
+	assert.Equal(t, 3, n)
+	w.resolve(31)
+	assert.Equal(t, 4, n, "rounded up")
+	require.NoError(t, w.Set("100%"))
+	w.resolve(7)
+	assert.Equal(t, 7, n)
+
+	require.NoError(t, w.Set("0"))
+	w.resolve(10)
+	assert.Equal(t, 0, n, "back to unlimited")
+
+	for _, v := range []string{"-1", "x", "1.5", "0%", "-5%", "101%", "%", "a%"} {
+		assert.Error(t, w.Set(v), v)
+	}
+}
+
+func Test_workerLimit_max(t *testing.T) {
+	var n int
+	w := workerLimit{n: &n, max: 8}
+	w.resolve(300)
+	assert.Equal(t, 8, n, "without -c")
+
+	require.NoError(t, w.Set("3"))
+	w.resolve(300)
+	assert.Equal(t, 3, n, "-c overrides --max-procs")
+	require.NoError(t, w.Set("0"))
+	w.resolve(300)
+	assert.Equal(t, 0, n, "even if unlimited")
+	require.NoError(t, w.Set("50%"))
+	w.resolve(300)
+	assert.Equal(t, 150, n)
+}
+
+func Test_parseMaxProcs(t *testing.T) {
+	n, err := parseMaxProcs("auto", 8)
+	require.NoError(t, err)
+	assert.Equal(t, 32, n)
+	n, err = parseMaxProcs("20", 8)
+	require.NoError(t, err)
+	assert.Equal(t, 20, n)
+
+	for _, v := range []string{"", "0", "-1", "x", "Auto"} {
+		_, err := parseMaxProcs(v, 8)
+		assert.Error(t, err, v)
+	}
+}